@@ -0,0 +1,255 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFailed 下载没有处于error状态 不需要重试
+var ErrNotFailed = errors.New("aria2: download is not in error state")
+
+// AutoRetryEvent 每次重试或放弃时传给OnRetry的信息
+type AutoRetryEvent struct {
+	Gid     string    // 失败的下载
+	Origin  string    // 最初的下载
+	NewGid  string    // 重新添加后的GID 放弃或失败时为空
+	Attempt int       // 第几次重试 从1开始
+	Code    ErrorCode // 失败的原因
+	Uris    []string  // 重新添加使用的uri
+	Delay   time.Duration
+	Err     error  // 重新添加失败时的错误
+	Reason  string // 放弃时的原因 "not retryable" "max attempts" "context done"
+}
+
+// AutoRetry 下载以error状态结束时 按errorCode判断能否重试 等待Backoff后用原来的uri和选项(包括dir)重新添加
+// 新旧GID的关系记录在lineage里 重试次数按最初的下载累计
+// 放弃重试之后(OnRetry返回后)以及Attach时收到完成或删除的通知时删除整条lineage 没有Attach时需要自己调用Forget
+//
+//	retry := aria2.NewAutoRetry(client)
+//	retry.Mirrors = aria2.RotateMirrors("https://mirror2/file.iso", "https://mirror3/file.iso")
+//	retry.Attach()
+type AutoRetry struct {
+	MaxAttempts int     // 最多重试次数 默认3
+	Backoff     Backoff // 第n次重试前等待Backoff.Delay(n) 默认30秒起每次翻倍 最多10分钟
+	// Retryable 判断能否重试 nil时使用ErrorCode.Retryable
+	Retryable func(status DownloadStatus) bool
+	// Mirrors 返回第attempt次重试使用的uri uris是失败的下载原来的uri nil时不换uri
+	Mirrors func(uris []string, attempt int) []string
+	Clock   Clock // 默认SystemClock
+	OnRetry func(event AutoRetryEvent)
+
+	client   *Aria2Client
+	lock     sync.Mutex
+	parent   map[string]string // 新GID -> 旧GID
+	attempts map[string]int    // 最初的GID -> 已经重试的次数
+	attached bool
+}
+
+// NewAutoRetry 使用默认设置 需要调用Attach或者自己调用Retry
+func NewAutoRetry(client *Aria2Client) *AutoRetry {
+	return &AutoRetry{
+		MaxAttempts: 3,
+		Backoff:     Backoff{Initial: 30 * time.Second, Max: 10 * time.Minute, Multiplier: 2, Jitter: 0.1},
+		Clock:       SystemClock,
+		client:      client,
+		parent:      make(map[string]string),
+		attempts:    make(map[string]int),
+	}
+}
+
+// RotateMirrors 把mirrors加到原来的uri后面 每次重试轮换一位 让aria2先连接不同的服务器
+func RotateMirrors(mirrors ...string) func(uris []string, attempt int) []string {
+	return func(uris []string, attempt int) []string {
+		all := make([]string, 0, len(uris)+len(mirrors))
+		seen := make(map[string]bool)
+		for _, list := range [][]string{uris, mirrors} {
+			for _, uri := range list {
+				if !seen[uri] {
+					seen[uri] = true
+					all = append(all, uri)
+				}
+			}
+		}
+		if len(all) == 0 {
+			return all
+		}
+		shift := attempt % len(all)
+		return append(all[shift:], all[:shift]...)
+	}
+}
+
+// Attach 注册onDownloadError回调 在新的goroutine里调用Retry 只需要调用一次
+// 同时注册onDownloadComplete和onDownloadStop回调 下载结束后忘掉它的lineage
+func (self *AutoRetry) Attach() {
+	self.lock.Lock()
+	if self.attached {
+		self.lock.Unlock()
+		return
+	}
+	self.attached = true
+	self.lock.Unlock()
+	self.client.OnDownloadError(func(client *Aria2Client, data RpcRequest) {
+		if gid := notificationGid(data); gid != "" {
+			go self.Retry(context.Background(), gid)
+		}
+	})
+	finished := func(client *Aria2Client, data RpcRequest) {
+		if gid := notificationGid(data); gid != "" {
+			self.finish(gid)
+		}
+	}
+	self.client.OnDownloadComplete(finished)
+	self.client.OnDownloadStop(finished)
+}
+
+// Origin 返回gid最初的下载 没有重试过时就是gid本身
+func (self *AutoRetry) Origin(gid string) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.origin(gid)
+}
+
+func (self *AutoRetry) origin(gid string) string {
+	for {
+		parent, ok := self.parent[gid]
+		if !ok {
+			return gid
+		}
+		gid = parent
+	}
+}
+
+// Lineage 返回从最初的下载到gid的所有GID
+func (self *AutoRetry) Lineage(gid string) []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	lineage := []string{gid}
+	for {
+		parent, ok := self.parent[gid]
+		if !ok {
+			break
+		}
+		lineage = append([]string{parent}, lineage...)
+		gid = parent
+	}
+	return lineage
+}
+
+// Attempts 返回gid所属的下载已经重试的次数
+func (self *AutoRetry) Attempts(gid string) int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.attempts[self.origin(gid)]
+}
+
+// Forget 删除gid所在的整条lineage
+func (self *AutoRetry) Forget(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.forget(gid)
+}
+
+// finish gid完成或被删除 已经被重试替代的旧GID不影响lineage
+func (self *AutoRetry) finish(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, parent := range self.parent {
+		if parent == gid {
+			return
+		}
+	}
+	self.forget(gid)
+}
+
+func (self *AutoRetry) forget(gid string) {
+	origin := self.origin(gid)
+	delete(self.attempts, origin)
+	lineage := make([]string, 0)
+	for child := range self.parent {
+		if self.origin(child) == origin {
+			lineage = append(lineage, child)
+		}
+	}
+	for _, child := range lineage {
+		delete(self.parent, child)
+	}
+}
+
+func (self *AutoRetry) emit(event AutoRetryEvent) {
+	if self.OnRetry != nil {
+		self.OnRetry(event)
+	}
+}
+
+// Retry 判断gid能否重试 可以的话等待Backoff后重新添加 返回新的GID
+// 不能重试时返回*DownloadError 下载没有失败时返回ErrNotFailed 放弃或等待时ctx被取消都会清理lineage
+func (self *AutoRetry) Retry(ctx context.Context, gid string) (string, error) {
+	client := self.client.WithContext(ctx)
+	status, err := client.tellStatus(gid, &[]string{"gid", "status", "errorCode", "errorMessage", "files", "infoHash", "bittorrent"})
+	if err != nil {
+		return "", err
+	}
+	if status.Status != StatusError {
+		return "", ErrNotFailed
+	}
+	self.lock.Lock()
+	origin := self.origin(gid)
+	attempt := self.attempts[origin] + 1
+	self.lock.Unlock()
+
+	event := AutoRetryEvent{Gid: gid, Origin: origin, Attempt: attempt, Code: status.ErrorCode}
+	retryable := self.Retryable
+	if retryable == nil {
+		retryable = func(status DownloadStatus) bool { return status.ErrorCode.Retryable() }
+	}
+	maxAttempts := self.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	switch {
+	case !retryable(status):
+		event.Reason = "not retryable"
+	case attempt > maxAttempts:
+		event.Reason = "max attempts"
+	}
+	if event.Reason != "" {
+		self.emit(event)
+		self.Forget(gid)
+		return "", status.Err()
+	}
+
+	backoff := self.Backoff
+	if backoff == (Backoff{}) {
+		backoff = DefaultBackoff
+	}
+	event.Delay = backoff.Delay(attempt)
+	clock := self.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	select {
+	case <-ctx.Done():
+		event.Reason = "context done"
+		self.emit(event)
+		self.Forget(gid)
+		return "", ctx.Err()
+	case <-clock.After(event.Delay):
+	}
+
+	uris := sourceUris(status)
+	if self.Mirrors != nil && status.InfoHash == "" {
+		uris = self.Mirrors(uris, attempt)
+	}
+	event.Uris = uris
+	event.NewGid, event.Err = client.resubmit(gid, uris, nil)
+	self.lock.Lock()
+	self.attempts[origin] = attempt
+	if event.NewGid != "" {
+		self.parent[event.NewGid] = gid
+	}
+	self.lock.Unlock()
+	self.emit(event)
+	return event.NewGid, event.Err
+}
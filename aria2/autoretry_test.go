@@ -0,0 +1,86 @@
+package aria2
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAutoRetryFinishPrunesLineage(t *testing.T) {
+	retry := NewAutoRetry(nil)
+	// a失败后重试成b b失败后重试成c
+	retry.parent["b"] = "a"
+	retry.parent["c"] = "b"
+	retry.attempts["a"] = 2
+	retry.parent["y"] = "x"
+	retry.attempts["x"] = 1
+
+	// 旧的GID结束不影响还在进行的重试
+	retry.finish("b")
+	if got := retry.Lineage("c"); len(got) != 3 {
+		t.Fatalf("lineage pruned by superseded gid: %v", got)
+	}
+	retry.finish("c")
+	if len(retry.parent) != 1 || len(retry.attempts) != 1 || retry.Attempts("c") != 0 {
+		t.Fatalf("lineage not pruned: parent=%v attempts=%v", retry.parent, retry.attempts)
+	}
+	if retry.Origin("y") != "x" {
+		t.Fatal("unrelated lineage pruned")
+	}
+}
+
+func TestAutoRetryGiveUpPrunesLineage(t *testing.T) {
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.tellStatus" {
+			return map[string]interface{}{"gid": params[0], "status": "error", "errorCode": "3", "errorMessage": "Resource not found"}, nil
+		}
+		return versionHandler(method, params)
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	retry := NewAutoRetry(client)
+	retry.Retryable = func(status DownloadStatus) bool { return false }
+	retry.parent["b"] = "a"
+	retry.attempts["a"] = 1
+	var lineage []string
+	retry.OnRetry = func(event AutoRetryEvent) {
+		// 放弃时OnRetry里还能读到lineage
+		lineage = retry.Lineage(event.Gid)
+	}
+	if _, err := retry.Retry(context.Background(), "b"); err == nil {
+		t.Fatal("expected download error")
+	}
+	if len(lineage) != 2 {
+		t.Fatalf("lineage in OnRetry = %v", lineage)
+	}
+	if len(retry.parent) != 0 || len(retry.attempts) != 0 {
+		t.Fatalf("lineage not pruned after giving up: parent=%v attempts=%v", retry.parent, retry.attempts)
+	}
+
+	// 等待重试时ctx被取消 同样放弃并清理lineage
+	retry.Retryable = func(status DownloadStatus) bool { return true }
+	retry.parent["d"] = "c"
+	retry.attempts["c"] = 1
+	var reason string
+	retry.OnRetry = func(event AutoRetryEvent) {
+		reason = event.Reason
+		lineage = retry.Lineage(event.Gid)
+	}
+	clock := NewManualClock(time.Now())
+	retry.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := retry.Retry(ctx, "d"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if reason != "context done" || len(lineage) != 2 {
+		t.Fatalf("reason %q lineage %v", reason, lineage)
+	}
+	if len(retry.parent) != 0 || len(retry.attempts) != 0 {
+		t.Fatalf("lineage not pruned after cancel: parent=%v attempts=%v", retry.parent, retry.attempts)
+	}
+}
@@ -0,0 +1,260 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// Weekdays 周一到周五
+	Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	// Weekends 周六和周日
+	Weekends = []time.Weekday{time.Saturday, time.Sunday}
+)
+
+// TimeOfDay 一天中的时刻 ClockTime(9, 30)表示09:30
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+}
+
+// ClockTime 构造TimeOfDay
+func ClockTime(hour int, minute int) TimeOfDay {
+	return TimeOfDay{Hour: hour, Minute: minute}
+}
+
+func (self TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", self.Hour, self.Minute)
+}
+
+func (self TimeOfDay) on(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, self.Hour, self.Minute, 0, 0, loc)
+}
+
+// BandwidthRule 在某些天的某个时间段内使用Options
+// End不晚于Start时表示跨过午夜 到第二天的End结束 Start和End相同表示全天 Days指开始的那一天
+type BandwidthRule struct {
+	Name     string
+	Days     []time.Weekday // 为空表示每天
+	Start    TimeOfDay
+	End      TimeOfDay
+	Location *time.Location // 为nil时使用BandwidthSchedule.Location
+	// Options 生效时通过changeGlobalOption下发的全局选项 例如max-overall-download-limit
+	Options map[string]string
+	// Priority 多条规则同时生效时取Priority最大的 相同时取时间段较短的 再相同时取靠前的
+	Priority int
+}
+
+func (self *BandwidthRule) onDay(day time.Weekday) bool {
+	if len(self.Days) == 0 {
+		return true
+	}
+	for _, d := range self.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// window 从date(所在时区的某一天)开始的时间段
+func (self *BandwidthRule) window(date time.Time) (time.Time, time.Time) {
+	y, m, d := date.Date()
+	loc := date.Location()
+	start := self.Start.on(y, m, d, loc)
+	end := self.End.on(y, m, d, loc)
+	if !end.After(start) {
+		end = self.End.on(y, m, d+1, loc)
+	}
+	return start, end
+}
+
+// BandwidthSchedule 按时间段切换全局限速等选项
+//
+//	schedule := aria2.NewBandwidthSchedule(client, map[string]string{"max-overall-download-limit": "0"},
+//		aria2.BandwidthRule{Days: aria2.Weekdays, Start: aria2.ClockTime(9, 0), End: aria2.ClockTime(18, 0),
+//			Options: map[string]string{"max-overall-download-limit": "2M"}})
+//	go schedule.Run(ctx)
+//
+// 每次下发前先读取aria2当前的全局选项 只修改不一致的项 所以aria2重启或者被别人改过之后再次Apply就能恢复
+type BandwidthSchedule struct {
+	Rules []BandwidthRule
+	// Default 没有规则生效时使用的选项 规则里出现的key都应该在这里给出 否则离开时间段后不会恢复
+	Default  map[string]string
+	Location *time.Location // 默认time.Local
+	Clock    Clock          // 默认SystemClock
+	// ResyncInterval Run在两次切换之间重新检查的间隔 用于发现aria2重启 默认5分钟
+	ResyncInterval time.Duration
+	// OnApply 每次Apply之后调用 rule为nil表示使用Default changed是实际修改的选项
+	OnApply func(rule *BandwidthRule, changed map[string]string, err error)
+
+	client  *Aria2Client
+	lock    sync.Mutex
+	trigger chan struct{}
+}
+
+// NewBandwidthSchedule 创建调度 需要调用Run或者自己定时调用Apply
+func NewBandwidthSchedule(client *Aria2Client, defaults map[string]string, rules ...BandwidthRule) *BandwidthSchedule {
+	return &BandwidthSchedule{
+		Rules:          rules,
+		Default:        defaults,
+		Location:       time.Local,
+		Clock:          SystemClock,
+		ResyncInterval: 5 * time.Minute,
+		client:         client,
+		trigger:        make(chan struct{}, 1),
+	}
+}
+
+func (self *BandwidthSchedule) locationOf(rule *BandwidthRule) *time.Location {
+	if rule.Location != nil {
+		return rule.Location
+	}
+	if self.Location != nil {
+		return self.Location
+	}
+	return time.Local
+}
+
+func (self *BandwidthSchedule) clock() Clock {
+	if self.Clock != nil {
+		return self.Clock
+	}
+	return SystemClock
+}
+
+// Active 返回t时刻生效的规则 没有时返回nil
+func (self *BandwidthSchedule) Active(t time.Time) *BandwidthRule {
+	var best *BandwidthRule
+	var bestLength time.Duration
+	for i := range self.Rules {
+		rule := &self.Rules[i]
+		local := t.In(self.locationOf(rule))
+		for _, offset := range []int{-1, 0} {
+			date := local.AddDate(0, 0, offset)
+			if !rule.onDay(date.Weekday()) {
+				continue
+			}
+			start, end := rule.window(date)
+			if t.Before(start) || !t.Before(end) {
+				continue
+			}
+			length := end.Sub(start)
+			if best == nil || rule.Priority > best.Priority || (rule.Priority == best.Priority && length < bestLength) {
+				best, bestLength = rule, length
+			}
+		}
+	}
+	return best
+}
+
+// Options t时刻应该使用的选项 规则的选项覆盖Default
+func (self *BandwidthSchedule) Options(t time.Time) (*BandwidthRule, map[string]string) {
+	options := make(map[string]string, len(self.Default))
+	for k, v := range self.Default {
+		options[k] = v
+	}
+	rule := self.Active(t)
+	if rule != nil {
+		for k, v := range rule.Options {
+			options[k] = v
+		}
+	}
+	return rule, options
+}
+
+// NextChange t之后第一个可能切换规则的时刻 没有规则时返回零值
+func (self *BandwidthSchedule) NextChange(t time.Time) time.Time {
+	var next time.Time
+	for i := range self.Rules {
+		rule := &self.Rules[i]
+		local := t.In(self.locationOf(rule))
+		for offset := -1; offset <= 8; offset++ {
+			date := local.AddDate(0, 0, offset)
+			if !rule.onDay(date.Weekday()) {
+				continue
+			}
+			start, end := rule.window(date)
+			for _, edge := range []time.Time{start, end} {
+				if edge.After(t) && (next.IsZero() || edge.Before(next)) {
+					next = edge
+				}
+			}
+		}
+	}
+	return next
+}
+
+// Apply 读取aria2当前的全局选项 把和当前时刻应有的值不一致的项改掉
+func (self *BandwidthSchedule) Apply(ctx context.Context) (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	rule, options := self.Options(self.clock().Now())
+	changed := make(map[string]string)
+	defer func() {
+		if self.OnApply != nil {
+			self.OnApply(rule, changed, err)
+		}
+	}()
+	client := self.client.WithContext(ctx)
+	res, err := client.GetGlobalOption()
+	if err != nil {
+		return err
+	}
+	live, _ := res.(map[string]interface{})
+	update := make(map[string]interface{})
+	for k, v := range options {
+		if current, ok := live[k]; ok && sameOption(fmt.Sprint(current), v) {
+			continue
+		}
+		update[k] = v
+		changed[k] = v
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	_, err = client.ChangeGlobalOption(update)
+	return err
+}
+
+// sameOption 大小类的值按字节数比较 2M和2097152相同
+func sameOption(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	x, err1 := ParseSize(a)
+	y, err2 := ParseSize(b)
+	return err1 == nil && err2 == nil && x == y
+}
+
+// Reapply 让Run立即重新Apply 可以设置为WebsocketRequestHandler.OnReconnect
+func (self *BandwidthSchedule) Reapply() {
+	select {
+	case self.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run 立即Apply 之后在每个切换时刻、每隔ResyncInterval以及调用Reapply时再次Apply 直到ctx结束
+// Apply出错不会退出 等下一次时机再试
+func (self *BandwidthSchedule) Run(ctx context.Context) error {
+	for {
+		self.Apply(ctx)
+		now := self.clock().Now()
+		wait := self.ResyncInterval
+		if wait <= 0 {
+			wait = 5 * time.Minute
+		}
+		if next := self.NextChange(now); !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-self.clock().After(wait):
+		case <-self.trigger:
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 2024-01-05是周五
+func friday(hour int, minute int, loc *time.Location) time.Time {
+	return time.Date(2024, 1, 5, hour, minute, 0, 0, loc)
+}
+
+func ruleName(rule *BandwidthRule) string {
+	if rule == nil {
+		return "<default>"
+	}
+	return rule.Name
+}
+
+func TestBandwidthActiveOverlap(t *testing.T) {
+	schedule := NewBandwidthSchedule(nil, nil,
+		BandwidthRule{Name: "work", Start: ClockTime(9, 0), End: ClockTime(18, 0)},
+		BandwidthRule{Name: "lunch", Start: ClockTime(12, 0), End: ClockTime(13, 0)},
+		BandwidthRule{Name: "backup", Start: ClockTime(17, 0), End: ClockTime(20, 0), Priority: 1},
+	)
+	schedule.Location = time.UTC
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		{friday(8, 59, time.UTC), "<default>"},
+		{friday(9, 0, time.UTC), "work"},
+		{friday(12, 30, time.UTC), "lunch"},
+		{friday(13, 0, time.UTC), "work"},
+		{friday(17, 30, time.UTC), "backup"},
+		{friday(19, 59, time.UTC), "backup"},
+		{friday(20, 0, time.UTC), "<default>"},
+	}
+	for _, c := range cases {
+		if got := ruleName(schedule.Active(c.at)); got != c.want {
+			t.Errorf("Active(%s) = %s, want %s", c.at.Format("15:04"), got, c.want)
+		}
+	}
+	if next := schedule.NextChange(friday(12, 30, time.UTC)); !next.Equal(friday(13, 0, time.UTC)) {
+		t.Errorf("NextChange = %s", next)
+	}
+	if next := schedule.NextChange(friday(13, 0, time.UTC)); !next.Equal(friday(17, 0, time.UTC)) {
+		t.Errorf("NextChange = %s", next)
+	}
+}
+
+func TestBandwidthCrossMidnight(t *testing.T) {
+	schedule := NewBandwidthSchedule(nil, nil,
+		BandwidthRule{Name: "night", Days: []time.Weekday{time.Friday}, Start: ClockTime(22, 0), End: ClockTime(6, 0)},
+	)
+	schedule.Location = time.UTC
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		// 周五凌晨属于周四开始的时间段 周四不在Days里
+		{friday(3, 0, time.UTC), "<default>"},
+		{friday(21, 59, time.UTC), "<default>"},
+		{friday(22, 0, time.UTC), "night"},
+		{friday(23, 30, time.UTC), "night"},
+		{friday(24+5, 59, time.UTC), "night"},
+		{friday(24+6, 0, time.UTC), "<default>"},
+		{friday(24+23, 0, time.UTC), "<default>"},
+	}
+	for _, c := range cases {
+		if got := ruleName(schedule.Active(c.at)); got != c.want {
+			t.Errorf("Active(%s) = %s, want %s", c.at.Format("Mon 15:04"), got, c.want)
+		}
+	}
+	if next := schedule.NextChange(friday(20, 0, time.UTC)); !next.Equal(friday(22, 0, time.UTC)) {
+		t.Errorf("NextChange before window = %s", next)
+	}
+	if next := schedule.NextChange(friday(23, 0, time.UTC)); !next.Equal(friday(24+6, 0, time.UTC)) {
+		t.Errorf("NextChange inside window = %s", next)
+	}
+	// 下一个周五的22:00
+	if next := schedule.NextChange(friday(24+6, 0, time.UTC)); !next.Equal(friday(7*24+22, 0, time.UTC)) {
+		t.Errorf("NextChange after window = %s", next)
+	}
+}
+
+func TestBandwidthLocation(t *testing.T) {
+	shanghai := time.FixedZone("UTC+8", 8*60*60)
+	schedule := NewBandwidthSchedule(nil, nil,
+		BandwidthRule{Name: "office", Days: Weekdays, Start: ClockTime(9, 0), End: ClockTime(18, 0), Location: shanghai},
+	)
+	schedule.Location = time.UTC
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		{friday(0, 59, time.UTC), "<default>"},
+		{friday(1, 0, time.UTC), "office"},
+		{friday(9, 59, time.UTC), "office"},
+		{friday(10, 0, time.UTC), "<default>"},
+		// 周五UTC 20:00在UTC+8已经是周六
+		{friday(20, 0, time.UTC), "<default>"},
+		// 周日UTC 23:00在UTC+8是周一07:00 周一UTC 01:00是周一09:00
+		{friday(2*24+23, 0, time.UTC), "<default>"},
+		{friday(3*24+1, 0, time.UTC), "office"},
+	}
+	for _, c := range cases {
+		if got := ruleName(schedule.Active(c.at)); got != c.want {
+			t.Errorf("Active(%s) = %s, want %s", c.at.Format("Mon 15:04 MST"), got, c.want)
+		}
+	}
+	if next := schedule.NextChange(friday(0, 0, time.UTC)); !next.Equal(friday(1, 0, time.UTC)) {
+		t.Errorf("NextChange = %s", next)
+	}
+	// 规则没有Location时使用BandwidthSchedule.Location
+	schedule.Rules[0].Location = nil
+	schedule.Location = shanghai
+	if got := ruleName(schedule.Active(friday(1, 0, time.UTC))); got != "office" {
+		t.Errorf("Active with schedule location = %s", got)
+	}
+}
+
+// fakeGlobalOptions 用一个map模拟getGlobalOption和changeGlobalOption
+type fakeGlobalOptions struct {
+	lock    sync.Mutex
+	options map[string]string
+	changes int
+}
+
+func (self *fakeGlobalOptions) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	switch method {
+	case "aria2.getGlobalOption":
+		res := make(map[string]interface{}, len(self.options))
+		for k, v := range self.options {
+			res[k] = v
+		}
+		return res, nil
+	case "aria2.changeGlobalOption":
+		self.changes++
+		for k, v := range params[0].(map[string]interface{}) {
+			size, err := ParseSize(fmt.Sprint(v))
+			if err != nil {
+				return nil, &ErrorMsg{Code: 1, Message: err.Error()}
+			}
+			// aria2返回的限速总是字节数
+			self.options[k] = fmt.Sprint(size)
+		}
+		return "OK", nil
+	}
+	return versionHandler(method, params)
+}
+
+func (self *fakeGlobalOptions) get(key string) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.options[key]
+}
+
+func (self *fakeGlobalOptions) set(key string, value string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.options[key] = value
+}
+
+func (self *fakeGlobalOptions) changeCount() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.changes
+}
+
+func TestBandwidthApply(t *testing.T) {
+	const key = "max-overall-download-limit"
+	global := &fakeGlobalOptions{options: map[string]string{key: "0"}}
+	fake := newFakeAria2(t, global.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	clock := NewManualClock(friday(8, 0, time.UTC))
+	schedule := NewBandwidthSchedule(client, map[string]string{key: "0"},
+		BandwidthRule{Name: "work", Start: ClockTime(9, 0), End: ClockTime(18, 0), Options: map[string]string{key: "2M"}},
+	)
+	schedule.Location = time.UTC
+	schedule.Clock = clock
+	var lastRule *BandwidthRule
+	var lastChanged map[string]string
+	schedule.OnApply = func(rule *BandwidthRule, changed map[string]string, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		lastRule, lastChanged = rule, changed
+	}
+	ctx := context.Background()
+
+	// 和默认值一致 不需要修改
+	if err := schedule.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if global.changeCount() != 0 || lastRule != nil || len(lastChanged) != 0 {
+		t.Fatalf("unexpected change before window: rule=%s changed=%v", ruleName(lastRule), lastChanged)
+	}
+
+	clock.Set(friday(9, 30, time.UTC))
+	if err := schedule.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ruleName(lastRule) != "work" || lastChanged[key] != "2M" || global.get(key) != "2097152" {
+		t.Fatalf("window not applied: rule=%s changed=%v live=%s", ruleName(lastRule), lastChanged, global.get(key))
+	}
+
+	// aria2返回2097152 和2M相同 不重复下发
+	if err := schedule.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if global.changeCount() != 1 || len(lastChanged) != 0 {
+		t.Fatalf("re-apply without change sent %d changes, changed=%v", global.changeCount(), lastChanged)
+	}
+
+	// 模拟aria2重启或者被别人改过 再次Apply恢复
+	global.set(key, "0")
+	if err := schedule.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if global.changeCount() != 2 || global.get(key) != "2097152" {
+		t.Fatalf("re-apply after external change: changes=%d live=%s", global.changeCount(), global.get(key))
+	}
+
+	clock.Set(friday(18, 0, time.UTC))
+	if err := schedule.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lastRule != nil || lastChanged[key] != "0" || global.get(key) != "0" {
+		t.Fatalf("default not restored: rule=%s changed=%v live=%s", ruleName(lastRule), lastChanged, global.get(key))
+	}
+}
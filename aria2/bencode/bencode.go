@@ -0,0 +1,288 @@
+// Package bencode BitTorrent使用的bencode编码
+//
+// 解码结果的类型: 整数int64 字符串string(可能是任意二进制) 列表[]interface{} 字典map[string]interface{}
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// RawMessage 一段未解码的bencode数据 编码时原样写出
+type RawMessage []byte
+
+// SyntaxError 数据格式错误 Offset是出错的位置
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (self *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", self.Msg, self.Offset)
+}
+
+// 防止恶意数据造成过深的递归
+const maxDepth = 512
+
+type decoder struct {
+	data  []byte
+	depth int
+}
+
+// Decode 解码一个完整的bencode值 末尾不能有多余数据
+// 只接受规范编码: 整数和字符串长度不能有前导0 字典的key必须按字节序严格递增
+func Decode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, end, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, &SyntaxError{Offset: end, Msg: "trailing data"}
+	}
+	return v, nil
+}
+
+// DecodeReader 读取全部数据后解码
+func DecodeReader(r io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// DecodeRawDict 把顶层字典拆成key到原始bencode数据的映射
+// 计算info-hash需要info字典未经修改的原始字节
+func DecodeRawDict(data []byte) (map[string]RawMessage, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, &SyntaxError{Offset: 0, Msg: "expected dictionary"}
+	}
+	d := &decoder{data: data}
+	raw := make(map[string]RawMessage)
+	pos := 1
+	last := ""
+	for first := true; ; first = false {
+		if pos >= len(data) {
+			return nil, &SyntaxError{Offset: pos, Msg: "unexpected end of data"}
+		}
+		if data[pos] == 'e' {
+			pos++
+			break
+		}
+		key, next, err := d.str(pos)
+		if err != nil {
+			return nil, err
+		}
+		if !first && key <= last {
+			return nil, &SyntaxError{Offset: pos, Msg: "dictionary keys not sorted"}
+		}
+		last = key
+		_, end, err := d.value(next)
+		if err != nil {
+			return nil, err
+		}
+		raw[key] = RawMessage(data[next:end])
+		pos = end
+	}
+	if pos != len(data) {
+		return nil, &SyntaxError{Offset: pos, Msg: "trailing data"}
+	}
+	return raw, nil
+}
+
+func (self *decoder) value(pos int) (interface{}, int, error) {
+	if pos >= len(self.data) {
+		return nil, pos, &SyntaxError{Offset: pos, Msg: "unexpected end of data"}
+	}
+	switch c := self.data[pos]; {
+	case c == 'i':
+		return self.integer(pos)
+	case c >= '0' && c <= '9':
+		return self.str(pos)
+	case c == 'l':
+		if self.depth++; self.depth > maxDepth {
+			return nil, pos, &SyntaxError{Offset: pos, Msg: "nested too deeply"}
+		}
+		defer func() { self.depth-- }()
+		list := make([]interface{}, 0)
+		pos++
+		for {
+			if pos >= len(self.data) {
+				return nil, pos, &SyntaxError{Offset: pos, Msg: "unexpected end of data"}
+			}
+			if self.data[pos] == 'e' {
+				return list, pos + 1, nil
+			}
+			v, next, err := self.value(pos)
+			if err != nil {
+				return nil, next, err
+			}
+			list = append(list, v)
+			pos = next
+		}
+	case c == 'd':
+		if self.depth++; self.depth > maxDepth {
+			return nil, pos, &SyntaxError{Offset: pos, Msg: "nested too deeply"}
+		}
+		defer func() { self.depth-- }()
+		dict := make(map[string]interface{})
+		last := ""
+		pos++
+		for first := true; ; first = false {
+			if pos >= len(self.data) {
+				return nil, pos, &SyntaxError{Offset: pos, Msg: "unexpected end of data"}
+			}
+			if self.data[pos] == 'e' {
+				return dict, pos + 1, nil
+			}
+			key, next, err := self.str(pos)
+			if err != nil {
+				return nil, next, err
+			}
+			if !first && key <= last {
+				return nil, pos, &SyntaxError{Offset: pos, Msg: "dictionary keys not sorted"}
+			}
+			last = key
+			v, next, err := self.value(next)
+			if err != nil {
+				return nil, next, err
+			}
+			dict[key] = v
+			pos = next
+		}
+	default:
+		return nil, pos, &SyntaxError{Offset: pos, Msg: fmt.Sprintf("unexpected byte %q", c)}
+	}
+}
+
+func (self *decoder) integer(pos int) (interface{}, int, error) {
+	end := bytes.IndexByte(self.data[pos:], 'e')
+	if end < 0 {
+		return nil, pos, &SyntaxError{Offset: pos, Msg: "unterminated integer"}
+	}
+	end += pos
+	digits := self.data[pos+1 : end]
+	if !canonical(bytes.TrimPrefix(digits, []byte("-"))) || string(digits) == "-0" {
+		return nil, pos, &SyntaxError{Offset: pos, Msg: "invalid integer"}
+	}
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return nil, pos, &SyntaxError{Offset: pos, Msg: "invalid integer"}
+	}
+	return n, end + 1, nil
+}
+
+// canonical 只包含数字 并且除了0本身之外不能以0开头
+func canonical(digits []byte) bool {
+	if len(digits) == 0 || (digits[0] == '0' && len(digits) > 1) {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *decoder) str(pos int) (string, int, error) {
+	colon := bytes.IndexByte(self.data[pos:], ':')
+	if colon < 0 {
+		return "", pos, &SyntaxError{Offset: pos, Msg: "expected string"}
+	}
+	colon += pos
+	if !canonical(self.data[pos:colon]) {
+		return "", pos, &SyntaxError{Offset: pos, Msg: "invalid string length"}
+	}
+	n, err := strconv.Atoi(string(self.data[pos:colon]))
+	if err != nil {
+		return "", pos, &SyntaxError{Offset: pos, Msg: "invalid string length"}
+	}
+	start := colon + 1
+	if n > len(self.data)-start {
+		return "", pos, &SyntaxError{Offset: pos, Msg: "string exceeds data"}
+	}
+	return string(self.data[start : start+n]), start + n, nil
+}
+
+// Encode 编码 支持整数、字符串、[]byte、RawMessage、切片以及key为string的map 字典按key排序
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case RawMessage:
+		buf.Write(v)
+		return nil
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.Write(v)
+		return nil
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.WriteString(v)
+		return nil
+	case bool:
+		if v {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteString("i" + strconv.FormatInt(rv.Int(), 10) + "e")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteString("i" + strconv.FormatUint(rv.Uint(), 10) + "e")
+	case reflect.String:
+		return encode(buf, rv.String())
+	case reflect.Slice, reflect.Array:
+		buf.WriteByte('l')
+		for i := 0; i < rv.Len(); i++ {
+			if err := encode(buf, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return errors.New("bencode: map key must be string")
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			encode(buf, key)
+			if err := encode(buf, rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).Interface()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return errors.New("bencode: cannot encode nil")
+		}
+		return encode(buf, rv.Elem().Interface())
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	v, err := Decode([]byte("d4:listli0ei-42e3:abce4:name4:spam3:numi1099511627776ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"list": []interface{}{int64(0), int64(-42), "abc"},
+		"name": "spam",
+		"num":  int64(1099511627776),
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v", v)
+	}
+	// 编码结果和输入一致
+	data, err := Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "d4:listli0ei-42e3:abce4:name4:spam3:numi1099511627776ee" {
+		t.Fatalf("encode: %s", data)
+	}
+}
+
+func TestDecodeRejectsMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"integer leading zero", "i03e"},
+		{"negative zero", "i-0e"},
+		{"negative leading zero", "i-03e"},
+		{"integer plus sign", "i+3e"},
+		{"empty integer", "ie"},
+		{"unterminated integer", "i42"},
+		{"length leading zero", "03:abc"},
+		{"length plus sign", "+3:abc"},
+		{"unterminated string", "5:abc"},
+		{"missing colon", "3abc"},
+		{"unsorted keys", "d1:bi1e1:ai2ee"},
+		{"duplicate keys", "d1:ai1e1:ai2ee"},
+		{"nested unsorted keys", "d1:ad1:zi1e1:yi2eee"},
+		{"unterminated list", "li1e"},
+		{"unterminated dict", "d1:ai1e"},
+		{"non-string key", "di1ei2ee"},
+		{"trailing data", "i1ei2e"},
+	}
+	for _, c := range cases {
+		if _, err := Decode([]byte(c.data)); err == nil {
+			t.Errorf("%s: %q decoded without error", c.name, c.data)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected *SyntaxError, got %T", c.name, err)
+		}
+	}
+}
+
+func TestDecodeRawDict(t *testing.T) {
+	raw, err := DecodeRawDict([]byte("d8:announce3:url4:infod6:lengthi5eee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw["info"]) != "d6:lengthi5ee" || string(raw["announce"]) != "3:url" {
+		t.Fatalf("got %q", raw)
+	}
+	for _, data := range []string{"d4:infoi1e8:announce3:urle", "d4:infoi1e4:infoi2ee", "d4:infoi01ee", "l4:infoe"} {
+		if _, err := DecodeRawDict([]byte(data)); err == nil {
+			t.Errorf("%q decoded without error", data)
+		}
+	}
+}
+
+func TestDecodeDepthLimit(t *testing.T) {
+	data := make([]byte, 0, 2*(maxDepth+1))
+	for i := 0; i <= maxDepth; i++ {
+		data = append(data, 'l')
+	}
+	for i := 0; i <= maxDepth; i++ {
+		data = append(data, 'e')
+	}
+	if _, err := Decode(data); err == nil {
+		t.Fatal("deeply nested data decoded")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data, err := Encode(map[string]interface{}{
+		"b":   []byte{0, 1},
+		"a":   []interface{}{uint8(7), true, RawMessage("i9e")},
+		"str": []string{"x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "d1:ali7ei1ei9ee1:b2:\x00\x013:strl1:xee" {
+		t.Fatalf("got %q", data)
+	}
+	if _, err := Encode(map[int]string{1: "a"}); err == nil {
+		t.Fatal("map with int keys encoded")
+	}
+	if _, err := Encode(3.5); err == nil {
+		t.Fatal("float encoded")
+	}
+}
@@ -0,0 +1,212 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultChunkSize 每个system.multicall包含的调用数
+const DefaultChunkSize = 100
+
+// Selector 选出要批量操作的下载
+type Selector interface {
+	Select(ctx context.Context) ([]string, error)
+}
+
+// GidList 直接给出GID列表的Selector
+type GidList []string
+
+func (self GidList) Select(ctx context.Context) ([]string, error) {
+	return self, nil
+}
+
+// Select 执行查询 只请求gid和过滤需要的字段 使Query可以作为Selector
+func (self *Query) Select(ctx context.Context) ([]string, error) {
+	statuses, err := self.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gids := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		gids = append(gids, status.Gid)
+	}
+	return gids, nil
+}
+
+// BulkResult 批量操作中一个GID的结果
+type BulkResult struct {
+	Gid    string
+	Result interface{}
+	Err    error
+}
+
+// BulkReport 批量操作的结果 Results与选出的GID顺序一致
+type BulkReport struct {
+	Results []BulkResult
+}
+
+// Succeeded 成功的GID
+func (self *BulkReport) Succeeded() []string {
+	gids := make([]string, 0, len(self.Results))
+	for _, result := range self.Results {
+		if result.Err == nil {
+			gids = append(gids, result.Gid)
+		}
+	}
+	return gids
+}
+
+// Failed 失败的项
+func (self *BulkReport) Failed() []BulkResult {
+	failed := make([]BulkResult, 0)
+	for _, result := range self.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err 全部成功时返回nil 否则返回汇总的错误
+func (self *BulkReport) Err() error {
+	failed := self.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("aria2: %d of %d calls failed, first: %s: %w", len(failed), len(self.Results), failed[0].Gid, failed[0].Err)
+}
+
+// Bulk 把对大量GID的同一种操作拆成若干个system.multicall发送
+type Bulk struct {
+	ChunkSize   int // 每个multicall的调用数 默认DefaultChunkSize
+	Concurrency int // 同时发送的multicall数 默认1
+
+	client *Aria2Client
+}
+
+// Bulk 创建批量操作
+func (self *Aria2Client) Bulk() *Bulk {
+	return &Bulk{ChunkSize: DefaultChunkSize, Concurrency: 1, client: self}
+}
+
+// Do 对选出的每个GID调用method(不带aria2.前缀) 参数是gid加上args
+func (self *Bulk) Do(ctx context.Context, selector Selector, method string, args ...interface{}) (*BulkReport, error) {
+	return self.do(ctx, selector, method, self.Concurrency, func(i int, gid string) []interface{} {
+		return append([]interface{}{gid}, args...)
+	})
+}
+
+func (self *Bulk) Pause(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "pause")
+}
+
+func (self *Bulk) ForcePause(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "forcePause")
+}
+
+func (self *Bulk) Unpause(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "unpause")
+}
+
+func (self *Bulk) Remove(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "remove")
+}
+
+func (self *Bulk) ForceRemove(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "forceRemove")
+}
+
+func (self *Bulk) RemoveDownloadResult(ctx context.Context, selector Selector) (*BulkReport, error) {
+	return self.Do(ctx, selector, "removeDownloadResult")
+}
+
+func (self *Bulk) ChangeOption(ctx context.Context, selector Selector, options map[string]interface{}) (*BulkReport, error) {
+	return self.Do(ctx, selector, "changeOption", options)
+}
+
+// ChangePosition 移动选出的下载 how为POS_SET时第i个GID移动到pos+i 移动后保持选出的顺序
+// 其余情况每个GID都按pos和how移动 顺序相关 所以总是逐个chunk依次发送
+func (self *Bulk) ChangePosition(ctx context.Context, selector Selector, pos int, how string) (*BulkReport, error) {
+	return self.do(ctx, selector, "changePosition", 1, func(i int, gid string) []interface{} {
+		if how == "POS_SET" {
+			return []interface{}{gid, pos + i, how}
+		}
+		return []interface{}{gid, pos, how}
+	})
+}
+
+func (self *Bulk) do(ctx context.Context, selector Selector, method string, concurrency int, params func(i int, gid string) []interface{}) (*BulkReport, error) {
+	gids, err := selector.Select(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := self.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	report := &BulkReport{Results: make([]BulkResult, len(gids))}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for start := 0; start < len(gids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(gids) {
+			end = len(gids)
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// 两个case同时就绪时select随机选择 ctx取消后剩下的块都不再发送
+		if ctx.Err() != nil {
+			for i := start; i < len(gids); i++ {
+				report.Results[i] = BulkResult{Gid: gids[i], Err: ctx.Err()}
+			}
+			wg.Wait()
+			return report, ctx.Err()
+		}
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			self.chunk(ctx, method, gids, start, end, params, report.Results)
+		}(start, end)
+	}
+	wg.Wait()
+	return report, nil
+}
+
+// chunk 用一个multicall处理gids[start:end] 结果写到results的对应位置
+func (self *Bulk) chunk(ctx context.Context, method string, gids []string, start int, end int, params func(i int, gid string) []interface{}, results []BulkResult) {
+	calls := make([]interface{}, 0, end-start)
+	for i := start; i < end; i++ {
+		calls = append(calls, map[string]interface{}{"methodName": "aria2." + method, "params": params(i, gids[i])})
+	}
+	req := self.client.newRequest("multicall", []interface{}{calls}, "system.")
+	res, err := self.client.Invoke(ctx, req)
+	if err == nil {
+		err = res.Err()
+	}
+	values, _ := res.Result.([]interface{})
+	for i := start; i < end; i++ {
+		result := BulkResult{Gid: gids[i]}
+		switch {
+		case err != nil:
+			result.Err = err
+		case i-start >= len(values):
+			result.Err = errors.New("aria2: missing multicall result")
+		default:
+			// 成功时是只有一个元素的数组 失败时是{"code":..,"message":..}
+			if v, ok := values[i-start].([]interface{}); ok && len(v) > 0 {
+				result.Result = v[0]
+			} else {
+				result.Err = newErrorMsg(values[i-start])
+			}
+		}
+		results[i] = result
+	}
+}
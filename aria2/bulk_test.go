@@ -0,0 +1,198 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// multicallSizes 每个system.multicall包含的调用数
+func multicallSizes(fake *fakeAria2) []int {
+	sizes := make([]int, 0)
+	calls, params := fake.Calls(), fake.Params()
+	for i, method := range calls {
+		if method == "system.multicall" {
+			sizes = append(sizes, len(params[i][0].([]interface{})))
+		}
+	}
+	return sizes
+}
+
+func makeGids(n int) GidList {
+	gids := make(GidList, 0, n)
+	for i := 0; i < n; i++ {
+		gids = append(gids, fmt.Sprintf("%016x", i))
+	}
+	return gids
+}
+
+func TestBulkChunking(t *testing.T) {
+	pause := multicallHandler(func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.pause" {
+			return params[0], nil
+		}
+		return versionHandler(method, params)
+	})
+	cases := []struct {
+		n           int
+		chunkSize   int
+		concurrency int
+		sizes       string
+	}{
+		{DefaultChunkSize, 0, 1, "[100]"},
+		{DefaultChunkSize + 1, 0, 1, "[100 1]"},
+		{2*DefaultChunkSize + 50, DefaultChunkSize, 4, "[100 100 50]"},
+		{7, 3, 2, "[3 3 1]"},
+		{0, 0, 1, "[]"},
+	}
+	for _, c := range cases {
+		fake := newFakeAria2(t, pause)
+		client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+		bulk := client.Bulk()
+		bulk.ChunkSize = c.chunkSize
+		bulk.Concurrency = c.concurrency
+		gids := makeGids(c.n)
+		report, err := bulk.Pause(context.Background(), gids)
+		if err != nil || report.Err() != nil {
+			t.Fatalf("%d gids: %v %v", c.n, err, report.Err())
+		}
+		sizes := multicallSizes(fake)
+		if c.concurrency > 1 {
+			// 并发发送时到达顺序不确定
+			sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+		}
+		if got := fmt.Sprint(sizes); got != c.sizes {
+			t.Errorf("%d gids in chunks of %d: multicalls %s, want %s", c.n, c.chunkSize, got, c.sizes)
+		}
+		// 并发时结果仍然与选出的顺序一致
+		if len(report.Results) != c.n {
+			t.Fatalf("%d results for %d gids", len(report.Results), c.n)
+		}
+		for i, result := range report.Results {
+			if result.Gid != gids[i] || result.Result != gids[i] {
+				t.Fatalf("result %d = %+v", i, result)
+			}
+		}
+	}
+}
+
+func TestBulkPerItemErrors(t *testing.T) {
+	var lock sync.Mutex
+	var changed []string
+	fake := newFakeAria2(t, multicallHandler(func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		switch method {
+		case "aria2.changeOption":
+			gid := params[0].(string)
+			if strings.HasSuffix(gid, "1") || strings.HasSuffix(gid, "4") {
+				return nil, &ErrorMsg{Code: 1, Message: "GID " + gid + " is not found"}
+			}
+			lock.Lock()
+			changed = append(changed, fmt.Sprint(params[1]))
+			lock.Unlock()
+			return "OK", nil
+		}
+		return versionHandler(method, params)
+	}))
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	bulk := client.Bulk()
+	bulk.ChunkSize = 2
+	report, err := bulk.ChangeOption(context.Background(), makeGids(6), map[string]interface{}{"max-download-limit": "1M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 失败的项不影响同一个multicall中的其他项
+	if got := strings.Join(report.Succeeded(), " "); got != "0000000000000000 0000000000000002 0000000000000003 0000000000000005" {
+		t.Fatalf("succeeded %s", got)
+	}
+	failed := report.Failed()
+	if len(failed) != 2 || failed[0].Gid != "0000000000000001" || failed[1].Gid != "0000000000000004" {
+		t.Fatalf("failed %+v", failed)
+	}
+	var rpcErr *ErrorMsg
+	if !errors.As(failed[1].Err, &rpcErr) || rpcErr.Code != 1 || rpcErr.Message != "GID 0000000000000004 is not found" {
+		t.Fatalf("item error %#v", failed[1].Err)
+	}
+	if err := report.Err(); !errors.As(err, &rpcErr) || !strings.Contains(err.Error(), "2 of 6 calls failed, first: 0000000000000001") {
+		t.Fatalf("report error %v", err)
+	}
+	if report.Results[0].Result != "OK" || fmt.Sprint(changed[0]) != "map[max-download-limit:1M]" {
+		t.Fatalf("result %+v, options %v", report.Results[0], changed)
+	}
+}
+
+func TestBulkChunkErrors(t *testing.T) {
+	multicalls := 0
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method != "system.multicall" {
+			return versionHandler(method, params)
+		}
+		multicalls++
+		calls := params[0].([]interface{})
+		switch multicalls {
+		case 1:
+			// 整个multicall失败 这一块的每一项都得到同一个错误
+			return nil, &ErrorMsg{Code: 1, Message: "busy"}
+		case 2:
+			// 结果比调用少
+			return []interface{}{[]interface{}{"OK"}}, nil
+		}
+		results := make([]interface{}, 0, len(calls))
+		for range calls {
+			results = append(results, []interface{}{"OK"})
+		}
+		return results, nil
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	bulk := client.Bulk()
+	bulk.ChunkSize = 2
+	report, err := bulk.Remove(context.Background(), makeGids(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make([]string, 0)
+	for _, result := range report.Results {
+		errs = append(errs, fmt.Sprint(result.Err))
+	}
+	want := "aria2: rpc error 1: busy|aria2: rpc error 1: busy|<nil>|aria2: missing multicall result|<nil>"
+	if got := strings.Join(errs, "|"); got != want {
+		t.Fatalf("errors %s, want %s", got, want)
+	}
+
+	// ctx已经取消时不发送 每一项都记录ctx的错误
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := len(fake.Calls())
+	report, err = bulk.Remove(ctx, makeGids(3))
+	if err != context.Canceled || len(fake.Calls()) != calls {
+		t.Fatalf("cancelled: %v, %d new calls", err, len(fake.Calls())-calls)
+	}
+	for _, result := range report.Results {
+		if result.Err != context.Canceled {
+			t.Fatalf("cancelled result %+v", result)
+		}
+	}
+}
+
+func TestBulkChangePosition(t *testing.T) {
+	queue := &fakeQueue{queue: strings.Fields("a b c d e f")}
+	fake := newFakeAria2(t, multicallHandler(queue.handle))
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	bulk := client.Bulk()
+	bulk.ChunkSize = 2
+	bulk.Concurrency = 4
+	// POS_SET时第i个移动到pos+i 跨chunk也连续 移动后保持选出的顺序
+	report, err := bulk.ChangePosition(context.Background(), GidList{"f", "d", "b"}, 0, "POS_SET")
+	if err != nil || report.Err() != nil {
+		t.Fatal(err, report.Err())
+	}
+	if got := queue.gids(); got != "f d b a c e" {
+		t.Fatalf("queue %s", got)
+	}
+	if fmt.Sprint(multicallSizes(fake)) != "[2 1]" {
+		t.Fatalf("multicalls %v", multicallSizes(fake))
+	}
+}
@@ -1,7 +1,13 @@
 package aria2
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +20,10 @@ type Aria2Client struct {
 	Token   *string
 	Queue   *chan RpcRequest
 	Handler IRequestHandler
+
+	interceptors []Interceptor
+	ctx          context.Context
+	startErr     error
 }
 
 func NewAria2Client(url string,
@@ -23,11 +33,11 @@ func NewAria2Client(url string,
 	queue *chan RpcRequest,
 	handler IRequestHandler) *Aria2Client {
 	if id == nil {
+		// 批量操作、调度器等会在多个goroutine里并发发请求 计数器必须是原子的
 		func_ := func() IdFactory {
-			inner := 0
+			var inner int64
 			return func() string {
-				inner++
-				return string(inner)
+				return strconv.FormatInt(atomic.AddInt64(&inner, 1), 10)
 			}
 		}()
 		id = &func_
@@ -42,16 +52,60 @@ func NewAria2Client(url string,
 	}
 	handler.SetUrl(url)
 	client := &Aria2Client{Url: url, Id: id, Mode: mode, Token: token, Queue: queue, Handler: handler}
-	if v, ok := handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(handler); ok {
 		v.SetClient(client)
-		connected := make(chan bool)
-		go v.listen(connected)
-		<-connected
+		client.startErr = v.start()
 	}
 	return client
 }
 
-func (self *Aria2Client) jsonrpc(method string, params []interface{}, prefix string) (interface{}, error) {
+// StartErr 返回NewAria2Client建立websocket(或failover的首选端点)连接时的错误 http handler总是返回nil
+// 开启了AutoReconnect时会在后台继续重连 连上之前的调用返回ErrNotConnected
+func (self *Aria2Client) StartErr() error {
+	return self.startErr
+}
+
+// Use 添加拦截器 先添加的在外层 应当在发起请求之前调用
+func (self *Aria2Client) Use(interceptors ...Interceptor) {
+	self.interceptors = append(self.interceptors, interceptors...)
+}
+
+// WithContext 返回一个绑定了ctx的浅拷贝 通过它调用的方法都使用这个ctx 拦截器也能拿到它
+func (self *Aria2Client) WithContext(ctx context.Context) *Aria2Client {
+	client := *self
+	client.ctx = ctx
+	return &client
+}
+
+// Context 返回WithContext绑定的ctx 没有绑定时返回context.Background()
+func (self *Aria2Client) Context() context.Context {
+	if self.ctx == nil {
+		return context.Background()
+	}
+	return self.ctx
+}
+
+// Invoke 让一个请求经过拦截器链 最后交给Handler发送
+func (self *Aria2Client) Invoke(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	if len(self.interceptors) == 0 {
+		return self.send(ctx, req)
+	}
+	return ChainInterceptors(self.interceptors...)(ctx, req, self.send)
+}
+
+func (self *Aria2Client) send(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	if v, ok := self.Handler.(IContextRequestHandler); ok {
+		return v.SendRequestContext(ctx, req)
+	}
+	result, err := self.Handler.SendRequest(req)
+	if err != nil {
+		return RpcResponse{}, err
+	}
+	return RpcResponse{ID: fmt.Sprint(req.Id), Jsonrpc: req.Jsonrpc, Result: result}, nil
+}
+
+// newRequest 构造请求 如果设置了token就加到参数最前面
+func (self *Aria2Client) newRequest(method string, params []interface{}, prefix string) RpcRequest {
 	if self.Token != nil {
 		tokenStr := fmt.Sprintf("token:%s", *self.Token)
 		if method == "multicall" {
@@ -67,7 +121,11 @@ func (self *Aria2Client) jsonrpc(method string, params []interface{}, prefix str
 			params = append(newParams, params...)
 		}
 	}
-	reqObj := RpcRequest{Jsonrpc: "2.0", Id: (*self.Id)(), Method: prefix + method, Params: params}
+	return RpcRequest{Jsonrpc: "2.0", Id: (*self.Id)(), Method: prefix + method, Params: params}
+}
+
+func (self *Aria2Client) jsonrpc(method string, params []interface{}, prefix string) (interface{}, error) {
+	reqObj := self.newRequest(method, params, prefix)
 	switch *self.Mode {
 	case "batch":
 		*self.Queue <- reqObj
@@ -75,12 +133,53 @@ func (self *Aria2Client) jsonrpc(method string, params []interface{}, prefix str
 	case "format":
 		return reqObj, nil
 	case "normal":
-		return self.Handler.SendRequest(reqObj)
+		res, err := self.Invoke(self.Context(), reqObj)
+		if err != nil {
+			return nil, err
+		}
+		return res.Result, nil
 	default:
 		return nil, nil
 	}
 }
 
+// Flush 把batch模式下排队的请求打包成一个system.multicall发送 同样经过拦截器链
+// 返回值与排队顺序一一对应 单个请求失败时对应RpcResponse的Error不为nil
+func (self *Aria2Client) Flush() ([]RpcResponse, error) {
+	queued := make([]RpcRequest, 0, len(*self.Queue))
+	for len(*self.Queue) > 0 {
+		queued = append(queued, <-*self.Queue)
+	}
+	if len(queued) == 0 {
+		return nil, nil
+	}
+	methods := make([]interface{}, 0, len(queued))
+	for _, req := range queued {
+		methods = append(methods, map[string]interface{}{"methodName": req.Method, "params": req.Params})
+	}
+	reqObj := RpcRequest{Jsonrpc: "2.0", Id: (*self.Id)(), Method: "system.multicall", Params: []interface{}{methods}}
+	res, err := self.Invoke(self.Context(), reqObj)
+	if err != nil {
+		return nil, err
+	}
+	results, _ := res.Result.([]interface{})
+	responses := make([]RpcResponse, len(queued))
+	for i, req := range queued {
+		responses[i] = RpcResponse{ID: fmt.Sprint(req.Id), Jsonrpc: req.Jsonrpc}
+		if i >= len(results) {
+			responses[i].Error = map[string]interface{}{"code": float64(1), "message": "missing multicall result"}
+			continue
+		}
+		// 成功时是只有一个元素的数组 失败时是{"code":..,"message":..}
+		if v, ok := results[i].([]interface{}); ok && len(v) > 0 {
+			responses[i].Result = v[0]
+		} else {
+			responses[i].Error = results[i]
+		}
+	}
+	return responses, nil
+}
+
 // AddUri position一般是nil
 //添加新的任务到下载队列
 //:param uris: 要添加的链接 务必是list HTTP/FTP/SFTP/BitTorrent URIs (strings)
@@ -111,18 +210,70 @@ func (self *Aria2Client) AddTorrent(torrent string, uris *[]string, options *map
 	return self.jsonrpc("addTorrent", params, "aria2.")
 }
 
-// AddMetalink 此方法通过上载一个来添加一个Metalink下载 metalink是一个用base64编码的字符串，其中包含“.metalink”文件。
-//:param metalink: base64编码的字符串 base64.b64encode(open('file.meta4',"rb").read())
+// AddTorrentFile 读取本地的.torrent文件 base64编码后调用AddTorrent 参数同AddTorrent
+// 提交前需要预览文件列表时可以先用torrent.ParseFile
+func (self *Aria2Client) AddTorrentFile(path string, uris *[]string, options *map[string]interface{}, position *int) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return self.AddTorrent(base64.StdEncoding.EncodeToString(data), uris, options, position)
+}
+
+// AddTorrentReader 同AddTorrentFile 种子内容从r读取
+func (self *Aria2Client) AddTorrentReader(r io.Reader, uris *[]string, options *map[string]interface{}, position *int) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return self.AddTorrent(base64.StdEncoding.EncodeToString(data), uris, options, position)
+}
+
+// AddSeed 提交一个本地已有完整内容的种子做种 aria2校验完文件后直接进入做种状态
+// aria2按dir/种子名查找内容 找不到或者校验失败时会变成普通下载
+//:param torrent: .torrent文件内容 例如torrent.Builder生成的MetaInfo.Raw
+//:param dir: 内容所在的目录 dir下必须有与种子名(MetaInfo.Name)同名的文件或目录
+// 用torrent.Builder制作且没有改Name时 就是制作种子时文件或目录的上一级目录
+//:param seedRatio: 分享率达到后停止 0表示不论分享率一直做种
+//:param seedTime: 做种时间 0表示不限制
+//:param options: 其余参数 可以为nil
+func (self *Aria2Client) AddSeed(torrent []byte, dir string, seedRatio float64, seedTime time.Duration, options *map[string]interface{}) (interface{}, error) {
+	opts := make(map[string]interface{})
+	if options != nil {
+		for k, v := range *options {
+			opts[k] = v
+		}
+	}
+	opts["dir"] = dir
+	opts["check-integrity"] = "true"
+	opts["seed-ratio"] = strconv.FormatFloat(seedRatio, 'f', -1, 64)
+	if seedTime > 0 {
+		opts["seed-time"] = strconv.FormatFloat(seedTime.Minutes(), 'f', -1, 64)
+	}
+	return self.AddTorrent(base64.StdEncoding.EncodeToString(torrent), nil, &opts, nil)
+}
+
+// AddMetalink 此方法通过上载一个来添加一个Metalink下载 aria2要求的是base64编码的“.metalink”文件，这里会自动编码。
+//:param metalink: .meta4或.metalink文件的内容 可以用metalink包生成
 //:param options:参数字典
 //:param position:在下载队列中的位置
-//:return:包含结果的json
-//{"result":"2089b05ecca3d829"}
-func (self *Aria2Client) AddMetalink(metalink []string, options *map[string]interface{}, position *int) (interface{}, error) {
-	params := append(make([]interface{}, 0, 2), metalink)
+//:return:包含结果的json 每个文件一个GID
+// {"result":["2089b05ecca3d829"]}
+func (self *Aria2Client) AddMetalink(metalink []byte, options *map[string]interface{}, position *int) (interface{}, error) {
+	params := append(make([]interface{}, 0, 2), base64.StdEncoding.EncodeToString(metalink))
 	params = addOptionsAndPosition(params, options, position)
 	return self.jsonrpc("addMetalink", params, "aria2.")
 }
 
+// AddMetalinkReader 同AddMetalink 内容从r读取
+func (self *Aria2Client) AddMetalinkReader(r io.Reader, options *map[string]interface{}, position *int) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return self.AddMetalink(data, options, position)
+}
+
 // Remove 正在下载的停止下载 停止的删除状态
 //:param gid: GID(或GID)是管理每个下载的密钥。每个下载将被分配一个唯一的GID。GID在aria2中存储为64位二进制值。
 //:return:包含结果的json
@@ -506,7 +657,11 @@ func (self *Aria2Client) SaveSession() (interface{}, error) {
 //'params':[base64.b64encode(open('file.torrent').read())]}]
 //:return:
 func (self *Aria2Client) Multicall(methods []map[string]interface{}) (interface{}, error) {
-	params := append(make([]interface{}, 0, 1), methods)
+	calls := make([]interface{}, 0, len(methods))
+	for _, method := range methods {
+		calls = append(calls, method)
+	}
+	params := append(make([]interface{}, 0, 1), calls)
 	return self.jsonrpc("multicall", params, "system.")
 }
 
@@ -526,48 +681,48 @@ func (self *Aria2Client) ListNotifications() (interface{}, error) {
 }
 
 func (self *Aria2Client) SetTimeout(t time.Duration){
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.SetTimeout(t)
 	}
 }
 
 func (self *Aria2Client) OnDownloadStart(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnDownloadStart(callback)
 	}
 	return callback
 }
 
 func (self *Aria2Client) OnDownloadPause(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnDownloadPause(callback)
 	}
 	return callback
 }
 
 func (self *Aria2Client) OnDownloadStop(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnDownloadStop(callback)
 	}
 	return callback
 }
 
 func (self *Aria2Client) OnDownloadComplete(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnDownloadComplete(callback)
 	}
 	return callback
 }
 
 func (self *Aria2Client) OnDownloadError(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnDownloadError(callback)
 	}
 	return callback
 }
 
 func (self *Aria2Client) OnBtDownloadComplete(callback Callback) Callback {
-	if v, ok := self.Handler.(*WebsocketRequestHandler); ok {
+	if v, ok := findNotificationHandler(self.Handler); ok {
 		v.OnBtDownloadComplete(callback)
 	}
 	return callback
@@ -0,0 +1,108 @@
+package aria2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/metalink"
+	"sync"
+	"testing"
+)
+
+func TestStartErr(t *testing.T) {
+	fake := newFakeAria2(t, versionHandler)
+	handler := NewWebsocketRequestHandler()
+	client := NewAria2Client(fake.RpcUrl(true), nil, nil, nil, nil, handler)
+	defer handler.Close()
+	if err := client.StartErr(); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+
+	url := fake.RpcUrl(true)
+	fake.Close()
+	handler = NewWebsocketRequestHandler()
+	client = NewAria2Client(url, nil, nil, nil, nil, handler)
+	if client.StartErr() == nil {
+		t.Fatal("expected start error for unreachable endpoint")
+	}
+	if _, err := client.GetVersion(); err != ErrNotConnected {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestDefaultIdFactoryConcurrent(t *testing.T) {
+	fake := newFakeAria2(t, versionHandler)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	ids := make(map[string]bool)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id := (*client.Id)()
+				lock.Lock()
+				if ids[id] {
+					t.Errorf("duplicate request id %s", id)
+				}
+				ids[id] = true
+				lock.Unlock()
+			}
+			if _, err := client.GetVersion(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAddMetalink(t *testing.T) {
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.addMetalink" {
+			return []interface{}{"2089b05ecca3d829"}, nil
+		}
+		return versionHandler(method, params)
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	doc := &metalink.Metalink{Files: []metalink.File{{
+		Name: "dir/file.iso",
+		Size: 1024,
+		Urls: []metalink.Url{{Url: "http://mirror.example/file.iso", Priority: 1}},
+	}}}
+	data, err := doc.MarshalV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	position := 0
+	res, err := client.AddMetalink(data, &map[string]interface{}{"dir": "/data"}, &position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(res) != "[2089b05ecca3d829]" {
+		t.Fatalf("AddMetalink = %v", res)
+	}
+	if _, err := client.AddMetalinkReader(bytes.NewReader(data), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两种方式都发送文件内容的base64编码 而不是原始xml
+	params := fake.Params()
+	if len(params) != 2 {
+		t.Fatalf("params %v", params)
+	}
+	want := base64.StdEncoding.EncodeToString(data)
+	for _, p := range params {
+		if p[0] != want {
+			t.Fatalf("payload %v, want %s", p[0], want)
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(params[1][0].(string))
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Fatalf("decoded payload %q %v", decoded, err)
+	}
+	if fmt.Sprint(params[0][1:]) != "[map[dir:/data] 0]" || len(params[1]) != 1 {
+		t.Fatalf("options and position %v %v", params[0][1:], params[1][1:])
+	}
+}
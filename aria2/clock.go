@@ -0,0 +1,93 @@
+package aria2
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时间来源 定时类的功能通过它取时间和等待 测试时可以换成ManualClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SystemClock 使用系统时间
+var SystemClock Clock = systemClock{}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// ManualClock 只有调用Set或Advance时才会前进的时钟
+type ManualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+// NewManualClock 从now开始
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (self *ManualClock) Now() time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.now
+}
+
+func (self *ManualClock) After(d time.Duration) <-chan time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- self.now
+		return ch
+	}
+	self.waiters = append(self.waiters, clockWaiter{at: self.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance 前进d 到期的After会收到时间
+func (self *ManualClock) Advance(d time.Duration) {
+	self.Set(self.Now().Add(d))
+}
+
+// Set 设置当前时间 不能倒退
+func (self *ManualClock) Set(now time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if now.Before(self.now) {
+		return
+	}
+	self.now = now
+	sort.Slice(self.waiters, func(i, j int) bool { return self.waiters[i].at.Before(self.waiters[j].at) })
+	remaining := self.waiters[:0]
+	for _, waiter := range self.waiters {
+		if waiter.at.After(now) {
+			remaining = append(remaining, waiter)
+		} else {
+			waiter.ch <- now
+		}
+	}
+	self.waiters = remaining
+}
+
+// Waiters 还没到期的After数量 测试中可以用来等待被测代码进入等待
+func (self *ManualClock) Waiters() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.waiters)
+}
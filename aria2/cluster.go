@@ -0,0 +1,501 @@
+package aria2
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrGidNotFound 集群中没有任何节点认识这个gid
+var ErrGidNotFound = errors.New("aria2: gid not found in cluster")
+
+// ErrNoNode 集群里没有可用的节点
+var ErrNoNode = errors.New("aria2: no available node in cluster")
+
+// ClusterError 向多个节点发请求时部分节点失败 key是节点的Url
+type ClusterError struct {
+	Errors map[string]error
+}
+
+func (self *ClusterError) Error() string {
+	urls := make([]string, 0, len(self.Errors))
+	for url := range self.Errors {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	msgs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", url, self.Errors[url]))
+	}
+	return "aria2: cluster errors: " + strings.Join(msgs, "; ")
+}
+
+// BalanceStrategy 决定新的下载交给哪个节点
+type BalanceStrategy interface {
+	Pick(cluster *Cluster, uris []string) (*Aria2Client, error)
+}
+
+// BalanceFunc 让普通函数实现BalanceStrategy
+type BalanceFunc func(cluster *Cluster, uris []string) (*Aria2Client, error)
+
+func (self BalanceFunc) Pick(cluster *Cluster, uris []string) (*Aria2Client, error) {
+	return self(cluster, uris)
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (self *roundRobin) Pick(cluster *Cluster, uris []string) (*Aria2Client, error) {
+	clients := cluster.Nodes()
+	if len(clients) == 0 {
+		return nil, ErrNoNode
+	}
+	n := atomic.AddUint64(&self.next, 1) - 1
+	return clients[n%uint64(len(clients))], nil
+}
+
+// RoundRobin 依次轮流分配
+func RoundRobin() BalanceStrategy {
+	return &roundRobin{}
+}
+
+// LeastActive 分配给活动+等待下载数最少的节点 根据GetGlobalStat
+func LeastActive() BalanceStrategy {
+	return BalanceFunc(func(cluster *Cluster, uris []string) (*Aria2Client, error) {
+		return cluster.pickByStat(func(stat GlobalStat) int64 {
+			return int64(stat.NumActive + stat.NumWaiting)
+		})
+	})
+}
+
+// LowestSpeed 分配给当前总下载速度最低的节点 根据GetGlobalStat
+func LowestSpeed() BalanceStrategy {
+	return BalanceFunc(func(cluster *Cluster, uris []string) (*Aria2Client, error) {
+		return cluster.pickByStat(func(stat GlobalStat) int64 {
+			return stat.DownloadSpeed
+		})
+	})
+}
+
+// HashByUrl 按第一个uri的哈希分配 同一个链接总是落到同一个节点(节点列表不变的前提下)
+func HashByUrl() BalanceStrategy {
+	return BalanceFunc(func(cluster *Cluster, uris []string) (*Aria2Client, error) {
+		clients := cluster.Nodes()
+		if len(clients) == 0 {
+			return nil, ErrNoNode
+		}
+		h := fnv.New32a()
+		if len(uris) > 0 {
+			h.Write([]byte(uris[0]))
+		}
+		return clients[h.Sum32()%uint32(len(clients))], nil
+	})
+}
+
+// Cluster 把多个aria2实例当成一个使用
+// 新下载按Strategy分配 基于gid的调用转发给拥有这个gid的节点 TellActive等列表查询合并所有节点的结果
+type Cluster struct {
+	Strategy BalanceStrategy
+	clients  []*Aria2Client
+	owners   map[string]*Aria2Client
+	lock     sync.RWMutex
+}
+
+// NewCluster strategy为nil时使用RoundRobin
+func NewCluster(strategy BalanceStrategy, clients ...*Aria2Client) *Cluster {
+	if strategy == nil {
+		strategy = RoundRobin()
+	}
+	return &Cluster{Strategy: strategy, clients: clients, owners: make(map[string]*Aria2Client)}
+}
+
+// AddNode 加入一个节点
+func (self *Cluster) AddNode(client *Aria2Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.clients = append(self.clients, client)
+}
+
+// RemoveNode 移除一个节点 并忘掉属于它的gid
+func (self *Cluster) RemoveNode(client *Aria2Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	clients := make([]*Aria2Client, 0, len(self.clients))
+	for _, c := range self.clients {
+		if c != client {
+			clients = append(clients, c)
+		}
+	}
+	self.clients = clients
+	for gid, owner := range self.owners {
+		if owner == client {
+			delete(self.owners, gid)
+		}
+	}
+}
+
+// Nodes 返回当前节点列表的拷贝
+func (self *Cluster) Nodes() []*Aria2Client {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return append([]*Aria2Client(nil), self.clients...)
+}
+
+// Forget 忘掉gid所属的节点 下载结果被清除后调用可以释放内存
+func (self *Cluster) Forget(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.owners, gid)
+}
+
+func (self *Cluster) remember(gid string, client *Aria2Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.owners[gid] = client
+}
+
+// each 并发地对每个节点执行fn 返回每个节点的错误
+func (self *Cluster) each(fn func(client *Aria2Client) error) map[*Aria2Client]error {
+	clients := self.Nodes()
+	errs := make(map[*Aria2Client]error)
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Aria2Client) {
+			defer wg.Done()
+			if err := fn(client); err != nil {
+				lock.Lock()
+				errs[client] = err
+				lock.Unlock()
+			}
+		}(client)
+	}
+	wg.Wait()
+	return errs
+}
+
+func toClusterError(errs map[*Aria2Client]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	clusterErr := &ClusterError{Errors: make(map[string]error, len(errs))}
+	for client, err := range errs {
+		clusterErr.Errors[client.Url] = err
+	}
+	return clusterErr
+}
+
+// pickByStat 选择score最小的节点 查询失败的节点跳过
+func (self *Cluster) pickByStat(score func(stat GlobalStat) int64) (*Aria2Client, error) {
+	var (
+		best      *Aria2Client
+		bestScore int64
+		lock      sync.Mutex
+	)
+	errs := self.each(func(client *Aria2Client) error {
+		stat, err := client.globalStat()
+		if err != nil {
+			return err
+		}
+		s := score(stat)
+		lock.Lock()
+		if best == nil || s < bestScore {
+			best, bestScore = client, s
+		}
+		lock.Unlock()
+		return nil
+	})
+	if best == nil {
+		if len(errs) > 0 {
+			return nil, toClusterError(errs)
+		}
+		return nil, ErrNoNode
+	}
+	return best, nil
+}
+
+// ClientFor 找到拥有gid的节点 不在缓存中时询问所有节点
+func (self *Cluster) ClientFor(gid string) (*Aria2Client, error) {
+	self.lock.RLock()
+	client, ok := self.owners[gid]
+	self.lock.RUnlock()
+	if ok {
+		return client, nil
+	}
+	var found *Aria2Client
+	var lock sync.Mutex
+	self.each(func(client *Aria2Client) error {
+		if _, err := client.TellStatus(gid, &[]string{"gid"}); err != nil {
+			return err
+		}
+		lock.Lock()
+		found = client
+		lock.Unlock()
+		return nil
+	})
+	if found == nil {
+		return nil, ErrGidNotFound
+	}
+	self.remember(gid, found)
+	return found, nil
+}
+
+// AddUri 按Strategy选择节点添加下载
+func (self *Cluster) AddUri(uris []string, options *map[string]interface{}, position *int) (interface{}, error) {
+	client, err := self.Strategy.Pick(self, uris)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.AddUri(uris, options, position)
+	if err != nil {
+		return res, err
+	}
+	if gid, ok := res.(string); ok {
+		self.remember(gid, client)
+	}
+	return res, nil
+}
+
+// AddTorrent 按Strategy选择节点添加种子 传给Strategy的uris是webseed列表
+func (self *Cluster) AddTorrent(torrent string, uris *[]string, options *map[string]interface{}, position *int) (interface{}, error) {
+	var seeds []string
+	if uris != nil {
+		seeds = *uris
+	}
+	client, err := self.Strategy.Pick(self, seeds)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.AddTorrent(torrent, uris, options, position)
+	if err != nil {
+		return res, err
+	}
+	if gid, ok := res.(string); ok {
+		self.remember(gid, client)
+	}
+	return res, nil
+}
+
+// AddMetalink 按Strategy选择节点添加Metalink 返回的所有GID都记录到这个节点
+func (self *Cluster) AddMetalink(metalink []byte, options *map[string]interface{}, position *int) (interface{}, error) {
+	client, err := self.Strategy.Pick(self, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.AddMetalink(metalink, options, position)
+	if err != nil {
+		return res, err
+	}
+	if gids, ok := res.([]interface{}); ok {
+		for _, gid := range gids {
+			if s, ok := gid.(string); ok {
+				self.remember(s, client)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (self *Cluster) Remove(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.Remove(gid)
+}
+
+func (self *Cluster) ForceRemove(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.ForceRemove(gid)
+}
+
+func (self *Cluster) Pause(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.Pause(gid)
+}
+
+func (self *Cluster) ForcePause(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.ForcePause(gid)
+}
+
+func (self *Cluster) Unpause(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.Unpause(gid)
+}
+
+func (self *Cluster) TellStatus(gid string, keys *[]string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.TellStatus(gid, keys)
+}
+
+func (self *Cluster) GetUris(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetUris(gid)
+}
+
+func (self *Cluster) GetFiles(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetFiles(gid)
+}
+
+func (self *Cluster) GetPeers(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetPeers(gid)
+}
+
+func (self *Cluster) GetServers(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetServers(gid)
+}
+
+func (self *Cluster) ChangePosition(gid string, pos int, how string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.ChangePosition(gid, pos, how)
+}
+
+func (self *Cluster) ChangeUri(gid string, fileIndex int, delUris []string, addUris []string, position *int) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.ChangeUri(gid, fileIndex, delUris, addUris, position)
+}
+
+func (self *Cluster) GetOption(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetOption(gid)
+}
+
+func (self *Cluster) ChangeOption(gid string, options map[string]interface{}) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	return client.ChangeOption(gid, options)
+}
+
+func (self *Cluster) RemoveDownloadResult(gid string) (interface{}, error) {
+	client, err := self.ClientFor(gid)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.RemoveDownloadResult(gid)
+	if err == nil {
+		self.Forget(gid)
+	}
+	return res, err
+}
+
+// merge 合并每个节点返回的下载列表 同时记住每个gid属于哪个节点
+// 部分节点失败时返回其余节点的结果和*ClusterError
+func (self *Cluster) merge(call func(client *Aria2Client) (interface{}, error)) ([]interface{}, error) {
+	merged := make([]interface{}, 0)
+	var lock sync.Mutex
+	errs := self.each(func(client *Aria2Client) error {
+		res, err := call(client)
+		if err != nil {
+			return err
+		}
+		items, _ := res.([]interface{})
+		lock.Lock()
+		defer lock.Unlock()
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				if gid, ok := m["gid"].(string); ok {
+					self.remember(gid, client)
+				}
+			}
+			merged = append(merged, item)
+		}
+		return nil
+	})
+	return merged, toClusterError(errs)
+}
+
+// TellActive 合并所有节点的活动下载
+func (self *Cluster) TellActive(keys *[]string) ([]interface{}, error) {
+	keys = withGid(keys)
+	return self.merge(func(client *Aria2Client) (interface{}, error) {
+		return client.TellActive(keys)
+	})
+}
+
+// TellWaiting 合并所有节点的等待下载 offset和num分别作用于每个节点
+func (self *Cluster) TellWaiting(offset int, num int, keys *[]string) ([]interface{}, error) {
+	keys = withGid(keys)
+	return self.merge(func(client *Aria2Client) (interface{}, error) {
+		return client.TellWaiting(offset, num, keys)
+	})
+}
+
+// TellStopped 合并所有节点的已停止下载 offset和num分别作用于每个节点
+func (self *Cluster) TellStopped(offset int, num int, keys *[]string) ([]interface{}, error) {
+	keys = withGid(keys)
+	return self.merge(func(client *Aria2Client) (interface{}, error) {
+		return client.TellStopped(offset, num, keys)
+	})
+}
+
+// GetGlobalStat 所有节点统计信息之和
+func (self *Cluster) GetGlobalStat() (GlobalStat, error) {
+	total := GlobalStat{}
+	var lock sync.Mutex
+	errs := self.each(func(client *Aria2Client) error {
+		stat, err := client.globalStat()
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		total.DownloadSpeed += stat.DownloadSpeed
+		total.UploadSpeed += stat.UploadSpeed
+		total.NumActive += stat.NumActive
+		total.NumWaiting += stat.NumWaiting
+		total.NumStopped += stat.NumStopped
+		total.NumStoppedTotal += stat.NumStoppedTotal
+		return nil
+	})
+	return total, toClusterError(errs)
+}
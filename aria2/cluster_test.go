@@ -0,0 +1,240 @@
+package aria2
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNode 集群中的一个节点 failing时所有调用都返回错误
+type fakeNode struct {
+	lock    sync.Mutex
+	name    string
+	active  []string
+	stopped []string
+	waiting int
+	failing bool
+	added   int
+}
+
+func (self *fakeNode) setFailing(failing bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.failing = failing
+}
+
+func (self *fakeNode) owns(gid string) bool {
+	for _, g := range append(append([]string(nil), self.active...), self.stopped...) {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *fakeNode) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.failing {
+		return nil, &ErrorMsg{Code: 1, Message: self.name + " is shutting down"}
+	}
+	statuses := func(gids []string) []interface{} {
+		list := make([]interface{}, 0, len(gids))
+		for _, gid := range gids {
+			list = append(list, map[string]interface{}{"gid": gid})
+		}
+		return list
+	}
+	switch method {
+	case "aria2.addUri":
+		self.added++
+		gid := fmt.Sprintf("%s%015d", self.name, self.added)
+		self.active = append(self.active, gid)
+		return gid, nil
+	case "aria2.tellStatus", "aria2.pause", "aria2.removeDownloadResult":
+		if !self.owns(params[0].(string)) {
+			return nil, &ErrorMsg{Code: 1, Message: "GID " + params[0].(string) + " is not found"}
+		}
+		if method == "aria2.tellStatus" {
+			return map[string]interface{}{"gid": params[0]}, nil
+		}
+		return params[0], nil
+	case "aria2.tellActive":
+		return statuses(self.active), nil
+	case "aria2.tellStopped":
+		return statuses(self.stopped), nil
+	case "aria2.getGlobalStat":
+		return map[string]interface{}{
+			"downloadSpeed": "100", "uploadSpeed": "0",
+			"numActive": fmt.Sprint(len(self.active)), "numWaiting": fmt.Sprint(self.waiting),
+			"numStopped": fmt.Sprint(len(self.stopped)), "numStoppedTotal": fmt.Sprint(len(self.stopped)),
+		}, nil
+	}
+	return versionHandler(method, params)
+}
+
+// newTestCluster 节点a和b 返回每个节点的客户端和假服务端
+func newTestCluster(t *testing.T, strategy BalanceStrategy, a *fakeNode, b *fakeNode) (*Cluster, []*Aria2Client, []*fakeAria2) {
+	fakes := []*fakeAria2{newFakeAria2(t, a.handle), newFakeAria2(t, b.handle)}
+	clients := make([]*Aria2Client, 0, 2)
+	for _, fake := range fakes {
+		clients = append(clients, NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil)))
+	}
+	return NewCluster(strategy, clients...), clients, fakes
+}
+
+func countCalls(fake *fakeAria2, method string) int {
+	n := 0
+	for _, call := range fake.Calls() {
+		if call == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClusterRoundRobin(t *testing.T) {
+	cluster, clients, fakes := newTestCluster(t, nil, &fakeNode{name: "a"}, &fakeNode{name: "b"})
+	owners := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		res, err := cluster.AddUri([]string{fmt.Sprintf("http://x/%d", i)}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gid := res.(string)
+		owners = append(owners, gid[:1])
+		// 新下载所在的节点被记住 不需要再询问
+		client, err := cluster.ClientFor(gid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := clients[strings.IndexByte("ab", gid[0])]; client != want {
+			t.Errorf("%s routed to %s", gid, client.Url)
+		}
+	}
+	if got := strings.Join(owners, ""); got != "abab" {
+		t.Fatalf("round robin order %s", got)
+	}
+	for _, fake := range fakes {
+		if n := countCalls(fake, "aria2.tellStatus"); n != 0 {
+			t.Fatalf("ClientFor asked nodes although gid was remembered: %d calls", n)
+		}
+	}
+}
+
+func TestClusterLeastActive(t *testing.T) {
+	a := &fakeNode{name: "a", active: []string{"a1", "a2", "a3"}, waiting: 1}
+	b := &fakeNode{name: "b", active: []string{"b1"}}
+	cluster, clients, _ := newTestCluster(t, LeastActive(), a, b)
+	pick := func() (*Aria2Client, error) { return cluster.Strategy.Pick(cluster, nil) }
+
+	if client, err := pick(); err != nil || client != clients[1] {
+		t.Fatalf("expected b, got %v %v", client, err)
+	}
+	res, err := cluster.AddUri([]string{"http://x/1"}, nil, nil)
+	if err != nil || !strings.HasPrefix(res.(string), "b") {
+		t.Fatalf("added to %v %v", res, err)
+	}
+	// 查询失败的节点被跳过
+	b.setFailing(true)
+	if client, err := pick(); err != nil || client != clients[0] {
+		t.Fatalf("expected a while b fails, got %v %v", client, err)
+	}
+	a.setFailing(true)
+	_, err = pick()
+	var clusterErr *ClusterError
+	if !errors.As(err, &clusterErr) || len(clusterErr.Errors) != 2 {
+		t.Fatalf("expected ClusterError for both nodes, got %v", err)
+	}
+}
+
+func TestClusterClientFor(t *testing.T) {
+	a := &fakeNode{name: "a", active: []string{"a1"}}
+	b := &fakeNode{name: "b", active: []string{"b1"}, stopped: []string{"b2"}}
+	cluster, clients, fakes := newTestCluster(t, nil, a, b)
+	// a出错时仍然能在b上找到
+	a.setFailing(true)
+	client, err := cluster.ClientFor("b2")
+	if err != nil || client != clients[1] {
+		t.Fatalf("ClientFor(b2) = %v %v", client, err)
+	}
+	a.setFailing(false)
+	if _, err := cluster.Pause("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if countCalls(fakes[0], "aria2.pause") != 0 || countCalls(fakes[1], "aria2.pause") != 1 {
+		t.Fatalf("pause routed wrongly: a=%v b=%v", fakes[0].Calls(), fakes[1].Calls())
+	}
+	// 已知的gid不再询问
+	before := countCalls(fakes[0], "aria2.tellStatus") + countCalls(fakes[1], "aria2.tellStatus")
+	if client, _ := cluster.ClientFor("b1"); client != clients[1] {
+		t.Fatal("b1 not routed to b")
+	}
+	if after := countCalls(fakes[0], "aria2.tellStatus") + countCalls(fakes[1], "aria2.tellStatus"); after != before {
+		t.Fatal("cached gid looked up again")
+	}
+	if _, err := cluster.ClientFor("ffffffffffffffff"); err != ErrGidNotFound {
+		t.Fatalf("unknown gid: %v", err)
+	}
+	// 清除下载结果后忘掉 再次查询需要询问节点
+	if _, err := cluster.RemoveDownloadResult("b2"); err != nil {
+		t.Fatal(err)
+	}
+	before = countCalls(fakes[1], "aria2.tellStatus")
+	cluster.ClientFor("b2")
+	if countCalls(fakes[1], "aria2.tellStatus") != before+1 {
+		t.Fatal("gid still cached after RemoveDownloadResult")
+	}
+	// 移除节点后属于它的gid找不到
+	cluster.RemoveNode(clients[1])
+	if _, err := cluster.ClientFor("b1"); err != ErrGidNotFound {
+		t.Fatalf("gid of removed node: %v", err)
+	}
+}
+
+func TestClusterMergePartialFailure(t *testing.T) {
+	a := &fakeNode{name: "a", active: []string{"a1", "a2"}, stopped: []string{"a3"}}
+	b := &fakeNode{name: "b", active: []string{"b1"}, stopped: []string{"b2"}}
+	cluster, clients, fakes := newTestCluster(t, nil, a, b)
+	gids := func(items []interface{}) string {
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, item.(map[string]interface{})["gid"].(string))
+		}
+		sort.Strings(list)
+		return strings.Join(list, " ")
+	}
+
+	active, err := cluster.TellActive(&[]string{"status"})
+	if err != nil || gids(active) != "a1 a2 b1" {
+		t.Fatalf("TellActive = %v %v", gids(active), err)
+	}
+
+	b.setFailing(true)
+	active, err = cluster.TellActive(nil)
+	var clusterErr *ClusterError
+	if !errors.As(err, &clusterErr) || len(clusterErr.Errors) != 1 || clusterErr.Errors[clients[1].Url] == nil {
+		t.Fatalf("expected ClusterError for b, got %v", err)
+	}
+	if gids(active) != "a1 a2" {
+		t.Fatalf("partial TellActive = %v", gids(active))
+	}
+	stopped, err := cluster.TellStopped(0, 100, nil)
+	if !errors.As(err, &clusterErr) || gids(stopped) != "a3" {
+		t.Fatalf("partial TellStopped = %v %v", gids(stopped), err)
+	}
+	stat, err := cluster.GetGlobalStat()
+	if !errors.As(err, &clusterErr) || stat.NumActive != 2 || stat.NumStopped != 1 || stat.DownloadSpeed != 100 {
+		t.Fatalf("partial GetGlobalStat = %+v %v", stat, err)
+	}
+	// 合并结果中的gid被记住 路由时不需要询问
+	if client, err := cluster.ClientFor("a3"); err != nil || client != clients[0] {
+		t.Fatalf("ClientFor(a3) = %v %v", client, err)
+	}
+	if n := countCalls(fakes[0], "aria2.tellStatus"); n != 0 {
+		t.Fatalf("merged gid looked up again: %d calls", n)
+	}
+}
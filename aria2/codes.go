@@ -0,0 +1,140 @@
+package aria2
+
+import "strconv"
+
+// Status 下载的状态 tellStatus结果中的status字段
+type Status string
+
+const (
+	StatusActive   Status = "active"
+	StatusWaiting  Status = "waiting"
+	StatusPaused   Status = "paused"
+	StatusError    Status = "error"
+	StatusComplete Status = "complete"
+	StatusRemoved  Status = "removed"
+)
+
+// IsTerminal 下载已经结束 不会再变化 这类下载出现在tellStopped里
+func (self Status) IsTerminal() bool {
+	return self == StatusError || self == StatusComplete || self == StatusRemoved
+}
+
+// IsQueued 在等待队列里 出现在tellWaiting里
+func (self Status) IsQueued() bool {
+	return self == StatusWaiting || self == StatusPaused
+}
+
+// Valid 是否是aria2定义的状态
+func (self Status) Valid() bool {
+	switch self {
+	case StatusActive, StatusWaiting, StatusPaused, StatusError, StatusComplete, StatusRemoved:
+		return true
+	}
+	return false
+}
+
+// ErrorCode aria2的退出码 也是tellStatus结果中的errorCode 含义见aria2文档EXIT STATUS一节
+type ErrorCode int
+
+const (
+	CodeFinished               ErrorCode = 0
+	CodeUnknownError           ErrorCode = 1
+	CodeTimeout                ErrorCode = 2
+	CodeResourceNotFound       ErrorCode = 3
+	CodeMaxFileNotFound        ErrorCode = 4
+	CodeTooSlowDownloadSpeed   ErrorCode = 5
+	CodeNetworkProblem         ErrorCode = 6
+	CodeInProgress             ErrorCode = 7
+	CodeCannotResume           ErrorCode = 8
+	CodeNotEnoughDiskSpace     ErrorCode = 9
+	CodePieceLengthChanged     ErrorCode = 10
+	CodeDuplicateDownload      ErrorCode = 11
+	CodeDuplicateInfoHash      ErrorCode = 12
+	CodeFileAlreadyExists      ErrorCode = 13
+	CodeFileRenamingFailed     ErrorCode = 14
+	CodeFileOpenError          ErrorCode = 15
+	CodeFileCreateError        ErrorCode = 16
+	CodeFileIoError            ErrorCode = 17
+	CodeDirCreateError         ErrorCode = 18
+	CodeNameResolveError       ErrorCode = 19
+	CodeMetalinkParseError     ErrorCode = 20
+	CodeFtpProtocolError       ErrorCode = 21
+	CodeHttpProtocolError      ErrorCode = 22
+	CodeHttpTooManyRedirects   ErrorCode = 23
+	CodeHttpAuthFailed         ErrorCode = 24
+	CodeBencodeParseError      ErrorCode = 25
+	CodeBittorrentParseError   ErrorCode = 26
+	CodeMagnetParseError       ErrorCode = 27
+	CodeOptionError            ErrorCode = 28
+	CodeHttpServiceUnavailable ErrorCode = 29
+	CodeJsonParseError         ErrorCode = 30
+	CodeRemoved                ErrorCode = 31
+	CodeChecksumError          ErrorCode = 32
+)
+
+type errorCodeInfo struct {
+	name        string
+	description string
+	retryable   bool
+}
+
+var errorCodes = map[ErrorCode]errorCodeInfo{
+	CodeFinished:               {"FINISHED", "all downloads were successful", false},
+	CodeUnknownError:           {"UNKNOWN_ERROR", "an unknown error occurred", false},
+	CodeTimeout:                {"TIME_OUT", "time out occurred", true},
+	CodeResourceNotFound:       {"RESOURCE_NOT_FOUND", "a resource was not found", false},
+	CodeMaxFileNotFound:        {"MAX_FILE_NOT_FOUND", "aria2 saw the specified number of \"resource not found\" errors", false},
+	CodeTooSlowDownloadSpeed:   {"TOO_SLOW_DOWNLOAD_SPEED", "download aborted because download speed was too slow", true},
+	CodeNetworkProblem:         {"NETWORK_PROBLEM", "network problem occurred", true},
+	CodeInProgress:             {"IN_PROGRESS", "there were unfinished downloads when aria2 exited", true},
+	CodeCannotResume:           {"CANNOT_RESUME", "remote server did not support resume when resume was required", false},
+	CodeNotEnoughDiskSpace:     {"NOT_ENOUGH_DISK_SPACE", "there was not enough disk space available", false},
+	CodePieceLengthChanged:     {"PIECE_LENGTH_CHANGED", "piece length was different from the one in the .aria2 control file", false},
+	CodeDuplicateDownload:      {"DUPLICATE_DOWNLOAD", "aria2 was downloading the same file at that moment", false},
+	CodeDuplicateInfoHash:      {"DUPLICATE_INFO_HASH", "aria2 was downloading the same info hash torrent at that moment", false},
+	CodeFileAlreadyExists:      {"FILE_ALREADY_EXISTS", "file already existed", false},
+	CodeFileRenamingFailed:     {"FILE_RENAMING_FAILED", "renaming file failed", false},
+	CodeFileOpenError:          {"FILE_OPEN_ERROR", "could not open existing file", false},
+	CodeFileCreateError:        {"FILE_CREATE_ERROR", "could not create new file or truncate existing file", false},
+	CodeFileIoError:            {"FILE_IO_ERROR", "file I/O error occurred", false},
+	CodeDirCreateError:         {"DIR_CREATE_ERROR", "could not create directory", false},
+	CodeNameResolveError:       {"NAME_RESOLVE_ERROR", "name resolution failed", true},
+	CodeMetalinkParseError:     {"METALINK_PARSE_ERROR", "could not parse Metalink document", false},
+	CodeFtpProtocolError:       {"FTP_PROTOCOL_ERROR", "FTP command failed", true},
+	CodeHttpProtocolError:      {"HTTP_PROTOCOL_ERROR", "HTTP response header was bad or unexpected", false},
+	CodeHttpTooManyRedirects:   {"HTTP_TOO_MANY_REDIRECTS", "too many redirects occurred", false},
+	CodeHttpAuthFailed:         {"HTTP_AUTH_FAILED", "HTTP authorization failed", false},
+	CodeBencodeParseError:      {"BENCODE_PARSE_ERROR", "could not parse bencoded file", false},
+	CodeBittorrentParseError:   {"BITTORRENT_PARSE_ERROR", ".torrent file was corrupted or missing information", false},
+	CodeMagnetParseError:       {"MAGNET_PARSE_ERROR", "magnet URI was bad", false},
+	CodeOptionError:            {"OPTION_ERROR", "bad/unrecognized option was given or unexpected option argument was given", false},
+	CodeHttpServiceUnavailable: {"HTTP_SERVICE_UNAVAILABLE", "remote server was unable to handle the request due to temporary overloading or maintenance", true},
+	CodeJsonParseError:         {"JSON_PARSE_ERROR", "could not parse JSON-RPC request", false},
+	CodeRemoved:                {"REMOVED", "reserved, not used", false},
+	CodeChecksumError:          {"CHECKSUM_ERROR", "checksum validation failed", true},
+}
+
+// Name aria2源码中的名字 例如NETWORK_PROBLEM 未知的退出码返回UNKNOWN(n)
+func (self ErrorCode) Name() string {
+	if info, ok := errorCodes[self]; ok {
+		return info.name
+	}
+	return "UNKNOWN(" + strconv.Itoa(int(self)) + ")"
+}
+
+// Description 退出码的说明
+func (self ErrorCode) Description() string {
+	if info, ok := errorCodes[self]; ok {
+		return info.description
+	}
+	return "unknown error code " + strconv.Itoa(int(self))
+}
+
+// Retryable 重新提交同样的下载是否有可能成功 网络、超时、服务器暂时不可用这类问题返回true
+func (self ErrorCode) Retryable() bool {
+	return errorCodes[self].retryable
+}
+
+func (self ErrorCode) String() string {
+	return self.Name()
+}
@@ -0,0 +1,119 @@
+package aria2
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorCodeRetryable(t *testing.T) {
+	retryable := map[ErrorCode]bool{
+		CodeTimeout:                true,
+		CodeTooSlowDownloadSpeed:   true,
+		CodeNetworkProblem:         true,
+		CodeInProgress:             true,
+		CodeNameResolveError:       true,
+		CodeFtpProtocolError:       true,
+		CodeHttpServiceUnavailable: true,
+		CodeChecksumError:          true,
+	}
+	for code := CodeFinished; code <= CodeChecksumError; code++ {
+		if code.Retryable() != retryable[code] {
+			t.Errorf("%s.Retryable() = %v, want %v", code, code.Retryable(), retryable[code])
+		}
+	}
+	// 磁盘满、无法续传、认证失败这类问题重试没有意义
+	for _, code := range []ErrorCode{CodeNotEnoughDiskSpace, CodeCannotResume, CodeHttpAuthFailed, CodeResourceNotFound} {
+		if code.Retryable() {
+			t.Errorf("%s should not be retryable", code)
+		}
+	}
+	if ErrorCode(99).Retryable() || ErrorCode(-1).Retryable() {
+		t.Error("unknown codes should not be retryable")
+	}
+}
+
+func TestErrorCodeString(t *testing.T) {
+	cases := []struct {
+		code ErrorCode
+		name string
+	}{
+		{CodeFinished, "FINISHED"},
+		{CodeTimeout, "TIME_OUT"},
+		{CodeNetworkProblem, "NETWORK_PROBLEM"},
+		{CodeNotEnoughDiskSpace, "NOT_ENOUGH_DISK_SPACE"},
+		{CodeHttpServiceUnavailable, "HTTP_SERVICE_UNAVAILABLE"},
+		{CodeChecksumError, "CHECKSUM_ERROR"},
+		{ErrorCode(33), "UNKNOWN(33)"},
+		{ErrorCode(-1), "UNKNOWN(-1)"},
+	}
+	for _, c := range cases {
+		if c.code.String() != c.name || c.code.Name() != c.name {
+			t.Errorf("ErrorCode(%d) = %s, want %s", int(c.code), c.code, c.name)
+		}
+	}
+	// 0到32每个退出码都有不同的名字和说明
+	names := make(map[string]ErrorCode)
+	for code := CodeFinished; code <= CodeChecksumError; code++ {
+		name := code.String()
+		if strings.HasPrefix(name, "UNKNOWN(") || strings.ToUpper(name) != name {
+			t.Errorf("ErrorCode(%d) has name %q", int(code), name)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("ErrorCode(%d) and ErrorCode(%d) share name %s", int(code), int(other), name)
+		}
+		names[name] = code
+		if strings.HasPrefix(code.Description(), "unknown error code") {
+			t.Errorf("ErrorCode(%d) has no description", int(code))
+		}
+	}
+	if ErrorCode(40).Description() != "unknown error code 40" {
+		t.Errorf("unknown description %q", ErrorCode(40).Description())
+	}
+}
+
+func TestStatusPredicates(t *testing.T) {
+	cases := []struct {
+		status   Status
+		terminal bool
+		queued   bool
+	}{
+		{StatusActive, false, false},
+		{StatusWaiting, false, true},
+		{StatusPaused, false, true},
+		{StatusError, true, false},
+		{StatusComplete, true, false},
+		{StatusRemoved, true, false},
+	}
+	for _, c := range cases {
+		if !c.status.Valid() || c.status.IsTerminal() != c.terminal || c.status.IsQueued() != c.queued {
+			t.Errorf("%s: valid=%v terminal=%v queued=%v", c.status, c.status.Valid(), c.status.IsTerminal(), c.status.IsQueued())
+		}
+	}
+	if Status("seeding").Valid() {
+		t.Error("unknown status is valid")
+	}
+}
+
+func TestErrorMsgErrorCode(t *testing.T) {
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.addUri" {
+			return nil, &ErrorMsg{Code: int(CodeOptionError), Message: "invalid option"}
+		}
+		return nil, &ErrorMsg{Code: 1, Message: "GID 2089b05ecca3d829 is not found"}
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+
+	_, err := client.TellStatus("2089b05ecca3d829", nil)
+	var rpcErr *ErrorMsg
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("expected *ErrorMsg, got %T %v", err, err)
+	}
+	if rpcErr.ErrorCode() != CodeUnknownError || rpcErr.ErrorCode().Retryable() {
+		t.Fatalf("ErrorCode() = %s", rpcErr.ErrorCode())
+	}
+	_, err = client.AddUri([]string{"http://a/f"}, &map[string]interface{}{"split": "x"}, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.ErrorCode() != CodeOptionError || rpcErr.ErrorCode().String() != "OPTION_ERROR" {
+		t.Fatalf("ErrorCode() = %v for %v", rpcErr.ErrorCode(), err)
+	}
+}
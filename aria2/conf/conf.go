@@ -0,0 +1,289 @@
+// Package conf 解析、校验、写出aria2.conf 并与运行中的aria2全局选项比较和同步
+//
+// aria2.conf每行一个key=value 以#开头的行是注释 header等选项可以出现多次
+package conf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Line 配置文件中的一行 Key为空时是注释或空行 原样保存在Comment里
+type Line struct {
+	Key     string
+	Value   string
+	Comment string
+}
+
+// Config 保持行顺序和注释的配置文件 写回时除了被修改的行之外和原文件一致
+type Config struct {
+	Lines []Line
+}
+
+// ParseError 带行号的解析错误
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (self *ParseError) Error() string {
+	return fmt.Sprintf("conf: line %d: %s", self.Line, self.Msg)
+}
+
+// Parse 解析aria2.conf
+func Parse(r io.Reader) (*Config, error) {
+	config := &Config{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := strings.TrimRight(scanner.Text(), "\r")
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			config.Lines = append(config.Lines, Line{Comment: raw})
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, &ParseError{Line: lineNo, Msg: fmt.Sprintf("expected key=value, got %q", line)}
+		}
+		config.Lines = append(config.Lines, Line{Key: strings.TrimSpace(line[:i]), Value: strings.TrimSpace(line[i+1:])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseFile 解析文件
+func ParseFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Get 返回key第一次出现的值
+func (self *Config) Get(key string) (string, bool) {
+	for _, line := range self.Lines {
+		if line.Key == key {
+			return line.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll 返回key的所有值
+func (self *Config) GetAll(key string) []string {
+	values := make([]string, 0)
+	for _, line := range self.Lines {
+		if line.Key == key {
+			values = append(values, line.Value)
+		}
+	}
+	return values
+}
+
+// Set 修改key第一次出现的那一行并删除其余的 key不存在时追加到末尾
+func (self *Config) Set(key string, value string) {
+	lines := make([]Line, 0, len(self.Lines)+1)
+	replaced := false
+	for _, line := range self.Lines {
+		if line.Key != key {
+			lines = append(lines, line)
+		} else if !replaced {
+			lines = append(lines, Line{Key: key, Value: value})
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, Line{Key: key, Value: value})
+	}
+	self.Lines = lines
+}
+
+// Add 追加一行 用于header这类可以重复的选项
+func (self *Config) Add(key string, value string) {
+	self.Lines = append(self.Lines, Line{Key: key, Value: value})
+}
+
+// Del 删除key的所有行
+func (self *Config) Del(key string) {
+	lines := self.Lines[:0]
+	for _, line := range self.Lines {
+		if line.Key != key {
+			lines = append(lines, line)
+		}
+	}
+	self.Lines = lines
+}
+
+// Keys 出现过的key 按首次出现的顺序
+func (self *Config) Keys() []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, line := range self.Lines {
+		if line.Key != "" && !seen[line.Key] {
+			seen[line.Key] = true
+			keys = append(keys, line.Key)
+		}
+	}
+	return keys
+}
+
+// Options 转换成changeGlobalOption/addUri可以使用的options 重复的key变成字符串数组
+func (self *Config) Options() map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, line := range self.Lines {
+		if line.Key == "" {
+			continue
+		}
+		aria2.MergeOption(m, line.Key, line.Value)
+	}
+	return m
+}
+
+// WriteTo 按aria2.conf格式写出
+func (self *Config) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, line := range self.Lines {
+		if line.Key == "" {
+			buf.WriteString(line.Comment)
+		} else {
+			buf.WriteString(line.Key + "=" + line.Value)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
+
+// WriteFile 写到文件 先写临时文件再改名
+func (self *Config) WriteFile(path string) error {
+	var buf bytes.Buffer
+	if _, err := self.WriteTo(&buf); err != nil {
+		return err
+	}
+	return aria2.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// ValidationError 校验发现的问题 Line从1开始
+type ValidationError struct {
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (self *ValidationError) Error() string {
+	return fmt.Sprintf("conf: line %d: %s: %s", self.Line, self.Key, self.Msg)
+}
+
+// Validate 检查未知选项、布尔值写法以及不能重复的选项 没有问题时返回nil
+func (self *Config) Validate() []*ValidationError {
+	var problems []*ValidationError
+	seen := make(map[string]int)
+	for i, line := range self.Lines {
+		if line.Key == "" {
+			continue
+		}
+		lineNo := i + 1
+		if !IsKnown(line.Key) {
+			problems = append(problems, &ValidationError{Line: lineNo, Key: line.Key, Msg: "unknown option"})
+			continue
+		}
+		if booleanSet[line.Key] && line.Value != "true" && line.Value != "false" {
+			problems = append(problems, &ValidationError{Line: lineNo, Key: line.Key, Msg: fmt.Sprintf("expected true or false, got %q", line.Value)})
+		}
+		if sizeSet[line.Key] {
+			if _, err := aria2.ParseSize(line.Value); err != nil {
+				problems = append(problems, &ValidationError{Line: lineNo, Key: line.Key, Msg: fmt.Sprintf("invalid size %q", line.Value)})
+			}
+		}
+		if first, ok := seen[line.Key]; ok && !repeatableSet[line.Key] {
+			problems = append(problems, &ValidationError{Line: lineNo, Key: line.Key, Msg: fmt.Sprintf("already set on line %d", first)})
+		}
+		if _, ok := seen[line.Key]; !ok {
+			seen[line.Key] = lineNo
+		}
+	}
+	return problems
+}
+
+// Change 配置文件和运行中的aria2不一致的一项 Live为空且Missing为true表示aria2没有返回这个选项
+type Change struct {
+	Key        string
+	Local      string
+	Live       string
+	Missing    bool
+	Changeable bool // 能否通过changeGlobalOption直接修改 否则需要重启aria2
+}
+
+// Diff 比较配置文件和GetGlobalOption的结果 只比较配置文件里出现的key
+// 大小类选项按字节数比较 2M和2097152视为相同 可以重复的选项比较时忽略
+func (self *Config) Diff(live map[string]interface{}) []Change {
+	changes := make([]Change, 0)
+	for _, key := range self.Keys() {
+		if repeatableSet[key] {
+			continue
+		}
+		local, _ := self.Get(key)
+		value, ok := live[key]
+		if !ok {
+			changes = append(changes, Change{Key: key, Local: local, Missing: true, Changeable: IsChangeable(key)})
+			continue
+		}
+		liveStr := fmt.Sprint(value)
+		if !sameValue(key, local, liveStr) {
+			changes = append(changes, Change{Key: key, Local: local, Live: liveStr, Changeable: IsChangeable(key)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+func sameValue(key string, a string, b string) bool {
+	if a == b {
+		return true
+	}
+	if sizeSet[key] {
+		x, err1 := aria2.ParseSize(a)
+		y, err2 := aria2.ParseSize(b)
+		return err1 == nil && err2 == nil && x == y
+	}
+	return false
+}
+
+// Sync 读取aria2的全局选项 把能在运行时修改的差异用changeGlobalOption下发
+// 返回已经下发的差异和需要重启aria2才能生效的差异
+func (self *Config) Sync(client *aria2.Aria2Client) (applied []Change, pending []Change, err error) {
+	res, err := client.GetGlobalOption()
+	if err != nil {
+		return nil, nil, err
+	}
+	live, ok := res.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("conf: unexpected getGlobalOption result %T", res)
+	}
+	options := make(map[string]interface{})
+	for _, change := range self.Diff(live) {
+		if change.Changeable {
+			options[change.Key] = change.Local
+			applied = append(applied, change)
+		} else {
+			pending = append(pending, change)
+		}
+	}
+	if len(options) > 0 {
+		if _, err := client.ChangeGlobalOption(options); err != nil {
+			return nil, pending, err
+		}
+	}
+	return applied, pending, nil
+}
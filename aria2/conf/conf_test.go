@@ -0,0 +1,220 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2"
+	"github.com/synodriver/goaria2/aria2/internal/fakearia2"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const confText = `# aria2.conf
+dir=/data/downloads
+
+## 连接
+max-connection-per-server = 16
+min-split-size=2M
+header=Authorization: Basic eA==
+header=X-Client: goaria2
+max-concurrent-downloads=5
+`
+
+func mustParse(t *testing.T, text string) *Config {
+	t.Helper()
+	config, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestParse(t *testing.T) {
+	config := mustParse(t, confText)
+	if dir, ok := config.Get("dir"); !ok || dir != "/data/downloads" {
+		t.Fatalf("dir = %q %v", dir, ok)
+	}
+	// 等号两边的空格被去掉
+	if n, _ := config.Get("max-connection-per-server"); n != "16" {
+		t.Fatalf("max-connection-per-server = %q", n)
+	}
+	if headers := config.GetAll("header"); fmt.Sprint(headers) != "[Authorization: Basic eA== X-Client: goaria2]" {
+		t.Fatalf("headers %q", headers)
+	}
+	if _, ok := config.Get("split"); ok {
+		t.Fatal("missing key found")
+	}
+	if keys := strings.Join(config.Keys(), " "); keys != "dir max-connection-per-server min-split-size header max-concurrent-downloads" {
+		t.Fatalf("keys %s", keys)
+	}
+	options := config.Options()
+	if fmt.Sprint(options["header"]) != "[Authorization: Basic eA== X-Client: goaria2]" || options["dir"] != "/data/downloads" {
+		t.Fatalf("options %v", options)
+	}
+
+	_, err := Parse(strings.NewReader("dir=/data\nnot an option\n"))
+	if parseErr, ok := err.(*ParseError); !ok || parseErr.Line != 2 {
+		t.Fatalf("expected ParseError on line 2, got %v", err)
+	}
+	if _, err := Parse(strings.NewReader("=value\n")); err == nil {
+		t.Fatal("empty key accepted")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	config := mustParse(t, confText)
+	var buf bytes.Buffer
+	if _, err := config.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// 注释、空行和重复的header保持原样 只有key=value周围的空格被规范化
+	want := strings.Replace(confText, "max-connection-per-server = 16", "max-connection-per-server=16", 1)
+	if buf.String() != want {
+		t.Fatalf("round trip:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	path := filepath.Join(t.TempDir(), "aria2.conf")
+	if err := config.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fromFile.Lines) != fmt.Sprint(config.Lines) {
+		t.Fatalf("file round trip:\n%v\n%v", fromFile.Lines, config.Lines)
+	}
+}
+
+func TestSetAddDel(t *testing.T) {
+	config := mustParse(t, confText)
+	// Set修改第一次出现的位置并删除其余的
+	config.Set("header", "X-Only: 1")
+	config.Set("split", "8")
+	config.Set("dir", "/mnt")
+	var buf bytes.Buffer
+	config.WriteTo(&buf)
+	want := `# aria2.conf
+dir=/mnt
+
+## 连接
+max-connection-per-server=16
+min-split-size=2M
+header=X-Only: 1
+max-concurrent-downloads=5
+split=8
+`
+	if buf.String() != want {
+		t.Fatalf("after Set:\n%s", buf.String())
+	}
+
+	config.Add("header", "X-Two: 2")
+	if fmt.Sprint(config.GetAll("header")) != "[X-Only: 1 X-Two: 2]" {
+		t.Fatalf("after Add %q", config.GetAll("header"))
+	}
+	config.Del("header")
+	config.Del("missing")
+	if len(config.GetAll("header")) != 0 || len(config.Lines) != 8 {
+		t.Fatalf("after Del: %v", config.Lines)
+	}
+	// 注释不受影响
+	if config.Lines[0].Comment != "# aria2.conf" || config.Lines[3].Comment != "## 连接" {
+		t.Fatalf("comments changed: %v", config.Lines)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	config := mustParse(t, `dir=/data
+enable-rpc=yes
+rpc-listen-all=true
+max-download-limit=10X
+min-split-size=1M
+no-such-option=1
+dir=/other
+header=A: 1
+header=B: 2
+`)
+	problems := config.Validate()
+	got := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		got = append(got, fmt.Sprintf("%d:%s", problem.Line, problem.Key))
+	}
+	if strings.Join(got, " ") != "2:enable-rpc 4:max-download-limit 6:no-such-option 7:dir" {
+		t.Fatalf("problems %v", problems)
+	}
+	if !strings.Contains(problems[3].Error(), "already set on line 1") {
+		t.Fatalf("duplicate message: %v", problems[3])
+	}
+	if problems := mustParse(t, confText).Validate(); problems != nil {
+		t.Fatalf("valid config reported %v", problems)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	config := mustParse(t, confText)
+	live := map[string]interface{}{
+		"dir":                       "/data/downloads",
+		"max-connection-per-server": "4",
+		"min-split-size":            "2097152", // aria2返回字节数
+		"header":                    "ignored",
+	}
+	changes := config.Diff(live)
+	want := []Change{
+		{Key: "max-concurrent-downloads", Local: "5", Missing: true, Changeable: true},
+		{Key: "max-connection-per-server", Local: "16", Live: "4", Changeable: true},
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("diff %+v", changes)
+	}
+
+	live["min-split-size"] = "1048576"
+	live["max-concurrent-downloads"] = "5"
+	live["max-connection-per-server"] = "16"
+	changes = config.Diff(live)
+	if len(changes) != 1 || changes[0].Key != "min-split-size" || changes[0].Live != "1048576" {
+		t.Fatalf("diff %+v", changes)
+	}
+}
+
+func TestSync(t *testing.T) {
+	var changed map[string]interface{}
+	fake := fakearia2.New(t, func(method string, params []interface{}) (interface{}, *fakearia2.Error) {
+		switch method {
+		case "aria2.getGlobalOption":
+			return map[string]interface{}{
+				"dir": "/tmp", "max-connection-per-server": "16", "min-split-size": "2097152",
+				"max-concurrent-downloads": "5", "rpc-listen-port": "6800",
+			}, nil
+		case "aria2.changeGlobalOption":
+			changed = params[0].(map[string]interface{})
+			return "OK", nil
+		}
+		return fakearia2.Version(method, params)
+	})
+	client := aria2.NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, aria2.NewNetHttpRequestHandler(nil))
+	config := mustParse(t, confText+"rpc-listen-port=6801\n")
+	applied, pending, err := config.Sync(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Key != "dir" {
+		t.Fatalf("applied %+v", applied)
+	}
+	if len(pending) != 1 || pending[0].Key != "rpc-listen-port" || pending[0].Changeable {
+		t.Fatalf("pending %+v", pending)
+	}
+	if fmt.Sprint(changed) != "map[dir:/data/downloads]" {
+		t.Fatalf("changeGlobalOption %v", changed)
+	}
+
+	// 没有可以下发的差异时不调用changeGlobalOption
+	calls := len(fake.Calls())
+	config.Set("dir", "/tmp")
+	if _, _, err := config.Sync(client); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls()[calls:]; fmt.Sprint(got) != "[aria2.getGlobalOption]" {
+		t.Fatalf("calls %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package conf
+
+// 可以写在input-file里、也能通过rpc给单个下载设置的选项
+var inputFileOptions = []string{
+	"all-proxy", "all-proxy-passwd", "all-proxy-user", "allow-overwrite", "allow-piece-length-change",
+	"always-resume", "async-dns", "auto-file-renaming", "bt-enable-hook-after-hash-check", "bt-enable-lpd",
+	"bt-exclude-tracker", "bt-external-ip", "bt-force-encryption", "bt-hash-check-seed", "bt-load-saved-metadata",
+	"bt-max-peers", "bt-metadata-only", "bt-min-crypto-level", "bt-prioritize-piece", "bt-remove-unselected-file",
+	"bt-request-peer-speed-limit", "bt-require-crypto", "bt-save-metadata", "bt-seed-unverified", "bt-stop-timeout",
+	"bt-tracker", "bt-tracker-connect-timeout", "bt-tracker-interval", "bt-tracker-timeout", "check-integrity",
+	"checksum", "conditional-get", "connect-timeout", "content-disposition-default-utf8", "continue", "dir",
+	"dry-run", "enable-http-keep-alive", "enable-http-pipelining", "enable-mmap", "enable-peer-exchange",
+	"file-allocation", "follow-metalink", "follow-torrent", "force-save", "ftp-passwd", "ftp-pasv", "ftp-proxy",
+	"ftp-proxy-passwd", "ftp-proxy-user", "ftp-reuse-connection", "ftp-type", "ftp-user", "gid", "hash-check-only",
+	"header", "http-accept-gzip", "http-auth-challenge", "http-no-cache", "http-passwd", "http-proxy",
+	"http-proxy-passwd", "http-proxy-user", "http-user", "https-proxy", "https-proxy-passwd", "https-proxy-user",
+	"index-out", "lowest-speed-limit", "max-connection-per-server", "max-download-limit", "max-file-not-found",
+	"max-mmap-limit", "max-resume-failure-tries", "max-tries", "max-upload-limit", "metalink-base-uri",
+	"metalink-enable-unique-protocol", "metalink-language", "metalink-location", "metalink-os",
+	"metalink-preferred-protocol", "metalink-version", "min-split-size", "no-file-allocation-limit", "no-netrc",
+	"no-proxy", "no-want-digest-header", "out", "parameterized-uri", "pause", "pause-metadata", "piece-length",
+	"proxy-method", "realtime-chunk-checksum", "referer", "remote-time", "remove-control-file", "retry-wait",
+	"reuse-uri", "rpc-save-upload-metadata", "seed-ratio", "seed-time", "select-file", "split", "ssh-host-key-md",
+	"stream-piece-selector", "timeout", "uri-selector", "use-head", "user-agent",
+}
+
+// 只能作为全局选项使用的选项
+var globalOnlyOptions = []string{
+	"async-dns-server", "auto-save-interval", "bt-detach-seed-only", "bt-lpd-interface", "bt-max-open-files",
+	"ca-certificate", "certificate", "check-certificate", "conf-path", "console-log-level", "daemon",
+	"deferred-input", "dht-entry-point", "dht-entry-point6", "dht-file-path", "dht-file-path6",
+	"dht-listen-addr6", "dht-listen-port", "dht-message-timeout", "disable-ipv6", "disk-cache", "download-result",
+	"dscp", "enable-color", "enable-dht", "enable-dht6", "enable-rpc", "event-poll", "force-sequential",
+	"human-readable", "input-file", "interface", "keep-unfinished-download-result", "listen-port", "load-cookies",
+	"log", "log-level", "max-concurrent-downloads", "max-download-result", "max-overall-download-limit",
+	"max-overall-upload-limit", "metalink-file", "min-tls-version", "multiple-interface", "netrc-path", "no-conf",
+	"on-bt-download-complete", "on-download-complete", "on-download-error", "on-download-pause",
+	"on-download-start", "on-download-stop", "optimize-concurrent-downloads", "peer-agent", "peer-id-prefix",
+	"private-key", "quiet", "rlimit-nofile", "rpc-allow-origin-all", "rpc-certificate", "rpc-listen-all",
+	"rpc-listen-port", "rpc-max-request-size", "rpc-passwd", "rpc-private-key", "rpc-secret", "rpc-secure",
+	"rpc-user", "save-cookies", "save-not-found", "save-session", "save-session-interval", "server-stat-if",
+	"server-stat-of", "server-stat-timeout", "show-console-readout", "show-files", "socket-recv-buffer-size",
+	"stderr", "stop", "stop-with-process", "summary-interval", "torrent-file", "truncate-console-readout",
+}
+
+// 运行时可以用changeGlobalOption修改的全局选项 另外还包括除了下面几项之外的所有input-file选项
+var changeableGlobalOptions = []string{
+	"bt-max-open-files", "download-result", "keep-unfinished-download-result", "log", "log-level",
+	"max-concurrent-downloads", "max-download-result", "max-overall-download-limit", "max-overall-upload-limit",
+	"optimize-concurrent-downloads", "save-cookies", "save-session", "server-stat-of",
+}
+
+// 不能通过changeGlobalOption修改的input-file选项
+var unchangeableInputOptions = []string{"checksum", "index-out", "out", "pause", "select-file"}
+
+// 取值只能是true/false的选项
+var booleanOptions = []string{
+	"allow-overwrite", "allow-piece-length-change", "always-resume", "async-dns", "auto-file-renaming",
+	"bt-detach-seed-only", "bt-enable-hook-after-hash-check", "bt-enable-lpd", "bt-force-encryption",
+	"bt-hash-check-seed", "bt-load-saved-metadata", "bt-metadata-only", "bt-remove-unselected-file",
+	"bt-require-crypto", "bt-save-metadata", "bt-seed-unverified", "check-certificate", "check-integrity",
+	"conditional-get", "content-disposition-default-utf8", "continue", "daemon", "deferred-input",
+	"disable-ipv6", "dry-run", "enable-color", "enable-dht", "enable-dht6", "enable-http-keep-alive",
+	"enable-http-pipelining", "enable-mmap", "enable-peer-exchange", "enable-rpc",
+	"force-save", "force-sequential", "ftp-pasv", "ftp-reuse-connection", "hash-check-only", "http-accept-gzip",
+	"http-auth-challenge", "http-no-cache", "human-readable", "keep-unfinished-download-result",
+	"metalink-enable-unique-protocol", "no-conf", "no-netrc", "no-want-digest-header", "parameterized-uri",
+	"pause", "pause-metadata", "quiet", "realtime-chunk-checksum", "remote-time", "remove-control-file",
+	"reuse-uri", "rpc-allow-origin-all", "rpc-listen-all", "rpc-save-upload-metadata", "rpc-secure",
+	"save-not-found", "show-console-readout", "show-files", "truncate-console-readout", "use-head",
+}
+
+// 可以出现多次的选项
+var repeatableOptions = []string{"header", "index-out"}
+
+// 值是带K/M/G单位的大小 比较时需要换算成字节 aria2返回的是字节数
+var sizeOptions = []string{
+	"disk-cache", "lowest-speed-limit", "max-download-limit", "max-mmap-limit", "max-overall-download-limit",
+	"max-overall-upload-limit", "max-upload-limit", "min-split-size", "no-file-allocation-limit", "piece-length",
+	"rpc-max-request-size", "socket-recv-buffer-size",
+}
+
+func toSet(lists ...[]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, list := range lists {
+		for _, item := range list {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+var (
+	knownSet      = toSet(inputFileOptions, globalOnlyOptions)
+	booleanSet    = toSet(booleanOptions)
+	repeatableSet = toSet(repeatableOptions)
+	sizeSet       = toSet(sizeOptions)
+	changeableSet = func() map[string]bool {
+		set := toSet(changeableGlobalOptions, inputFileOptions)
+		for _, key := range unchangeableInputOptions {
+			delete(set, key)
+		}
+		return set
+	}()
+)
+
+// IsKnown key是否是aria2认识的选项
+func IsKnown(key string) bool {
+	return knownSet[key]
+}
+
+// IsChangeable key能否在运行时通过changeGlobalOption修改
+func IsChangeable(key string) bool {
+	return changeableSet[key]
+}
@@ -0,0 +1,259 @@
+package aria2
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProxy 进程内的代理 记录成功建立的隧道数
+type testProxy struct {
+	listener net.Listener
+	tunnels  int32
+}
+
+func (self *testProxy) Addr() string {
+	return self.listener.Addr().String()
+}
+
+func (self *testProxy) Tunnels() int {
+	return int(atomic.LoadInt32(&self.tunnels))
+}
+
+func newTestProxy(t *testing.T, serve func(proxy *testProxy, conn net.Conn)) *testProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &testProxy{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(proxy, conn)
+		}
+	}()
+	return proxy
+}
+
+// tunnel 连接目标后在两个方向转发 直到任意一端关闭
+func (self *testProxy) tunnel(client net.Conn, addr string, ready func() error) {
+	defer client.Close()
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	if err := ready(); err != nil {
+		return
+	}
+	atomic.AddInt32(&self.tunnels, 1)
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(target, client); done <- struct{}{} }()
+	go func() { io.Copy(client, target); done <- struct{}{} }()
+	<-done
+}
+
+// newHttpConnectProxy user为空时不要求认证
+func newHttpConnectProxy(t *testing.T, user string, password string) *testProxy {
+	return newTestProxy(t, func(proxy *testProxy, conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != "CONNECT" {
+			conn.Close()
+			return
+		}
+		if user != "" {
+			expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+			if req.Header.Get("Proxy-Authorization") != expected {
+				io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+				conn.Close()
+				return
+			}
+		}
+		proxy.tunnel(conn, req.Host, func() error {
+			_, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			return err
+		})
+	})
+}
+
+// newSocks5Proxy user为空时不要求认证 authVersion是用户名密码子协商回复的版本号 正常为0x01
+func newSocks5Proxy(t *testing.T, user string, password string, authVersion byte) *testProxy {
+	return newTestProxy(t, func(proxy *testProxy, conn net.Conn) {
+		fail := func() { conn.Close() }
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil || header[0] != socks5Version {
+			fail()
+			return
+		}
+		methods := make([]byte, header[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			fail()
+			return
+		}
+		method := byte(socks5AuthNone)
+		if user != "" {
+			method = socks5AuthNoAcceptable
+			for _, m := range methods {
+				if m == socks5AuthPassword {
+					method = socks5AuthPassword
+				}
+			}
+		}
+		conn.Write([]byte{socks5Version, method})
+		switch method {
+		case socks5AuthNoAcceptable:
+			fail()
+			return
+		case socks5AuthPassword:
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(conn, b); err != nil || b[0] != socks5AuthVersion {
+				fail()
+				return
+			}
+			name := make([]byte, b[1])
+			io.ReadFull(conn, name)
+			io.ReadFull(conn, b[:1])
+			pass := make([]byte, b[0])
+			io.ReadFull(conn, pass)
+			if string(name) != user || string(pass) != password {
+				conn.Write([]byte{authVersion, 0x01})
+				fail()
+				return
+			}
+			conn.Write([]byte{authVersion, 0x00})
+		}
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil || req[1] != socks5CmdConnect {
+			fail()
+			return
+		}
+		var host string
+		switch req[3] {
+		case socks5AtypIPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AtypIPv6:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AtypDomain:
+			n := make([]byte, 1)
+			io.ReadFull(conn, n)
+			name := make([]byte, n[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			fail()
+			return
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		proxy.tunnel(conn, addr, func() error {
+			_, err := conn.Write([]byte{socks5Version, 0x00, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+			return err
+		})
+	})
+}
+
+// callThroughProxy 分别用三种handler经过proxyUrl调用getVersion
+func callThroughProxy(t *testing.T, fake *fakeAria2, proxyUrl string) map[string]error {
+	errs := make(map[string]error)
+
+	fastHandler := NewHttpRequestHandler()
+	if err := fastHandler.SetProxy(proxyUrl); err != nil {
+		t.Fatal(err)
+	}
+	_, errs["fasthttp"] = NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, fastHandler).GetVersion()
+
+	netHandler := NewNetHttpRequestHandler(nil)
+	if err := netHandler.SetProxy(proxyUrl); err != nil {
+		t.Fatal(err)
+	}
+	_, errs["net/http"] = NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, netHandler).GetVersion()
+
+	wsHandler := NewWebsocketRequestHandler()
+	wsHandler.SetTimeout(5 * time.Second)
+	if err := wsHandler.SetProxy(proxyUrl); err != nil {
+		t.Fatal(err)
+	}
+	_, errs["websocket"] = NewAria2Client(fake.RpcUrl(true), nil, nil, nil, nil, wsHandler).GetVersion()
+	wsHandler.Close()
+	return errs
+}
+
+func TestProxyDialContext(t *testing.T) {
+	fake := newFakeAria2(t, versionHandler)
+	cases := []struct {
+		name  string
+		proxy *testProxy
+		url   func(addr string) string
+	}{
+		{"http", newHttpConnectProxy(t, "", ""), func(addr string) string { return "http://" + addr }},
+		{"http auth", newHttpConnectProxy(t, "bastion", "s3cret"), func(addr string) string { return "http://bastion:s3cret@" + addr }},
+		{"socks5", newSocks5Proxy(t, "", "", socks5AuthVersion), func(addr string) string { return "socks5://" + addr }},
+		{"socks5 auth", newSocks5Proxy(t, "bastion", "s3cret", socks5AuthVersion), func(addr string) string { return "socks5://bastion:s3cret@" + addr }},
+		{"socks5h auth", newSocks5Proxy(t, "bastion", "s3cret", socks5AuthVersion), func(addr string) string { return "socks5h://bastion:s3cret@" + addr }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for handler, err := range callThroughProxy(t, fake, c.url(c.proxy.Addr())) {
+				if err != nil {
+					t.Errorf("%s: %v", handler, err)
+				}
+			}
+			if n := c.proxy.Tunnels(); n != 3 {
+				t.Errorf("expected 3 tunnels, got %d", n)
+			}
+		})
+	}
+}
+
+func TestProxyDialContextBadCredentials(t *testing.T) {
+	fake := newFakeAria2(t, versionHandler)
+	cases := []struct {
+		name     string
+		proxyUrl string
+	}{
+		{"http", "http://bastion:wrong@" + newHttpConnectProxy(t, "bastion", "s3cret").Addr()},
+		{"http missing", "http://" + newHttpConnectProxy(t, "bastion", "s3cret").Addr()},
+		{"socks5", "socks5://bastion:wrong@" + newSocks5Proxy(t, "bastion", "s3cret", socks5AuthVersion).Addr()},
+		{"socks5 missing", "socks5://" + newSocks5Proxy(t, "bastion", "s3cret", socks5AuthVersion).Addr()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for handler, err := range callThroughProxy(t, fake, c.proxyUrl) {
+				if err == nil {
+					t.Errorf("%s: expected error", handler)
+				}
+			}
+		})
+	}
+}
+
+func TestSocks5RejectsAuthReplyVersion(t *testing.T) {
+	proxy := newSocks5Proxy(t, "bastion", "s3cret", socks5Version)
+	dial, err := ProxyDialContext("socks5://bastion:s3cret@"+proxy.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dial(context.Background(), "tcp", "127.0.0.1:1")
+	if err == nil || !strings.Contains(err.Error(), "auth version") {
+		t.Fatalf("expected auth version error, got %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNoHealthyEndpoint 所有端点都不可用
+var ErrNoHealthyEndpoint = errors.New("aria2: no healthy endpoint")
+
+// FailoverRequestHandler 把一组有序的端点当成同一个aria2服务使用
+// 平时只使用当前端点 出现暂时性错误时用getVersion检查后续端点并切换过去
+// 切换后会停留在新端点上(除非开启FailBack) websocket通知也只从当前端点接收
+type FailoverRequestHandler struct {
+	Endpoints []string
+	// NewHandler 为每个端点创建handler nil时ws://和wss://使用WebsocketRequestHandler 其余使用HttpRequestHandler
+	NewHandler func(url string) IRequestHandler
+	// HealthCheckInterval Monitor检查端点的间隔 0表示30s
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout 单次getVersion检查的超时 0表示5s
+	HealthCheckTimeout time.Duration
+	// FailBack 首选端点恢复后是否切回去 websocket场景下切换会丢失切换期间的通知
+	FailBack bool
+	// OnServed 每次调用结束后告知是哪个端点处理的
+	OnServed func(endpoint string, req RpcRequest, err error)
+	// OnFailover 切换端点时调用
+	OnFailover func(from string, to string, err error)
+
+	client    *Aria2Client
+	timeout   time.Duration
+	handlers  []IRequestHandler
+	current   int
+	functions map[string][]Callback
+	lock      sync.Mutex
+}
+
+// NewFailoverRequestHandler endpoints按优先级排列 第一个是首选端点
+func NewFailoverRequestHandler(endpoints ...string) *FailoverRequestHandler {
+	return &FailoverRequestHandler{Endpoints: endpoints, functions: make(map[string][]Callback, 5)}
+}
+
+// SetUrl 如果url不在端点列表里 就把它作为首选端点插到最前面
+func (self *FailoverRequestHandler) SetUrl(url string) {
+	if url == "" {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, endpoint := range self.Endpoints {
+		if endpoint == url {
+			return
+		}
+	}
+	self.Endpoints = append([]string{url}, self.Endpoints...)
+	if self.handlers != nil {
+		self.handlers = append([]IRequestHandler{nil}, self.handlers...)
+		self.current++
+	}
+}
+
+func (self *FailoverRequestHandler) SetClient(c *Aria2Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.client = c
+	for _, handler := range self.handlers {
+		if v, ok := handler.(notificationHandler); ok {
+			v.SetClient(c)
+		}
+	}
+}
+
+func (self *FailoverRequestHandler) SetTimeout(t time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.timeout = t
+	for _, handler := range self.handlers {
+		if v, ok := handler.(notificationHandler); ok {
+			v.SetTimeout(t)
+		}
+	}
+}
+
+// Current 当前使用的端点
+func (self *FailoverRequestHandler) Current() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.Endpoints) == 0 {
+		return ""
+	}
+	return self.Endpoints[self.current]
+}
+
+func (self *FailoverRequestHandler) start() error {
+	_, _, _, err := self.active()
+	return err
+}
+
+// endpoints 返回Endpoints的副本 SetUrl可能同时修改它
+func (self *FailoverRequestHandler) endpoints() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string(nil), self.Endpoints...)
+}
+
+// newHandler 按NewHandler或者url的scheme创建handler 不注册回调
+func (self *FailoverRequestHandler) newHandler(url string) IRequestHandler {
+	var handler IRequestHandler
+	if self.NewHandler != nil {
+		handler = self.NewHandler(url)
+	} else if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://") {
+		handler = NewWebsocketRequestHandler()
+	} else {
+		handler = NewHttpRequestHandler()
+	}
+	handler.SetUrl(url)
+	if v, ok := handler.(notificationHandler); ok {
+		v.SetClient(self.client)
+		if self.timeout > 0 {
+			v.SetTimeout(self.timeout)
+		}
+	}
+	return handler
+}
+
+// handlerAt 返回端点对应的handler 需要时创建并注册回调 调用时必须持有lock
+func (self *FailoverRequestHandler) handlerAt(index int) (IRequestHandler, bool) {
+	if self.handlers == nil {
+		self.handlers = make([]IRequestHandler, len(self.Endpoints))
+	}
+	if handler := self.handlers[index]; handler != nil {
+		return handler, false
+	}
+	handler := self.newHandler(self.Endpoints[index])
+	if v, ok := handler.(notificationHandler); ok {
+		for type_, functions := range self.functions {
+			for _, function := range functions {
+				register(v, function, type_)
+			}
+		}
+	}
+	self.handlers[index] = handler
+	return handler, true
+}
+
+// connect 取得端点的handler websocket会在这里建立连接
+func (self *FailoverRequestHandler) connect(index int) (IRequestHandler, error) {
+	self.lock.Lock()
+	handler, created := self.handlerAt(index)
+	self.lock.Unlock()
+	if v, ok := handler.(notificationHandler); ok && created {
+		if err := v.start(); err != nil {
+			self.drop(index, handler)
+			return nil, err
+		}
+	}
+	return handler, nil
+}
+
+// drop 关闭并丢弃端点的handler 下次使用时重新创建
+func (self *FailoverRequestHandler) drop(index int, handler IRequestHandler) {
+	self.lock.Lock()
+	if index < len(self.handlers) && self.handlers[index] == handler {
+		self.handlers[index] = nil
+	}
+	self.lock.Unlock()
+	if v, ok := handler.(interface{ Close() error }); ok {
+		v.Close()
+	}
+}
+
+func (self *FailoverRequestHandler) active() (int, string, IRequestHandler, error) {
+	self.lock.Lock()
+	if len(self.Endpoints) == 0 {
+		self.lock.Unlock()
+		return 0, "", nil, ErrNoHealthyEndpoint
+	}
+	index := self.current
+	endpoint := self.Endpoints[index]
+	self.lock.Unlock()
+	handler, err := self.connect(index)
+	return index, endpoint, handler, err
+}
+
+func sendTo(ctx context.Context, handler IRequestHandler, req RpcRequest) (RpcResponse, error) {
+	if v, ok := handler.(IContextRequestHandler); ok {
+		return v.SendRequestContext(ctx, req)
+	}
+	result, err := handler.SendRequest(req)
+	if err != nil {
+		return RpcResponse{}, err
+	}
+	return RpcResponse{Jsonrpc: req.Jsonrpc, Result: result}, nil
+}
+
+// check 用getVersion检查端点是否可用
+// 非当前端点用一个不注册回调的临时handler检查 用完即关闭 避免同时收到两个端点的通知
+func (self *FailoverRequestHandler) check(ctx context.Context, index int) error {
+	self.lock.Lock()
+	if index >= len(self.Endpoints) {
+		self.lock.Unlock()
+		return ErrNoHealthyEndpoint
+	}
+	current := index == self.current
+	var probe IRequestHandler
+	if !current {
+		probe = self.newHandler(self.Endpoints[index])
+	}
+	client := self.client
+	self.lock.Unlock()
+	var handler IRequestHandler
+	if current {
+		var err error
+		if handler, err = self.connect(index); err != nil {
+			return err
+		}
+	} else {
+		handler = probe
+		if v, ok := handler.(interface{ Close() error }); ok {
+			defer v.Close()
+		}
+		if v, ok := handler.(notificationHandler); ok {
+			if err := v.start(); err != nil {
+				return err
+			}
+		}
+	}
+	timeout := self.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var req RpcRequest
+	if client != nil {
+		req = client.newRequest("getVersion", make([]interface{}, 0), "aria2.")
+	} else {
+		req = RpcRequest{Jsonrpc: "2.0", Id: "healthcheck", Method: "aria2.getVersion", Params: make([]interface{}, 0)}
+	}
+	_, err := sendTo(ctx, handler, req)
+	return err
+}
+
+// switchTo 把当前端点切换到index 关闭旧端点的websocket连接 保证通知只来自一个端点
+func (self *FailoverRequestHandler) switchTo(from int, to int, cause error) {
+	self.lock.Lock()
+	if self.current != from {
+		self.lock.Unlock()
+		return
+	}
+	self.current = to
+	var old IRequestHandler
+	if from < len(self.handlers) {
+		old = self.handlers[from]
+	}
+	fromUrl, toUrl := self.Endpoints[from], self.Endpoints[to]
+	self.lock.Unlock()
+	if _, ok := old.(notificationHandler); ok {
+		self.drop(from, old)
+	}
+	if self.OnFailover != nil {
+		self.OnFailover(fromUrl, toUrl, cause)
+	}
+}
+
+// failover 从from之后的端点里找一个健康的切换过去
+func (self *FailoverRequestHandler) failover(ctx context.Context, from int, cause error) error {
+	n := len(self.endpoints())
+	for i := 1; i < n; i++ {
+		to := (from + i) % n
+		if err := self.check(ctx, to); err != nil {
+			continue
+		}
+		self.switchTo(from, to, cause)
+		return nil
+	}
+	return ErrNoHealthyEndpoint
+}
+
+// notDelivered 错误发生在请求送达之前 即使不是幂等请求也可以换一个端点重发
+func notDelivered(err error) bool {
+	if errors.Is(err, ErrNotConnected) || errors.Is(err, ErrNoHealthyEndpoint) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (self *FailoverRequestHandler) SendRequest(req RpcRequest) (interface{}, error) {
+	res, err := self.SendRequestContext(context.Background(), req)
+	return res.Result, err
+}
+
+func (self *FailoverRequestHandler) SendRequestContext(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	var lastErr error
+	for attempt := 0; attempt < len(self.endpoints()); attempt++ {
+		index, endpoint, handler, err := self.active()
+		res := RpcResponse{}
+		if err == nil {
+			res, err = sendTo(ctx, handler, req)
+			if self.OnServed != nil {
+				self.OnServed(endpoint, req, err)
+			}
+			if err == nil || !IsTransient(err) {
+				return res, err
+			}
+			// 断开的websocket丢弃掉 下次使用这个端点时重新连接
+			if v, ok := handler.(*WebsocketRequestHandler); ok && !v.Connected() && !v.AutoReconnect {
+				self.drop(index, handler)
+			}
+			if !notDelivered(err) && !IsIdempotent(req) {
+				return res, err
+			}
+		}
+		lastErr = err
+		if ctx.Err() != nil || self.failover(ctx, index, err) != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = ErrNoHealthyEndpoint
+	}
+	return RpcResponse{}, lastErr
+}
+
+// HealthCheck 检查所有端点 返回每个端点的检查结果 nil表示健康
+func (self *FailoverRequestHandler) HealthCheck(ctx context.Context) map[string]error {
+	endpoints := self.endpoints()
+	results := make(map[string]error, len(endpoints))
+	for index, endpoint := range endpoints {
+		results[endpoint] = self.check(ctx, index)
+	}
+	return results
+}
+
+// Monitor 定期检查当前端点 不健康时主动切换 开启FailBack时首选端点恢复后切回 阻塞到ctx结束
+func (self *FailoverRequestHandler) Monitor(ctx context.Context) {
+	interval := self.HealthCheckInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		self.lock.Lock()
+		current := self.current
+		self.lock.Unlock()
+		if err := self.check(ctx, current); err != nil {
+			self.failover(ctx, current, err)
+			continue
+		}
+		if self.FailBack && current != 0 {
+			if self.check(ctx, 0) == nil {
+				self.switchTo(current, 0, nil)
+			}
+		}
+	}
+}
+
+// register 在handler上注册对应类型的回调
+func register(handler notificationHandler, function Callback, type_ string) {
+	switch type_ {
+	case "aria2.onDownloadStart":
+		handler.OnDownloadStart(function)
+	case "aria2.onDownloadPause":
+		handler.OnDownloadPause(function)
+	case "aria2.onDownloadStop":
+		handler.OnDownloadStop(function)
+	case "aria2.onDownloadComplete":
+		handler.OnDownloadComplete(function)
+	case "aria2.onDownloadError":
+		handler.OnDownloadError(function)
+	case "aria2.onBtDownloadComplete":
+		handler.OnBtDownloadComplete(function)
+	}
+}
+
+func (self *FailoverRequestHandler) register(function Callback, type_ string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.functions[type_] = append(self.functions[type_], function)
+	for _, handler := range self.handlers {
+		if v, ok := handler.(notificationHandler); ok {
+			register(v, function, type_)
+		}
+	}
+}
+
+func (self *FailoverRequestHandler) OnDownloadStart(callback Callback) Callback {
+	self.register(callback, "aria2.onDownloadStart")
+	return callback
+}
+func (self *FailoverRequestHandler) OnDownloadPause(callback Callback) Callback {
+	self.register(callback, "aria2.onDownloadPause")
+	return callback
+}
+func (self *FailoverRequestHandler) OnDownloadStop(callback Callback) Callback {
+	self.register(callback, "aria2.onDownloadStop")
+	return callback
+}
+func (self *FailoverRequestHandler) OnDownloadComplete(callback Callback) Callback {
+	self.register(callback, "aria2.onDownloadComplete")
+	return callback
+}
+func (self *FailoverRequestHandler) OnDownloadError(callback Callback) Callback {
+	self.register(callback, "aria2.onDownloadError")
+	return callback
+}
+func (self *FailoverRequestHandler) OnBtDownloadComplete(callback Callback) Callback {
+	self.register(callback, "aria2.onBtDownloadComplete")
+	return callback
+}
@@ -0,0 +1,72 @@
+package aria2
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailoverToStandby(t *testing.T) {
+	primary := newFakeAria2(t, versionHandler)
+	standby := newFakeAria2(t, versionHandler)
+	primaryUrl := primary.RpcUrl(false)
+	primary.Close()
+
+	handler := NewFailoverRequestHandler(primaryUrl, standby.RpcUrl(false))
+	var from, to string
+	handler.OnFailover = func(f string, t string, err error) { from, to = f, t }
+	client := NewAria2Client(primaryUrl, nil, nil, nil, nil, handler)
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if from != primaryUrl || to != standby.RpcUrl(false) {
+		t.Fatalf("unexpected failover %q -> %q", from, to)
+	}
+	if handler.Current() != standby.RpcUrl(false) {
+		t.Fatalf("current endpoint is %s", handler.Current())
+	}
+}
+
+func TestFailoverProbeDoesNotDeliverNotifications(t *testing.T) {
+	var primary *fakeAria2
+	primary = newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.getVersion" {
+			primary.Notify("aria2.onDownloadStart", "2089b05ecca3d829")
+		}
+		return versionHandler(method, params)
+	})
+	standby := newFakeAria2(t, versionHandler)
+
+	handler := NewFailoverRequestHandler(primary.RpcUrl(true), standby.RpcUrl(true))
+	handler.current = 1
+	client := NewAria2Client(standby.RpcUrl(true), nil, nil, nil, nil, handler)
+	if err := client.StartErr(); err != nil {
+		t.Fatal(err)
+	}
+	var fired int32
+	client.OnDownloadStart(func(client *Aria2Client, data RpcRequest) {
+		atomic.AddInt32(&fired, 1)
+	})
+
+	// FailBack探测首选端点时 首选端点推送的通知不能触发回调
+	if err := handler.check(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Fatalf("probe delivered %d notifications", n)
+	}
+
+	handler.switchTo(1, 0, nil)
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&fired) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Fatalf("expected 1 notification from the current endpoint, got %d", n)
+	}
+}
@@ -0,0 +1,29 @@
+package aria2
+
+import (
+	"github.com/synodriver/goaria2/aria2/internal/fakearia2"
+	"testing"
+)
+
+// fakeAria2 同时支持http POST和websocket的json-rpc服务端 方法的处理交给handle
+type fakeAria2 struct {
+	*fakearia2.Server
+}
+
+func newFakeAria2(t *testing.T, handle func(method string, params []interface{}) (interface{}, *ErrorMsg)) *fakeAria2 {
+	return &fakeAria2{fakearia2.New(t, func(method string, params []interface{}) (interface{}, *fakearia2.Error) {
+		result, err := handle(method, params)
+		if err != nil {
+			return nil, &fakearia2.Error{Code: err.Code, Message: err.Message}
+		}
+		return result, nil
+	})}
+}
+
+// versionHandler 只实现getVersion
+func versionHandler(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	if method == "aria2.getVersion" {
+		return map[string]interface{}{"version": "1.36.0", "enabledFeatures": []string{}}, nil
+	}
+	return nil, &ErrorMsg{Code: 1, Message: "method not found"}
+}
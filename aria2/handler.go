@@ -2,12 +2,17 @@ package aria2
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/tidwall/gjson"
 	"github.com/valyala/fasthttp"
+	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -16,6 +21,54 @@ type IRequestHandler interface {
 	SetUrl(url string)
 }
 
+// ErrTimeout websocket在Timeout内没有收到响应
+var ErrTimeout = errors.New("rpc call timeout")
+
+// HttpStatusError http返回了非200且body不是json-rpc响应 通常来自aria2前面的反向代理
+type HttpStatusError struct {
+	StatusCode int
+}
+
+func (self *HttpStatusError) Error() string {
+	return fmt.Sprintf("aria2: unexpected http status %d", self.StatusCode)
+}
+
+// IContextRequestHandler 支持context的handler 客户端的拦截器链末端优先使用它
+type IContextRequestHandler interface {
+	IRequestHandler
+	SendRequestContext(ctx context.Context, req RpcRequest) (RpcResponse, error)
+}
+
+// notificationHandler 能接收aria2通知的handler 由WebsocketRequestHandler和FailoverRequestHandler实现
+type notificationHandler interface {
+	IRequestHandler
+	SetClient(c *Aria2Client)
+	SetTimeout(t time.Duration)
+	OnDownloadStart(callback Callback) Callback
+	OnDownloadPause(callback Callback) Callback
+	OnDownloadStop(callback Callback) Callback
+	OnDownloadComplete(callback Callback) Callback
+	OnDownloadError(callback Callback) Callback
+	OnBtDownloadComplete(callback Callback) Callback
+	start() error
+}
+
+// findNotificationHandler 沿着Unwrap() IRequestHandler找到被包装的websocket或failover handler
+// 自己包装handler时实现Unwrap 通知回调和超时设置才能传到里面的handler
+func findNotificationHandler(handler IRequestHandler) (notificationHandler, bool) {
+	for handler != nil {
+		if v, ok := handler.(notificationHandler); ok {
+			return v, true
+		}
+		wrapper, ok := handler.(interface{ Unwrap() IRequestHandler })
+		if !ok {
+			return nil, false
+		}
+		handler = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
 type HttpRequestHandler struct {
 	Url    string
 	Client *fasthttp.Client
@@ -29,8 +82,35 @@ func (self *HttpRequestHandler) SetUrl(url string) {
 	self.Url = url
 }
 
+// SetDialContext 替换fasthttp建立连接的方式
+func (self *HttpRequestHandler) SetDialContext(dial DialContextFunc) {
+	self.Client.Dial = func(addr string) (net.Conn, error) {
+		return dial(context.Background(), "tcp", addr)
+	}
+}
+
+// SetProxy 通过代理访问aria2 支持的格式见ProxyDialContext
+func (self *HttpRequestHandler) SetProxy(proxyUrl string) error {
+	dial, err := ProxyDialContext(proxyUrl, nil)
+	if err != nil {
+		return err
+	}
+	self.SetDialContext(dial)
+	return nil
+}
+
 func (self *HttpRequestHandler) SendRequest(req RpcRequest) (interface{}, error) {
+	res, err := self.SendRequestContext(context.Background(), req)
+	return res.Result, err
+}
+
+// SendRequestContext fasthttp不支持取消 只使用ctx的deadline
+func (self *HttpRequestHandler) SendRequestContext(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return RpcResponse{}, err
+	}
 	httpreq := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(httpreq)
 	httpreq.SetRequestURI(self.Url)
 	requestBody, err := json.Marshal(req)
 	if err != nil {
@@ -40,33 +120,166 @@ func (self *HttpRequestHandler) SendRequest(req RpcRequest) (interface{}, error)
 	httpreq.Header.SetContentType("application/json")
 	httpreq.Header.SetMethod("POST")
 	httpresp := fasthttp.AcquireResponse()
-	if err := self.Client.Do(httpreq, httpresp); err != nil {
+	defer fasthttp.ReleaseResponse(httpresp)
+	if deadline, ok := ctx.Deadline(); ok {
+		err = self.Client.DoDeadline(httpreq, httpresp, deadline)
+	} else {
+		err = self.Client.Do(httpreq, httpresp)
+	}
+	if err != nil {
+		return RpcResponse{}, err
+	}
+	return decodeResponse(httpresp.Body(), httpresp.StatusCode())
+}
+
+// NetHttpRequestHandler 基于标准库net/http的handler 可以注入自己的*http.Client和http.RoundTripper
+// 便于使用代理、httptest以及各种RoundTripper中间件
+type NetHttpRequestHandler struct {
+	Url    string
+	Client *http.Client
+}
+
+// NewNetHttpRequestHandler client为nil时使用一个新的http.Client
+func NewNetHttpRequestHandler(client *http.Client) *NetHttpRequestHandler {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &NetHttpRequestHandler{Client: client}
+}
+
+func (self *NetHttpRequestHandler) SetUrl(url string) {
+	self.Url = url
+}
+
+// SetTransport 替换底层的RoundTripper
+func (self *NetHttpRequestHandler) SetTransport(transport http.RoundTripper) {
+	self.Client.Transport = transport
+}
+
+// SetDialContext 替换底层http.Transport建立连接的方式
+// 如果Transport已经被换成了其他RoundTripper则返回错误
+func (self *NetHttpRequestHandler) SetDialContext(dial DialContextFunc) error {
+	transport, err := self.transport()
+	if err != nil {
+		return err
+	}
+	transport.DialContext = dial
+	return nil
+}
+
+// SetProxy 通过代理访问aria2 支持的格式见ProxyDialContext 会覆盖环境变量里的代理设置
+func (self *NetHttpRequestHandler) SetProxy(proxyUrl string) error {
+	dial, err := ProxyDialContext(proxyUrl, nil)
+	if err != nil {
+		return err
+	}
+	transport, err := self.transport()
+	if err != nil {
+		return err
+	}
+	transport.Proxy = nil
+	transport.DialContext = dial
+	return nil
+}
+
+func (self *NetHttpRequestHandler) transport() (*http.Transport, error) {
+	switch transport := self.Client.Transport.(type) {
+	case nil:
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		self.Client.Transport = t
+		return t, nil
+	case *http.Transport:
+		return transport, nil
+	default:
+		return nil, fmt.Errorf("aria2: cannot configure dialer on %T", transport)
+	}
+}
+
+func (self *NetHttpRequestHandler) SendRequest(req RpcRequest) (interface{}, error) {
+	res, err := self.SendRequestContext(context.Background(), req)
+	return res.Result, err
+}
+
+func (self *NetHttpRequestHandler) SendRequestContext(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	requestBody, err := json.Marshal(req)
+	if err != nil {
 		return RpcResponse{}, err
 	}
-	b := httpresp.Body()
-	res := &RpcResponse{}
-	if err := json.Unmarshal(b, res); err != nil {
+	httpreq, err := http.NewRequestWithContext(ctx, "POST", self.Url, bytes.NewReader(requestBody))
+	if err != nil {
 		return RpcResponse{}, err
 	}
-	if (*res).Error != nil {
-		return RpcResponse{}, errors.New((*res).Error.(string))
+	httpreq.Header.Set("Content-Type", "application/json")
+	httpresp, err := self.Client.Do(httpreq)
+	if err != nil {
+		return RpcResponse{}, err
 	}
-	return (*res).Result, nil
+	defer httpresp.Body.Close()
+	b, err := io.ReadAll(httpresp.Body)
+	if err != nil {
+		return RpcResponse{}, err
+	}
+	return decodeResponse(b, httpresp.StatusCode)
 }
 
+// decodeResponse 解析http返回的body aria2出错时http状态码不是200 但body里仍然有error字段
+func decodeResponse(b []byte, statusCode int) (RpcResponse, error) {
+	res := RpcResponse{}
+	if err := json.Unmarshal(b, &res); err != nil {
+		if statusCode != http.StatusOK {
+			return RpcResponse{}, &HttpStatusError{StatusCode: statusCode}
+		}
+		return RpcResponse{}, err
+	}
+	if err := res.Err(); err != nil {
+		return RpcResponse{}, err
+	}
+	return res, nil
+}
+
+// ErrNotConnected websocket还没有连上或者正在重连
+var ErrNotConnected = errors.New("aria2: websocket not connected")
+
+// ErrConnectionLost 等待响应期间websocket连接断开
+var ErrConnectionLost = errors.New("aria2: websocket connection lost")
+
 type WebsocketRequestHandler struct {
-	Url         string
-	Client      *Aria2Client
-	Conn        *websocket.Conn
-	Timeout     time.Duration
-	functions   map[string][]Callback
-	resultStore map[string]chan RpcResponse
+	Url     string
+	Client  *Aria2Client
+	Conn    *websocket.Conn
+	Dialer  *websocket.Dialer
+	Timeout time.Duration
+	// PingInterval 发送ping的间隔 0表示不发ping也不检测对端是否存活
+	PingInterval time.Duration
+	// PongTimeout 发出ping后等待pong的时间 超时则认为连接已死 0表示与PingInterval相同
+	PongTimeout time.Duration
+	// WriteTimeout 单次写入的超时 0表示不限制
+	WriteTimeout time.Duration
+	// ReadLimit 单条消息的最大字节数 0表示不限制
+	ReadLimit int64
+	// AutoReconnect 连接断开后按照ReconnectBackoff自动重连
+	AutoReconnect    bool
+	ReconnectBackoff Backoff
+	// OnDisconnect 连接被判定断开时调用
+	OnDisconnect func(err error)
+	// OnReconnect 自动重连成功后调用 可以在这里重新下发全局设置
+	OnReconnect func()
+
+	functions     map[string][]Callback
+	functionsLock sync.RWMutex
+	resultStore   map[string]chan RpcResponse
+	storeLock     sync.Mutex
+	connLock      sync.RWMutex
+	writeLock     sync.Mutex
+	lost          chan struct{} // 当前连接断开时被关闭
+	closed        chan struct{}
+	closeOnce     sync.Once
 }
 
 func NewWebsocketRequestHandler() *WebsocketRequestHandler {
 	functions := make(map[string][]Callback, 5)
 	resultStore := make(map[string]chan RpcResponse, 5)
-	return &WebsocketRequestHandler{functions: functions, resultStore: resultStore}
+	return &WebsocketRequestHandler{functions: functions, resultStore: resultStore, closed: make(chan struct{})}
 }
 
 func (self *WebsocketRequestHandler) SetUrl(url string) {
@@ -78,23 +291,137 @@ func (self *WebsocketRequestHandler) SetClient(c *Aria2Client) {
 func (self *WebsocketRequestHandler) SetTimeout(t time.Duration) {
 	self.Timeout = t
 }
-func (self *WebsocketRequestHandler) listen(connected chan<- bool) error {
-	con, _, err := websocket.DefaultDialer.Dial(self.Url, http.Header{})
+
+// SetKeepalive 设置ping间隔和等待pong的时间 需要在NewAria2Client之前调用
+func (self *WebsocketRequestHandler) SetKeepalive(pingInterval time.Duration, pongTimeout time.Duration) {
+	self.PingInterval = pingInterval
+	self.PongTimeout = pongTimeout
+}
+
+// SetAutoReconnect 开启或关闭自动重连 backoff为零值时使用DefaultBackoff
+func (self *WebsocketRequestHandler) SetAutoReconnect(enable bool, backoff Backoff) {
+	self.AutoReconnect = enable
+	self.ReconnectBackoff = backoff
+}
+
+// SetDialContext 替换websocket建立tcp连接的方式 需要在NewAria2Client之前调用
+func (self *WebsocketRequestHandler) SetDialContext(dial DialContextFunc) {
+	dialer := self.dialer()
+	dialer.Proxy = nil
+	dialer.NetDialContext = dial
+}
+
+// SetProxy 通过代理连接aria2 支持的格式见ProxyDialContext 需要在NewAria2Client之前调用
+func (self *WebsocketRequestHandler) SetProxy(proxyUrl string) error {
+	dial, err := ProxyDialContext(proxyUrl, nil)
 	if err != nil {
 		return err
 	}
-	self.Conn = con
-	connected <- true
-	defer func() { self.Conn.Close() }()
+	self.SetDialContext(dial)
+	return nil
+}
+
+func (self *WebsocketRequestHandler) dialer() *websocket.Dialer {
+	if self.Dialer == nil {
+		dialer := *websocket.DefaultDialer
+		self.Dialer = &dialer
+	}
+	return self.Dialer
+}
+
+// Connected 当前是否有可用的连接
+func (self *WebsocketRequestHandler) Connected() bool {
+	self.connLock.RLock()
+	defer self.connLock.RUnlock()
+	return self.Conn != nil
+}
+
+// Close 关闭连接并停止重连
+func (self *WebsocketRequestHandler) Close() error {
+	self.closeOnce.Do(func() { close(self.closed) })
+	self.connLock.RLock()
+	conn := self.Conn
+	self.connLock.RUnlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// start 建立第一个连接 失败时如果开启了自动重连就在后台继续尝试
+func (self *WebsocketRequestHandler) start() error {
+	err := self.connect()
+	if err != nil && self.AutoReconnect {
+		go self.reconnect()
+	}
+	return err
+}
+
+func (self *WebsocketRequestHandler) connect() error {
+	conn, _, err := self.dialer().Dial(self.Url, http.Header{})
+	if err != nil {
+		return err
+	}
+	if self.ReadLimit > 0 {
+		conn.SetReadLimit(self.ReadLimit)
+	}
+	if self.PingInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(self.deadAfter()))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(self.deadAfter()))
+		})
+	}
+	lost := make(chan struct{})
+	self.connLock.Lock()
+	self.Conn = conn
+	self.lost = lost
+	self.connLock.Unlock()
+	go self.listen(conn, lost)
+	if self.PingInterval > 0 {
+		go self.ping(conn, lost)
+	}
+	return nil
+}
+
+// deadAfter 超过这么久没有收到任何数据(包括pong)就认为连接已死
+func (self *WebsocketRequestHandler) deadAfter() time.Duration {
+	if self.PongTimeout > 0 {
+		return self.PingInterval + self.PongTimeout
+	}
+	return 2 * self.PingInterval
+}
+
+func (self *WebsocketRequestHandler) ping(conn *websocket.Conn, lost <-chan struct{}) {
+	ticker := time.NewTicker(self.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deadline := time.Now().Add(self.deadAfter())
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				self.disconnect(conn, err)
+				return
+			}
+		case <-lost:
+			return
+		}
+	}
+}
+
+func (self *WebsocketRequestHandler) listen(conn *websocket.Conn, lost <-chan struct{}) {
 	for {
 		buffer := NewBuffer()
-		t, reader, err := self.Conn.NextReader()
-		if err != nil {
-			continue
+		t, reader, err := conn.NextReader()
+		if err == nil {
+			_, err = buffer.ReadFrom(reader)
 		}
-		_, err = buffer.ReadFrom(reader)
 		if err != nil {
-			continue
+			PutBuffer(buffer)
+			self.disconnect(conn, err)
+			return
+		}
+		if self.PingInterval > 0 { // 收到任何消息都说明对端还活着
+			conn.SetReadDeadline(time.Now().Add(self.deadAfter()))
 		}
 		if t == websocket.TextMessage {
 			go func(buffer *bytes.Buffer) {
@@ -105,16 +432,63 @@ func (self *WebsocketRequestHandler) listen(connected chan<- bool) error {
 			PutBuffer(buffer)
 		}
 	}
-	return nil
 }
+
+// disconnect 把conn判定为已断开 正在等待响应的调用会立刻返回ErrConnectionLost
+func (self *WebsocketRequestHandler) disconnect(conn *websocket.Conn, err error) {
+	self.connLock.Lock()
+	if self.Conn != conn {
+		self.connLock.Unlock()
+		return
+	}
+	self.Conn = nil
+	close(self.lost)
+	self.connLock.Unlock()
+	conn.Close()
+	select {
+	case <-self.closed:
+		return
+	default:
+	}
+	if self.OnDisconnect != nil {
+		self.OnDisconnect(err)
+	}
+	if self.AutoReconnect {
+		go self.reconnect()
+	}
+}
+
+func (self *WebsocketRequestHandler) reconnect() {
+	backoff := self.ReconnectBackoff
+	if backoff == (Backoff{}) {
+		backoff = DefaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-self.closed:
+			return
+		case <-time.After(backoff.Delay(attempt)):
+		}
+		if err := self.connect(); err == nil {
+			if self.OnReconnect != nil {
+				self.OnReconnect()
+			}
+			return
+		}
+	}
+}
+
 func (self *WebsocketRequestHandler) handleEvent(b []byte) {
 	str := string(b)
-	if result := gjson.Get(str, "result"); result.Exists() { // 是rpc返回
+	if id := gjson.Get(str, "id"); id.Exists() { // 是rpc返回
 		res := &RpcResponse{}
 		if err := json.Unmarshal(b, res); err != nil {
 			return
 		}
-		if v, ok := self.resultStore[gjson.Get(str, "id").String()]; ok {
+		self.storeLock.Lock()
+		v, ok := self.resultStore[id.String()]
+		self.storeLock.Unlock()
+		if ok {
 			v <- *res
 		}
 	} else { // 是notice
@@ -123,7 +497,10 @@ func (self *WebsocketRequestHandler) handleEvent(b []byte) {
 			if err := json.Unmarshal(b, req); err != nil {
 				return
 			}
-			for _, function := range self.functions[method.String()] {
+			self.functionsLock.RLock()
+			functions := self.functions[method.String()]
+			self.functionsLock.RUnlock()
+			for _, function := range functions {
 				go function(self.Client, *req)
 			}
 		}
@@ -131,22 +508,62 @@ func (self *WebsocketRequestHandler) handleEvent(b []byte) {
 }
 
 func (self *WebsocketRequestHandler) SendRequest(req RpcRequest) (interface{}, error) {
-	self.resultStore[req.Id.(string)] = make(chan RpcResponse)
-	defer delete(self.resultStore, req.Id.(string))
+	res, err := self.SendRequestContext(context.Background(), req)
+	return res.Result, err
+}
+
+func (self *WebsocketRequestHandler) SendRequestContext(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+	self.connLock.RLock()
+	conn, lost := self.Conn, self.lost
+	self.connLock.RUnlock()
+	if conn == nil {
+		return RpcResponse{}, ErrNotConnected
+	}
+	id := fmt.Sprint(req.Id)
+	ch := make(chan RpcResponse, 1)
+	self.storeLock.Lock()
+	self.resultStore[id] = ch
+	self.storeLock.Unlock()
+	defer func() {
+		self.storeLock.Lock()
+		delete(self.resultStore, id)
+		self.storeLock.Unlock()
+	}()
 	mp := (&req).ToMap()
-	if err := self.Conn.WriteJSON(mp); err != nil { //todo panic
+	self.writeLock.Lock()
+	if self.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(self.WriteTimeout))
+	}
+	err := conn.WriteJSON(mp)
+	self.writeLock.Unlock()
+	if err != nil {
+		self.disconnect(conn, err)
 		return RpcResponse{}, err
 	}
+	var timeout <-chan time.Time // Timeout为0时只受ctx控制
+	if self.Timeout > 0 {
+		timer := time.NewTimer(self.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 	select {
-	case rpcres := <-self.resultStore[req.Id.(string)]:
-		return rpcres.Result, nil
-	case <-time.After(self.Timeout):
-		return RpcResponse{}, errors.New("rpc call timeout")
+	case rpcres := <-ch:
+		if err := rpcres.Err(); err != nil {
+			return RpcResponse{}, err
+		}
+		return rpcres, nil
+	case <-lost:
+		return RpcResponse{}, ErrConnectionLost
+	case <-ctx.Done():
+		return RpcResponse{}, ctx.Err()
+	case <-timeout:
+		return RpcResponse{}, ErrTimeout
 	}
-
 }
 
 func (self *WebsocketRequestHandler) register(function Callback, type_ string) {
+	self.functionsLock.Lock()
+	defer self.functionsLock.Unlock()
 	if v, ok := self.functions[type_]; ok {
 		self.functions[type_] = append(v, function)
 	} else {
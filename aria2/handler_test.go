@@ -0,0 +1,226 @@
+package aria2
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePeer websocket对端 mute之后已有的连接不再回应ping和rpc调用 新连接正常工作
+type fakePeer struct {
+	*httptest.Server
+	lock   sync.Mutex
+	conns  map[*websocket.Conn]*sync.Mutex // 每个连接的写锁
+	muted  map[*websocket.Conn]bool
+	reject int // 接下来拒绝的握手次数
+	dials  []time.Time
+}
+
+func newFakePeer(t *testing.T) *fakePeer {
+	peer := &fakePeer{conns: make(map[*websocket.Conn]*sync.Mutex), muted: make(map[*websocket.Conn]bool)}
+	peer.Server = httptest.NewServer(http.HandlerFunc(peer.serve))
+	t.Cleanup(peer.Close)
+	return peer
+}
+
+func (self *fakePeer) Close() {
+	self.lock.Lock()
+	for conn := range self.conns {
+		conn.Close()
+	}
+	self.lock.Unlock()
+	self.Server.Close()
+}
+
+func (self *fakePeer) RpcUrl() string {
+	return "ws" + strings.TrimPrefix(self.URL, "http") + "/jsonrpc"
+}
+
+// mute 让当前的连接假死 并拒绝接下来reject次握手
+func (self *fakePeer) mute(reject int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for conn := range self.conns {
+		self.muted[conn] = true
+	}
+	self.reject = reject
+}
+
+func (self *fakePeer) dialTimes() []time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]time.Time(nil), self.dials...)
+}
+
+func (self *fakePeer) isMuted(conn *websocket.Conn) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.muted[conn]
+}
+
+func (self *fakePeer) write(conn *websocket.Conn, v interface{}) {
+	self.lock.Lock()
+	writeLock := self.conns[conn]
+	self.lock.Unlock()
+	if writeLock == nil {
+		return
+	}
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	conn.WriteJSON(v)
+}
+
+// notify 向没有假死的连接推送通知
+func (self *fakePeer) notify(method string, gid string) {
+	self.lock.Lock()
+	conns := make([]*websocket.Conn, 0)
+	for conn := range self.conns {
+		if !self.muted[conn] {
+			conns = append(conns, conn)
+		}
+	}
+	self.lock.Unlock()
+	for _, conn := range conns {
+		self.write(conn, map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": []interface{}{map[string]interface{}{"gid": gid}}})
+	}
+}
+
+func (self *fakePeer) serve(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	self.dials = append(self.dials, time.Now())
+	reject := self.reject > 0
+	if reject {
+		self.reject--
+	}
+	self.lock.Unlock()
+	if reject {
+		http.Error(w, "restarting", http.StatusServiceUnavailable)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeLock := &sync.Mutex{}
+	self.lock.Lock()
+	self.conns[conn] = writeLock
+	self.lock.Unlock()
+	defer func() {
+		self.lock.Lock()
+		delete(self.conns, conn)
+		self.lock.Unlock()
+		conn.Close()
+	}()
+	conn.SetPingHandler(func(data string) error {
+		if self.isMuted(conn) {
+			return nil
+		}
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for {
+		req := RpcRequest{}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if self.isMuted(conn) {
+			continue
+		}
+		self.write(conn, RpcResponse{ID: req.Id.(string), Jsonrpc: "2.0", Result: map[string]interface{}{"version": "1.36.0"}})
+	}
+}
+
+func TestWebsocketKeepalive(t *testing.T) {
+	const pingInterval, pongTimeout = 100 * time.Millisecond, 100 * time.Millisecond
+	peer := newFakePeer(t)
+	handler := NewWebsocketRequestHandler()
+	handler.SetKeepalive(pingInterval, pongTimeout)
+	handler.SetAutoReconnect(true, Backoff{Initial: 20 * time.Millisecond, Multiplier: 2})
+	disconnected := make(chan time.Time, 4)
+	handler.OnDisconnect = func(err error) { disconnected <- time.Now() }
+	reconnected := make(chan struct{}, 4)
+	handler.OnReconnect = func() { reconnected <- struct{}{} }
+	started := make(chan string, 4)
+	handler.OnDownloadStart(func(client *Aria2Client, data RpcRequest) { started <- notificationGid(data) })
+	client := NewAria2Client(peer.RpcUrl(), nil, nil, nil, nil, handler)
+	defer handler.Close()
+	if err := client.StartErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对端回应pong时 连接一直保持
+	time.Sleep(3 * (pingInterval + pongTimeout))
+	select {
+	case <-disconnected:
+		t.Fatal("disconnected although peer answers pings")
+	default:
+	}
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对端不再回应 正在等待的调用返回ErrConnectionLost
+	muted := time.Now()
+	peer.mute(2)
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := client.GetVersion()
+		inflight <- err
+	}()
+	select {
+	case at := <-disconnected:
+		// 最后一次收到数据不晚于muted 留出调度的余量
+		if elapsed := at.Sub(muted); elapsed > pingInterval+pongTimeout+100*time.Millisecond {
+			t.Errorf("OnDisconnect after %v, want within %v", elapsed, pingInterval+pongTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+	select {
+	case err := <-inflight:
+		if err != ErrConnectionLost {
+			t.Fatalf("in-flight call returned %v, want ErrConnectionLost", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight call still waiting")
+	}
+
+	// 前两次重连被拒绝 每次等待的时间翻倍
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reconnected")
+	}
+	dials := peer.dialTimes()
+	if len(dials) != 4 {
+		t.Fatalf("expected 1 initial and 3 reconnect dials, got %d", len(dials))
+	}
+	for i, want := range []time.Duration{40 * time.Millisecond, 80 * time.Millisecond} {
+		if gap := dials[i+2].Sub(dials[i+1]); gap < want {
+			t.Errorf("reconnect attempt %d after %v, want at least %v", i+2, gap, want)
+		}
+	}
+	if !handler.Connected() {
+		t.Fatal("not connected after OnReconnect")
+	}
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重连之后通知照常送达
+	peer.notify("aria2.onDownloadStart", "2089b05ecca3d829")
+	select {
+	case gid := <-started:
+		if gid != "2089b05ecca3d829" {
+			t.Fatalf("notification for %q", gid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification not delivered after reconnect")
+	}
+}
@@ -0,0 +1,36 @@
+// Package atomicfile 原子地写文件
+// aria2包通过magnet依赖torrent 所以torrent不能直接使用aria2.WriteFileAtomic 实现放在这里共用
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile 先写同目录下的唯一临时文件并fsync 再改名覆盖path
+// 进程中途退出不会留下写了一半的文件 多个写入者同时写同一个path也不会互相覆盖临时文件
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
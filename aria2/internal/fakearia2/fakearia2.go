@@ -0,0 +1,185 @@
+// Package fakearia2 测试用的假aria2 同时支持http POST和websocket的json-rpc
+// 不依赖aria2包 所以aria2包自己的测试和子包的测试都可以使用
+package fakearia2
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Error rpc错误 对应aria2返回的{"code":1,"message":"..."}
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Handler 处理一个方法调用 params已经去掉了token
+type Handler func(method string, params []interface{}) (interface{}, *Error)
+
+type request struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	Id      interface{}   `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	Id      string      `json:"id"`
+	Jsonrpc string      `json:"jsonrpc"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *Error      `json:"error,omitempty"`
+}
+
+// Server 假的aria2 方法的处理交给handle
+type Server struct {
+	*httptest.Server
+	handle Handler
+
+	lock   sync.Mutex
+	calls  []string
+	params [][]interface{}
+	tokens []string
+	conns  []*conn
+}
+
+type conn struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (self *conn) write(v interface{}) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.conn.WriteJSON(v)
+}
+
+// New 启动服务端 测试结束时自动关闭
+func New(t testing.TB, handle Handler) *Server {
+	server := &Server{handle: handle}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHttp))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Close 先断开websocket连接 否则httptest.Server.Close会一直等待被hijack的请求
+func (self *Server) Close() {
+	self.lock.Lock()
+	conns := self.conns
+	self.conns = nil
+	self.lock.Unlock()
+	for _, c := range conns {
+		c.conn.Close()
+	}
+	self.Server.Close()
+}
+
+// RpcUrl ws为false时返回http地址 否则返回websocket地址
+func (self *Server) RpcUrl(ws bool) string {
+	if ws {
+		return "ws" + strings.TrimPrefix(self.URL, "http") + "/jsonrpc"
+	}
+	return self.URL + "/jsonrpc"
+}
+
+// Calls 收到的方法名 按顺序
+func (self *Server) Calls() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string(nil), self.calls...)
+}
+
+// Params 每次调用去掉token之后的参数 和Calls一一对应
+func (self *Server) Params() [][]interface{} {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([][]interface{}(nil), self.params...)
+}
+
+// Tokens 每次调用带的token 没有带时为空字符串 和Calls一一对应
+func (self *Server) Tokens() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string(nil), self.tokens...)
+}
+
+func (self *Server) call(req request) response {
+	params := req.Params
+	token := ""
+	if len(params) > 0 {
+		if s, ok := params[0].(string); ok && strings.HasPrefix(s, "token:") {
+			token = strings.TrimPrefix(s, "token:")
+			params = params[1:]
+		}
+	}
+	self.lock.Lock()
+	self.calls = append(self.calls, req.Method)
+	self.params = append(self.params, params)
+	self.tokens = append(self.tokens, token)
+	self.lock.Unlock()
+	res := response{Id: fmt.Sprint(req.Id), Jsonrpc: "2.0"}
+	res.Result, res.Error = self.handle(req.Method, params)
+	return res
+}
+
+func (self *Server) serveHttp(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		self.serveWebsocket(w, r)
+		return
+	}
+	req := request{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := self.call(req)
+	w.Header().Set("Content-Type", "application/json")
+	if res.Error != nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (self *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{conn: ws}
+	self.lock.Lock()
+	self.conns = append(self.conns, c)
+	self.lock.Unlock()
+	defer ws.Close()
+	for {
+		req := request{}
+		if err := ws.ReadJSON(&req); err != nil {
+			return
+		}
+		go c.write(self.call(req))
+	}
+}
+
+// Notify 向所有websocket连接推送通知
+func (self *Server) Notify(method string, gid string) {
+	self.lock.Lock()
+	conns := append([]*conn(nil), self.conns...)
+	self.lock.Unlock()
+	for _, c := range conns {
+		// aria2的通知没有id字段
+		c.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": []interface{}{map[string]interface{}{"gid": gid}}})
+	}
+}
+
+// Version 只实现getVersion的Handler
+func Version(method string, params []interface{}) (interface{}, *Error) {
+	if method == "aria2.getVersion" {
+		return map[string]interface{}{"version": "1.36.0", "enabledFeatures": []string{}}, nil
+	}
+	return nil, &Error{Code: 1, Message: "method not found"}
+}
@@ -0,0 +1,134 @@
+package aria2
+
+// DefaultPageSize 迭代器每次请求的条数
+const DefaultPageSize = 500
+
+// StatusIterator 分页遍历等待队列或已停止列表
+//
+//	it := client.IterWaiting(200, &[]string{"gid", "status", "totalLength"})
+//	for it.Next() {
+//		status := it.Status()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+//
+// 两次请求之间队列可能发生变化 例如前面的下载开始或被删除导致后面的下载前移
+// 每一页会和上一页重叠Overlap条 并按GID去重 前移不超过Overlap条时不会漏掉下载
+type StatusIterator struct {
+	PageSize int
+	// Overlap 相邻两页重叠的条数 必须小于PageSize 默认PageSize/4
+	Overlap int
+
+	client  *Aria2Client
+	stopped bool
+	reverse bool
+	keys    *[]string
+
+	next    int // 下一页的起始位置 倒序时是距离末尾的位置
+	page    []DownloadStatus
+	pos     int
+	last    bool
+	seen    map[string]bool
+	current DownloadStatus
+	err     error
+}
+
+// IterWaiting 遍历tellWaiting pageSize<=0时使用DefaultPageSize keys为nil时返回全部字段
+func (self *Aria2Client) IterWaiting(pageSize int, keys *[]string) *StatusIterator {
+	return self.newIterator(false, pageSize, keys)
+}
+
+// IterStopped 遍历tellStopped 参数同IterWaiting
+func (self *Aria2Client) IterStopped(pageSize int, keys *[]string) *StatusIterator {
+	return self.newIterator(true, pageSize, keys)
+}
+
+func (self *Aria2Client) newIterator(stopped bool, pageSize int, keys *[]string) *StatusIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &StatusIterator{
+		PageSize: pageSize,
+		Overlap:  pageSize / 4,
+		client:   self,
+		stopped:  stopped,
+		keys:     withGid(keys),
+		seen:     make(map[string]bool),
+	}
+}
+
+// Reverse 从队尾向队首遍历 使用aria2的负offset 必须在第一次调用Next之前调用
+func (self *StatusIterator) Reverse() *StatusIterator {
+	self.reverse = true
+	return self
+}
+
+// Next 移动到下一个下载 没有更多下载或出错时返回false
+func (self *StatusIterator) Next() bool {
+	for {
+		if self.err != nil {
+			return false
+		}
+		for self.pos < len(self.page) {
+			status := self.page[self.pos]
+			self.pos++
+			if self.seen[status.Gid] {
+				continue
+			}
+			self.seen[status.Gid] = true
+			self.current = status
+			return true
+		}
+		if self.last {
+			return false
+		}
+		self.fetch()
+	}
+}
+
+func (self *StatusIterator) fetch() {
+	overlap := self.Overlap
+	if overlap >= self.PageSize {
+		overlap = self.PageSize - 1
+	}
+	if overlap < 0 || self.next == 0 {
+		overlap = 0
+	}
+	start := self.next - overlap
+	offset := start
+	if self.reverse {
+		offset = -1 - start
+	}
+	var page []DownloadStatus
+	if self.stopped {
+		page, self.err = self.client.tellStopped(offset, self.PageSize, self.keys)
+	} else {
+		page, self.err = self.client.tellWaiting(offset, self.PageSize, self.keys)
+	}
+	if self.err != nil {
+		return
+	}
+	self.page = page
+	self.pos = 0
+	self.next = start + len(page)
+	self.last = len(page) < self.PageSize
+}
+
+// Status 当前的下载
+func (self *StatusIterator) Status() DownloadStatus {
+	return self.current
+}
+
+// Err 遍历过程中发生的错误
+func (self *StatusIterator) Err() error {
+	return self.err
+}
+
+// All 遍历剩余的全部下载
+func (self *StatusIterator) All() ([]DownloadStatus, error) {
+	statuses := make([]DownloadStatus, 0)
+	for self.Next() {
+		statuses = append(statuses, self.Status())
+	}
+	return statuses, self.Err()
+}
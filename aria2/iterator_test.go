@@ -0,0 +1,173 @@
+package aria2
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeList 等待队列或已停止列表 负offset按aria2的规则从末尾倒序返回
+// afterPage在每次返回一页之后调用 用来模拟两次请求之间队列的变化
+type fakeList struct {
+	lock      sync.Mutex
+	method    string
+	gids      []string
+	offsets   []int
+	afterPage func(list *fakeList)
+}
+
+func newFakeList(method string, n int) *fakeList {
+	list := &fakeList{method: method}
+	for i := 0; i < n; i++ {
+		list.gids = append(list.gids, fmt.Sprintf("g%d", i))
+	}
+	return list
+}
+
+func (self *fakeList) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if method != self.method {
+		return versionHandler(method, params)
+	}
+	offset, num := int(params[0].(float64)), int(params[1].(float64))
+	self.offsets = append(self.offsets, offset)
+	statuses := make([]interface{}, 0)
+	for i := 0; i < num; i++ {
+		pos := offset + i
+		if offset < 0 {
+			pos = len(self.gids) + offset - i
+		}
+		if pos < 0 || pos >= len(self.gids) {
+			break
+		}
+		statuses = append(statuses, map[string]interface{}{"gid": self.gids[pos], "status": "waiting"})
+	}
+	if self.afterPage != nil {
+		self.afterPage(self)
+	}
+	return statuses, nil
+}
+
+func (self *fakeList) requested() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return fmt.Sprint(self.offsets)
+}
+
+func iterGids(t *testing.T, it *StatusIterator) string {
+	t.Helper()
+	statuses, err := it.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gids := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		gids = append(gids, status.Gid)
+	}
+	return strings.Join(gids, " ")
+}
+
+func TestIteratorPaging(t *testing.T) {
+	cases := []struct {
+		name     string
+		n        int
+		pageSize int
+		overlap  int
+		offsets  string
+	}{
+		// 每页和上一页重叠一条
+		{"overlap", 10, 4, 1, "[0 3 6 9]"},
+		// 条数正好是PageSize的整数倍 最后一页为空
+		{"exact pages", 8, 4, 0, "[0 4 8]"},
+		// 不满一页时不再请求
+		{"short page", 6, 4, 0, "[0 4]"},
+		{"single short page", 3, 4, 1, "[0]"},
+		{"empty", 0, 4, 1, "[0]"},
+		// Overlap不小于PageSize时按PageSize-1处理
+		{"overlap clamped", 5, 2, 5, "[0 1 2 3 4]"},
+	}
+	for _, c := range cases {
+		list := newFakeList("aria2.tellWaiting", c.n)
+		fake := newFakeAria2(t, list.handle)
+		client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+		it := client.IterWaiting(c.pageSize, &[]string{"status"})
+		it.Overlap = c.overlap
+		if got, want := iterGids(t, it), strings.Join(list.gids, " "); got != want {
+			t.Errorf("%s: gids %q, want %q", c.name, got, want)
+		}
+		if got := list.requested(); got != c.offsets {
+			t.Errorf("%s: offsets %s, want %s", c.name, got, c.offsets)
+		}
+		// 请求的keys总是包含gid
+		if keys := fmt.Sprint(fake.Params()[0][2]); keys != "[status gid]" {
+			t.Errorf("%s: keys %s", c.name, keys)
+		}
+	}
+}
+
+func TestIteratorQueueShift(t *testing.T) {
+	// 第一页之后队首的下载开始运行 后面的下载前移一位
+	shift := func(list *fakeList) {
+		if len(list.offsets) == 1 {
+			list.gids = list.gids[1:]
+		}
+	}
+	list := newFakeList("aria2.tellWaiting", 10)
+	list.afterPage = shift
+	fake := newFakeAria2(t, list.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	// 重叠的g3已经见过 按GID去重 g4没有漏掉
+	if got := iterGids(t, client.IterWaiting(4, nil)); got != "g0 g1 g2 g3 g4 g5 g6 g7 g8 g9" {
+		t.Fatalf("with overlap: %s", got)
+	}
+
+	// 没有重叠时前移的g4落在两页之间被漏掉
+	list = newFakeList("aria2.tellWaiting", 10)
+	list.afterPage = shift
+	fake = newFakeAria2(t, list.handle)
+	client = NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	it := client.IterWaiting(4, nil)
+	it.Overlap = 0
+	if got := iterGids(t, it); got != "g0 g1 g2 g3 g5 g6 g7 g8 g9" {
+		t.Fatalf("without overlap: %s", got)
+	}
+}
+
+func TestIteratorReverse(t *testing.T) {
+	list := newFakeList("aria2.tellStopped", 6)
+	fake := newFakeAria2(t, list.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	if got := iterGids(t, client.IterStopped(4, nil).Reverse()); got != "g5 g4 g3 g2 g1 g0" {
+		t.Fatalf("reverse: %s", got)
+	}
+	// 第二页从距离末尾第3条开始 重叠g2 只有3条所以停止
+	if got := list.requested(); got != "[-1 -4]" {
+		t.Fatalf("offsets %s", got)
+	}
+}
+
+func TestIteratorError(t *testing.T) {
+	list := newFakeList("aria2.tellWaiting", 10)
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method == "aria2.tellWaiting" && int(params[0].(float64)) > 0 {
+			return nil, &ErrorMsg{Code: 1, Message: "internal error"}
+		}
+		return list.handle(method, params)
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	it := client.IterWaiting(4, nil)
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 4 || it.Err() == nil {
+		t.Fatalf("got %d statuses, err %v", n, it.Err())
+	}
+	// 出错之后不再请求
+	calls := len(fake.Calls())
+	if it.Next() || len(fake.Calls()) != calls {
+		t.Fatal("Next continued after error")
+	}
+}
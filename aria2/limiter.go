@@ -0,0 +1,266 @@
+package aria2
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// HostCategory 按第一个uri的主机名分类 返回"host:example.com" 可以用作CategoryLimiter.Classify
+func HostCategory(status DownloadStatus) []string {
+	for _, file := range status.Files {
+		for _, uri := range file.Uris {
+			if u, err := url.Parse(uri.Uri); err == nil && u.Hostname() != "" {
+				return []string{"host:" + u.Hostname()}
+			}
+		}
+	}
+	return nil
+}
+
+// limiterState CategoryLimiter持久化的内容
+type limiterState struct {
+	Tags map[string][]string `json:"tags"`
+	Held []string            `json:"held"`
+}
+
+// CategoryLimiter 限制每个分类同时放行的下载数
+//
+// aria2只有全局的max-concurrent-downloads 这里把超出分类限制的下载暂停 有空位时再恢复
+// 放行指活动中或者在等待队列中没有暂停的下载 一个下载可以属于多个分类 必须所有分类都有空位才放行
+// 只会恢复自己暂停的下载 用户手动暂停的不受影响
+type CategoryLimiter struct {
+	Limits map[string]int // 分类到最大放行数 没有列出的分类不限制
+	// Classify 根据状态自动分类 结果与Tag设置的分类合并 设置后每次Enforce都会请求完整的状态
+	Classify func(status DownloadStatus) []string
+	// StatePath 保存标签和被暂停下载的文件 为空时不持久化
+	StatePath string
+	OnPause   func(gid string, category string)
+	OnResume  func(gid string)
+
+	client    *Aria2Client
+	tags      map[string][]string
+	held      map[string]bool
+	lock      sync.Mutex
+	enforcing sync.Mutex
+	attached  bool
+}
+
+// NewCategoryLimiter statePath非空且文件存在时读取之前保存的状态
+func NewCategoryLimiter(client *Aria2Client, limits map[string]int, statePath string) (*CategoryLimiter, error) {
+	if limits == nil {
+		limits = make(map[string]int)
+	}
+	limiter := &CategoryLimiter{
+		Limits:    limits,
+		StatePath: statePath,
+		client:    client,
+		tags:      make(map[string][]string),
+		held:      make(map[string]bool),
+	}
+	if statePath != "" {
+		state := limiterState{}
+		if ok, err := readJsonFile(statePath, &state); err != nil {
+			return nil, err
+		} else if ok {
+			for gid, categories := range state.Tags {
+				limiter.tags[gid] = categories
+			}
+			for _, gid := range state.Held {
+				limiter.held[gid] = true
+			}
+		}
+	}
+	return limiter, nil
+}
+
+// Tag 设置下载的分类 覆盖之前的设置
+func (self *CategoryLimiter) Tag(gid string, categories ...string) error {
+	self.lock.Lock()
+	self.tags[gid] = append([]string(nil), categories...)
+	self.lock.Unlock()
+	return self.save()
+}
+
+// Untag 删除下载的分类
+func (self *CategoryLimiter) Untag(gid string) error {
+	self.lock.Lock()
+	delete(self.tags, gid)
+	delete(self.held, gid)
+	self.lock.Unlock()
+	return self.save()
+}
+
+// Categories 下载通过Tag设置的分类
+func (self *CategoryLimiter) Categories(gid string) []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string(nil), self.tags[gid]...)
+}
+
+// Held 被限制器暂停的下载
+func (self *CategoryLimiter) Held() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	gids := make([]string, 0, len(self.held))
+	for gid := range self.held {
+		gids = append(gids, gid)
+	}
+	return gids
+}
+
+func (self *CategoryLimiter) save() error {
+	if self.StatePath == "" {
+		return nil
+	}
+	self.lock.Lock()
+	state := limiterState{Tags: make(map[string][]string, len(self.tags)), Held: make([]string, 0, len(self.held))}
+	for gid, categories := range self.tags {
+		state.Tags[gid] = categories
+	}
+	for gid := range self.held {
+		state.Held = append(state.Held, gid)
+	}
+	self.lock.Unlock()
+	return writeJsonFile(self.StatePath, state)
+}
+
+func (self *CategoryLimiter) categoriesOf(status DownloadStatus) []string {
+	categories := self.Categories(status.Gid)
+	if self.Classify != nil {
+		categories = append(categories, self.Classify(status)...)
+	}
+	return categories
+}
+
+// Attach 注册通知回调 下载开始、暂停、结束时重新检查 只需要调用一次
+// 需要支持通知的Handler 例如WebsocketRequestHandler
+func (self *CategoryLimiter) Attach() {
+	self.lock.Lock()
+	if self.attached {
+		self.lock.Unlock()
+		return
+	}
+	self.attached = true
+	self.lock.Unlock()
+	changed := func(client *Aria2Client, data RpcRequest) {
+		self.Enforce(context.Background())
+	}
+	finished := func(client *Aria2Client, data RpcRequest) {
+		self.Untag(notificationGid(data))
+		self.Enforce(context.Background())
+	}
+	self.client.OnDownloadStart(changed)
+	self.client.OnDownloadPause(changed)
+	self.client.OnDownloadComplete(finished)
+	self.client.OnDownloadError(finished)
+	self.client.OnDownloadStop(finished)
+}
+
+// Enforce 读取活动和等待中的下载 暂停超出限制的 恢复有空位的
+// 活动中的下载优先占用名额 等待队列按顺序占用剩余名额
+func (self *CategoryLimiter) Enforce(ctx context.Context) error {
+	self.enforcing.Lock()
+	defer self.enforcing.Unlock()
+	client := self.client.WithContext(ctx)
+	var keys *[]string
+	if self.Classify == nil {
+		keys = &[]string{"gid", "status"}
+	}
+	active, err := client.tellActive(keys)
+	if err != nil {
+		return err
+	}
+	waiting, err := client.IterWaiting(0, keys).All()
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	fits := func(categories []string) bool {
+		for _, category := range categories {
+			if limit, ok := self.Limits[category]; ok && counts[category] >= limit {
+				return false
+			}
+		}
+		return true
+	}
+	admit := func(categories []string) {
+		for _, category := range categories {
+			counts[category]++
+		}
+	}
+	blocking := func(categories []string) string {
+		for _, category := range categories {
+			if limit, ok := self.Limits[category]; ok && counts[category] >= limit {
+				return category
+			}
+		}
+		return ""
+	}
+
+	changed := false
+	queued := make(map[string]bool)
+	admitted := append(append([]DownloadStatus(nil), active...), waiting...)
+	for _, status := range admitted {
+		if status.Status == StatusPaused {
+			queued[status.Gid] = true
+			continue
+		}
+		categories := self.categoriesOf(status)
+		if fits(categories) {
+			admit(categories)
+			continue
+		}
+		if _, err := client.Pause(status.Gid); err != nil {
+			return err
+		}
+		self.lock.Lock()
+		self.held[status.Gid] = true
+		self.lock.Unlock()
+		queued[status.Gid] = true
+		changed = true
+		if self.OnPause != nil {
+			self.OnPause(status.Gid, blocking(categories))
+		}
+	}
+	for _, status := range waiting {
+		if status.Status != StatusPaused {
+			continue
+		}
+		self.lock.Lock()
+		held := self.held[status.Gid]
+		self.lock.Unlock()
+		if !held {
+			continue
+		}
+		categories := self.categoriesOf(status)
+		if !fits(categories) {
+			continue
+		}
+		if _, err := client.Unpause(status.Gid); err != nil {
+			return err
+		}
+		admit(categories)
+		self.lock.Lock()
+		delete(self.held, status.Gid)
+		self.lock.Unlock()
+		changed = true
+		if self.OnResume != nil {
+			self.OnResume(status.Gid)
+		}
+	}
+	// 已经不在等待队列里的下载不再算作被暂停
+	self.lock.Lock()
+	for gid := range self.held {
+		if !queued[gid] {
+			delete(self.held, gid)
+			changed = true
+		}
+	}
+	self.lock.Unlock()
+	if changed {
+		return self.save()
+	}
+	return nil
+}
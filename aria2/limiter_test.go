@@ -0,0 +1,235 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDownloads 活动和等待队列 按加入顺序排列 pause和unpause只改状态不改位置
+type fakeDownloads struct {
+	lock  sync.Mutex
+	order []string
+	state map[string]string
+	host  map[string]string
+}
+
+func newFakeDownloads(spec string) *fakeDownloads {
+	downloads := &fakeDownloads{state: make(map[string]string), host: make(map[string]string)}
+	// 每一项是gid:status:host
+	for _, item := range strings.Fields(spec) {
+		parts := strings.Split(item, ":")
+		downloads.order = append(downloads.order, parts[0])
+		downloads.state[parts[0]] = parts[1]
+		downloads.host[parts[0]] = parts[2]
+	}
+	return downloads
+}
+
+func (self *fakeDownloads) remove(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.state, gid)
+}
+
+func (self *fakeDownloads) states() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	list := make([]string, 0, len(self.order))
+	for _, gid := range self.order {
+		if state, ok := self.state[gid]; ok {
+			list = append(list, gid+":"+state)
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+func (self *fakeDownloads) list(match func(state string) bool) []interface{} {
+	statuses := make([]interface{}, 0)
+	for _, gid := range self.order {
+		state, ok := self.state[gid]
+		if !ok || !match(state) {
+			continue
+		}
+		statuses = append(statuses, map[string]interface{}{
+			"gid": gid, "status": state,
+			"files": []interface{}{map[string]interface{}{
+				"index": "1", "path": "",
+				"uris": []interface{}{map[string]interface{}{"uri": "http://" + self.host[gid] + "/" + gid, "status": "used"}},
+			}},
+		})
+	}
+	return statuses
+}
+
+func (self *fakeDownloads) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	switch method {
+	case "aria2.tellActive":
+		return self.list(func(state string) bool { return state == "active" }), nil
+	case "aria2.tellWaiting":
+		waiting := self.list(func(state string) bool { return state == "waiting" || state == "paused" })
+		offset, num := int(params[0].(float64)), int(params[1].(float64))
+		if offset >= len(waiting) {
+			return []interface{}{}, nil
+		}
+		if offset+num > len(waiting) {
+			num = len(waiting) - offset
+		}
+		return waiting[offset : offset+num], nil
+	case "aria2.pause":
+		self.state[params[0].(string)] = "paused"
+		return params[0], nil
+	case "aria2.unpause":
+		self.state[params[0].(string)] = "waiting"
+		return params[0], nil
+	}
+	return versionHandler(method, params)
+}
+
+func sortedHeld(limiter *CategoryLimiter) string {
+	held := limiter.Held()
+	sort.Strings(held)
+	return strings.Join(held, " ")
+}
+
+func TestCategoryLimiterEnforce(t *testing.T) {
+	// u1是用户手动暂停的 不受限制器影响
+	downloads := newFakeDownloads("a1:active:a a2:active:a a3:active:a b1:active:b a4:waiting:a u1:paused:a b2:waiting:b")
+	fake := newFakeAria2(t, downloads.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	limiter, err := NewCategoryLimiter(client, map[string]int{"host:a": 2, "video": 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Classify = HostCategory
+	var events []string
+	limiter.OnPause = func(gid string, category string) { events = append(events, "pause "+gid+" "+category) }
+	limiter.OnResume = func(gid string) { events = append(events, "resume "+gid) }
+
+	if err := limiter.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 活动中的下载优先占用名额 超出的和等待队列里的都被暂停
+	if got := downloads.states(); got != "a1:active a2:active a3:paused b1:active a4:paused u1:paused b2:waiting" {
+		t.Fatalf("after enforce: %s", got)
+	}
+	if got := sortedHeld(limiter); got != "a3 a4" {
+		t.Fatalf("held %s", got)
+	}
+	if fmt.Sprint(events) != "[pause a3 host:a pause a4 host:a]" {
+		t.Fatalf("events %v", events)
+	}
+
+	// 再次检查没有变化
+	events = nil
+	if err := limiter.Enforce(context.Background()); err != nil || events != nil {
+		t.Fatalf("second enforce: %v %v", err, events)
+	}
+
+	// a1结束后按队列顺序恢复一个 用户暂停的u1保持暂停
+	downloads.remove("a1")
+	if err := limiter.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloads.states(); got != "a2:active a3:waiting b1:active a4:paused u1:paused b2:waiting" {
+		t.Fatalf("after a1 finished: %s", got)
+	}
+	if fmt.Sprint(events) != "[resume a3]" || sortedHeld(limiter) != "a4" {
+		t.Fatalf("events %v held %s", events, sortedHeld(limiter))
+	}
+
+	// 被暂停的下载被删除后不再记录
+	downloads.remove("a4")
+	if err := limiter.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if held := limiter.Held(); len(held) != 0 {
+		t.Fatalf("held %v", held)
+	}
+}
+
+func TestCategoryLimiterTags(t *testing.T) {
+	downloads := newFakeDownloads("v1:active:a v2:active:b x1:waiting:c")
+	fake := newFakeAria2(t, downloads.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	path := filepath.Join(t.TempDir(), "limiter.json")
+	limiter, err := NewCategoryLimiter(client, map[string]int{"video": 1, "host:c": 0}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Tag("v1", "video")
+	limiter.Tag("v2", "video", "large")
+	var blocked string
+	limiter.OnPause = func(gid string, category string) { blocked = gid + " " + category }
+	if err := limiter.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 没有设置Classify时只按Tag分类 host:c不起作用 只请求gid和status
+	if got := downloads.states(); got != "v1:active v2:paused x1:waiting" || blocked != "v2 video" {
+		t.Fatalf("states %s blocked %q", got, blocked)
+	}
+	if keys := fmt.Sprint(fake.Params()[0][0]); keys != "[gid status]" {
+		t.Fatalf("keys %s", keys)
+	}
+
+	// 重新创建时读取保存的标签和被暂停的下载
+	restored, err := NewCategoryLimiter(client, map[string]int{"video": 1}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sortedHeld(restored) != "v2" || fmt.Sprint(restored.Categories("v2")) != "[video large]" {
+		t.Fatalf("restored held %s categories %v", sortedHeld(restored), restored.Categories("v2"))
+	}
+	// Untag之后v2不再受限制 恢复
+	restored.Untag("v1")
+	downloads.remove("v1")
+	if err := restored.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloads.states(); got != "v2:waiting x1:waiting" {
+		t.Fatalf("after v1 finished: %s", got)
+	}
+}
+
+func TestCategoryLimiterAttach(t *testing.T) {
+	downloads := newFakeDownloads("a1:active:a a2:active:a")
+	fake := newFakeAria2(t, downloads.handle)
+	handler := NewWebsocketRequestHandler()
+	defer handler.Close()
+	client := NewAria2Client(fake.RpcUrl(true), nil, nil, nil, nil, handler)
+	if err := client.StartErr(); err != nil {
+		t.Fatal(err)
+	}
+	limiter, _ := NewCategoryLimiter(client, map[string]int{"host:a": 1}, "")
+	limiter.Classify = HostCategory
+	limiter.Attach()
+	limiter.Attach()
+	limiter.Tag("a1", "extra")
+	if err := limiter.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloads.states(); got != "a1:active a2:paused" {
+		t.Fatalf("after enforce: %s", got)
+	}
+
+	// 收到完成通知后清除标签并恢复a2
+	downloads.remove("a1")
+	fake.Notify("aria2.onDownloadComplete", "a1")
+	deadline := time.Now().Add(2 * time.Second)
+	for downloads.states() != "a2:waiting" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := downloads.states(); got != "a2:waiting" {
+		t.Fatalf("after notification: %s", got)
+	}
+	if len(limiter.Categories("a1")) != 0 {
+		t.Fatal("tags kept after download finished")
+	}
+}
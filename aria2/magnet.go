@@ -0,0 +1,127 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"github.com/synodriver/goaria2/aria2/magnet"
+	"sync"
+	"time"
+)
+
+// ErrNoFollow 元数据下载已经结束 但没有产生后续的下载 例如设置了bt-metadata-only或follow-torrent=false
+var ErrNoFollow = errors.New("aria2: metadata download finished without followedBy")
+
+// MagnetHandle AddMagnet返回的句柄
+// aria2先用一个GID下载种子元数据 完成后在followedBy里给出真正下载内容的GID 句柄负责从前者跟踪到后者
+type MagnetHandle struct {
+	Magnet      *magnet.Magnet
+	MetadataGid string
+	// PollInterval Resolve和Wait轮询tellStatus的间隔 默认1秒
+	PollInterval time.Duration
+
+	client *Aria2Client
+	lock   sync.Mutex
+	gid    string
+}
+
+// AddMagnet 校验并提交磁力链接 options和position同AddUri
+func (self *Aria2Client) AddMagnet(link string, options *map[string]interface{}, position *int) (*MagnetHandle, error) {
+	m, err := magnet.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	res, err := self.AddUri([]string{link}, options, position)
+	if err != nil {
+		return nil, err
+	}
+	gid, ok := res.(string)
+	if !ok {
+		return nil, errors.New("aria2: unexpected addUri result")
+	}
+	return &MagnetHandle{Magnet: m, MetadataGid: gid, PollInterval: time.Second, client: self}, nil
+}
+
+// Gid 真正下载内容的GID 元数据还没下载完时返回空字符串
+func (self *MagnetHandle) Gid() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.gid
+}
+
+// Status 返回当前阶段的状态 已经跟踪到内容下载时返回内容下载的状态 否则返回元数据下载的状态
+func (self *MagnetHandle) Status() (DownloadStatus, error) {
+	if gid := self.Gid(); gid != "" {
+		return self.client.tellStatus(gid, nil)
+	}
+	status, err := self.client.tellStatus(self.MetadataGid, nil)
+	if err != nil || len(status.FollowedBy) == 0 {
+		return status, err
+	}
+	self.lock.Lock()
+	self.gid = status.FollowedBy[0]
+	self.lock.Unlock()
+	return self.client.tellStatus(status.FollowedBy[0], nil)
+}
+
+// Resolve 等待元数据下载完成 返回内容下载的GID
+// 元数据下载出错或被删除时返回带错误信息的error
+func (self *MagnetHandle) Resolve(ctx context.Context) (string, error) {
+	for {
+		if gid := self.Gid(); gid != "" {
+			return gid, nil
+		}
+		status, err := self.client.WithContext(ctx).tellStatus(self.MetadataGid, &[]string{"gid", "status", "followedBy", "errorCode", "errorMessage"})
+		if err != nil {
+			return "", err
+		}
+		if len(status.FollowedBy) > 0 {
+			self.lock.Lock()
+			self.gid = status.FollowedBy[0]
+			self.lock.Unlock()
+			continue
+		}
+		switch status.Status {
+		case StatusError:
+			return "", &DownloadError{Gid: status.Gid, Code: status.ErrorCode, Message: status.ErrorMessage}
+		case StatusRemoved:
+			return "", errors.New("aria2: metadata download removed")
+		case StatusComplete:
+			return "", ErrNoFollow
+		}
+		if err := sleepContext(ctx, self.pollInterval()); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Wait 等待内容下载结束(完成、出错或被删除) 返回最终状态
+func (self *MagnetHandle) Wait(ctx context.Context) (DownloadStatus, error) {
+	gid, err := self.Resolve(ctx)
+	if err != nil {
+		return DownloadStatus{}, err
+	}
+	for {
+		status, err := self.client.WithContext(ctx).tellStatus(gid, nil)
+		if err != nil {
+			return status, err
+		}
+		if status.Status.IsTerminal() {
+			return status, nil
+		}
+		if err := sleepContext(ctx, self.pollInterval()); err != nil {
+			return status, err
+		}
+	}
+}
+
+// Owns 判断通知里的gid是否属于这个磁力链接 可以在OnDownloadComplete等回调里使用
+func (self *MagnetHandle) Owns(gid string) bool {
+	return gid == self.MetadataGid || gid == self.Gid()
+}
+
+func (self *MagnetHandle) pollInterval() time.Duration {
+	if self.PollInterval > 0 {
+		return self.PollInterval
+	}
+	return time.Second
+}
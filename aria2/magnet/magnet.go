@@ -0,0 +1,171 @@
+// Package magnet 解析和生成BitTorrent磁力链接
+//
+//	magnet:?xt=urn:btih:<info-hash>&dn=<name>&tr=<tracker>&ws=<web seed>&xl=<size>
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/torrent"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const scheme = "magnet:?"
+
+// v2 info-hash的multihash前缀 0x12表示sha2-256 0x20是32字节
+const btmhPrefix = "1220"
+
+// Magnet 磁力链接的内容
+type Magnet struct {
+	InfoHash   string // v1 info-hash 40位小写十六进制 base32形式会被转换
+	InfoHashV2 string // v2 info-hash 64位小写十六进制 不含multihash前缀
+	Name       string // dn
+	Length     int64  // xl 0表示未知
+	Trackers   []string
+	WebSeeds   []string
+	// Extra 其余不认识的参数 例如x.pe、so 生成链接时原样写出
+	Extra url.Values
+}
+
+// Parse 解析磁力链接 至少需要一个btih或btmh
+func Parse(s string) (*Magnet, error) {
+	if !strings.HasPrefix(strings.ToLower(s), scheme) {
+		return nil, fmt.Errorf("magnet: not a magnet link: %q", s)
+	}
+	m := &Magnet{Extra: url.Values{}}
+	// 不用url.ParseQuery 它不保留tracker的顺序
+	for _, pair := range strings.Split(s[len(scheme):], "&") {
+		if pair == "" {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		var err error
+		if key, err = url.QueryUnescape(key); err != nil {
+			return nil, fmt.Errorf("magnet: %w", err)
+		}
+		if value, err = url.QueryUnescape(value); err != nil {
+			return nil, fmt.Errorf("magnet: %w", err)
+		}
+		// tr.1 xt.2这种带序号的写法
+		base := key
+		if i := strings.IndexByte(key, '.'); i > 0 {
+			if _, err := strconv.Atoi(key[i+1:]); err == nil {
+				base = key[:i]
+			}
+		}
+		switch base {
+		case "xt":
+			if err := m.parseTopic(value); err != nil {
+				return nil, err
+			}
+		case "dn":
+			m.Name = value
+		case "xl":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("magnet: invalid xl %q", value)
+			}
+			m.Length = n
+		case "tr":
+			m.Trackers = appendUnique(m.Trackers, value)
+		case "ws":
+			m.WebSeeds = appendUnique(m.WebSeeds, value)
+		default:
+			m.Extra[key] = append(m.Extra[key], value)
+		}
+	}
+	if m.InfoHash == "" && m.InfoHashV2 == "" {
+		return nil, errors.New("magnet: missing xt=urn:btih or xt=urn:btmh")
+	}
+	return m, nil
+}
+
+func (self *Magnet) parseTopic(topic string) error {
+	lower := strings.ToLower(topic)
+	switch {
+	case strings.HasPrefix(lower, "urn:btih:"):
+		hash := topic[len("urn:btih:"):]
+		switch len(hash) {
+		case 40:
+			if _, err := hex.DecodeString(hash); err != nil {
+				return fmt.Errorf("magnet: invalid btih %q", hash)
+			}
+			self.InfoHash = strings.ToLower(hash)
+		case 32:
+			b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+			if err != nil {
+				return fmt.Errorf("magnet: invalid btih %q", hash)
+			}
+			self.InfoHash = hex.EncodeToString(b)
+		default:
+			return fmt.Errorf("magnet: invalid btih %q", hash)
+		}
+	case strings.HasPrefix(lower, "urn:btmh:"):
+		hash := strings.ToLower(topic[len("urn:btmh:"):])
+		if len(hash) != len(btmhPrefix)+64 || !strings.HasPrefix(hash, btmhPrefix) {
+			return fmt.Errorf("magnet: unsupported btmh %q", hash)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("magnet: invalid btmh %q", hash)
+		}
+		self.InfoHashV2 = hash[len(btmhPrefix):]
+	}
+	// 其他类型的xt(例如ed2k)忽略
+	return nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// String 生成磁力链接 参数顺序固定为xt dn xl tr ws 然后是Extra
+func (self *Magnet) String() string {
+	parts := make([]string, 0, 4+len(self.Trackers)+len(self.WebSeeds))
+	if self.InfoHash != "" {
+		parts = append(parts, "xt=urn:btih:"+self.InfoHash)
+	}
+	if self.InfoHashV2 != "" {
+		parts = append(parts, "xt=urn:btmh:"+btmhPrefix+self.InfoHashV2)
+	}
+	if self.Name != "" {
+		parts = append(parts, "dn="+url.QueryEscape(self.Name))
+	}
+	if self.Length > 0 {
+		parts = append(parts, "xl="+strconv.FormatInt(self.Length, 10))
+	}
+	for _, tracker := range self.Trackers {
+		parts = append(parts, "tr="+url.QueryEscape(tracker))
+	}
+	for _, seed := range self.WebSeeds {
+		parts = append(parts, "ws="+url.QueryEscape(seed))
+	}
+	if len(self.Extra) > 0 {
+		parts = append(parts, self.Extra.Encode())
+	}
+	return scheme + strings.Join(parts, "&")
+}
+
+// FromTorrent 根据种子生成磁力链接 混合种子同时带btih和btmh
+func FromTorrent(m *torrent.MetaInfo) *Magnet {
+	return &Magnet{
+		InfoHash:   m.InfoHash,
+		InfoHashV2: m.InfoHashV2,
+		Name:       m.Name,
+		Length:     m.TotalLength(),
+		Trackers:   m.Trackers(),
+		WebSeeds:   append([]string(nil), m.UrlList...),
+		Extra:      url.Values{},
+	}
+}
@@ -0,0 +1,143 @@
+package magnet
+
+import (
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/torrent"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	v1Hash = "0123456789abcdef0123456789abcdef01234567"
+	v2Hash = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+)
+
+func TestParse(t *testing.T) {
+	link := "magnet:?xt=urn:btih:0123456789ABCDEF0123456789ABCDEF01234567" +
+		"&xt=urn:btmh:1220" + v2Hash +
+		"&dn=Ubuntu%2024.04+Desktop%20%28amd64%29.iso" +
+		"&xl=6114656256" +
+		"&tr=udp%3A%2F%2Ft1.example%3A6969%2Fannounce" +
+		"&tr.1=http://t2.example/announce" +
+		"&tr=udp%3A%2F%2Ft1.example%3A6969%2Fannounce" +
+		"&ws=http%3A%2F%2Fmirror1.example%2Fubuntu.iso" +
+		"&ws=http://mirror2.example/ubuntu.iso" +
+		"&x.pe=10.0.0.1:6881&so=0,2-4"
+	m, err := Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.InfoHash != v1Hash || m.InfoHashV2 != v2Hash {
+		t.Fatalf("hashes %q %q", m.InfoHash, m.InfoHashV2)
+	}
+	if m.Name != "Ubuntu 24.04 Desktop (amd64).iso" || m.Length != 6114656256 {
+		t.Fatalf("dn=%q xl=%d", m.Name, m.Length)
+	}
+	// 顺序保持 重复的tracker去掉
+	if fmt.Sprint(m.Trackers) != "[udp://t1.example:6969/announce http://t2.example/announce]" {
+		t.Fatalf("trackers %q", m.Trackers)
+	}
+	if fmt.Sprint(m.WebSeeds) != "[http://mirror1.example/ubuntu.iso http://mirror2.example/ubuntu.iso]" {
+		t.Fatalf("web seeds %q", m.WebSeeds)
+	}
+	if m.Extra.Get("x.pe") != "10.0.0.1:6881" || m.Extra.Get("so") != "0,2-4" {
+		t.Fatalf("extra %v", m.Extra)
+	}
+
+	// 生成的链接再解析得到同样的内容
+	again, err := Parse(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%+v", again) != fmt.Sprintf("%+v", m) {
+		t.Fatalf("round trip:\n%+v\n%+v", again, m)
+	}
+}
+
+func TestParseBtihForms(t *testing.T) {
+	cases := []struct {
+		link string
+		v1   string
+		v2   string
+	}{
+		{"magnet:?xt=urn:btih:" + v1Hash, v1Hash, ""},
+		// base32形式转换成十六进制
+		{"magnet:?xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH", v1Hash, ""},
+		{"MAGNET:?xt=URN:BTIH:" + v1Hash, v1Hash, ""},
+		// 纯v2
+		{"magnet:?xt=urn:btmh:1220" + v2Hash + "&dn=v2", "", v2Hash},
+		// 其他类型的xt忽略
+		{"magnet:?xt=urn:ed2k:31d6cfe0d16ae931b73c59d7e0c089c0&xt=urn:btih:" + v1Hash, v1Hash, ""},
+	}
+	for _, c := range cases {
+		m, err := Parse(c.link)
+		if err != nil {
+			t.Errorf("%s: %v", c.link, err)
+			continue
+		}
+		if m.InfoHash != c.v1 || m.InfoHashV2 != c.v2 {
+			t.Errorf("%s: hashes %q %q", c.link, m.InfoHash, m.InfoHashV2)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, link := range []string{
+		"http://example.com/?xt=urn:btih:" + v1Hash,
+		"magnet:?dn=no-hash",
+		"magnet:?xt=urn:ed2k:31d6cfe0d16ae931b73c59d7e0c089c0",
+		"magnet:?xt=urn:btih:0123",
+		"magnet:?xt=urn:btih:zz23456789abcdef0123456789abcdef01234567",
+		"magnet:?xt=urn:btmh:1114" + v2Hash,
+		"magnet:?xt=urn:btmh:1220" + v2Hash[:62],
+		"magnet:?xt=urn:btih:" + v1Hash + "&xl=-1",
+		"magnet:?xt=urn:btih:" + v1Hash + "&dn=%zz",
+	} {
+		if _, err := Parse(link); err == nil {
+			t.Errorf("%s parsed without error", link)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	m := &Magnet{
+		InfoHash:   v1Hash,
+		InfoHashV2: v2Hash,
+		Name:       "a b&c.iso",
+		Length:     1024,
+		Trackers:   []string{"udp://t1.example:6969", "http://t2.example/announce?k=1&x=2"},
+		WebSeeds:   []string{"http://seed.example/a b.iso"},
+	}
+	want := "magnet:?xt=urn:btih:" + v1Hash + "&xt=urn:btmh:1220" + v2Hash +
+		"&dn=a+b%26c.iso&xl=1024" +
+		"&tr=udp%3A%2F%2Ft1.example%3A6969&tr=http%3A%2F%2Ft2.example%2Fannounce%3Fk%3D1%26x%3D2" +
+		"&ws=http%3A%2F%2Fseed.example%2Fa+b.iso"
+	if got := m.String(); got != want {
+		t.Fatalf("String()\n got %s\nwant %s", got, want)
+	}
+	parsed, err := Parse(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != m.Name || fmt.Sprint(parsed.Trackers) != fmt.Sprint(m.Trackers) || fmt.Sprint(parsed.WebSeeds) != fmt.Sprint(m.WebSeeds) {
+		t.Fatalf("round trip %+v", parsed)
+	}
+}
+
+func TestFromTorrent(t *testing.T) {
+	meta, err := torrent.ParseFile(filepath.Join("..", "torrent", "testdata", "hybrid.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := FromTorrent(meta)
+	if m.InfoHash != meta.InfoHash || m.InfoHashV2 != meta.InfoHashV2 || m.Name != "v2" || m.Length != 70010 {
+		t.Fatalf("magnet %+v", m)
+	}
+	parsed, err := Parse(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.InfoHash != meta.InfoHash || parsed.InfoHashV2 != meta.InfoHashV2 || fmt.Sprint(parsed.Trackers) != "[http://tracker.example/announce]" {
+		t.Fatalf("parsed %+v", parsed)
+	}
+}
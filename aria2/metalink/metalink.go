@@ -0,0 +1,475 @@
+// Package metalink 生成和解析Metalink文档
+//
+// 支持Metalink 4.0(RFC 5854 .meta4)和Metalink 3.0(.metalink) 两种格式共用同一组结构体
+// 哈希类型统一使用4.0的写法 例如sha-256 写出3.0格式时自动转换
+package metalink
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	NamespaceV4 = "urn:ietf:params:xml:ns:metalink"
+	NamespaceV3 = "http://www.metalinker.org/"
+)
+
+// Hash 整个文件的校验值 Type例如sha-256、sha-1、md5 Value是十六进制
+type Hash struct {
+	Type  string
+	Value string
+}
+
+// Pieces 分块校验 第i个哈希对应文件中[i*Length, (i+1)*Length)的内容
+type Pieces struct {
+	Length int64
+	Type   string
+	Hashes []string
+}
+
+// Url 一个镜像 Priority越小越优先 0表示未指定 Location是ISO 3166-1国家代码
+type Url struct {
+	Url      string
+	Location string
+	Priority int
+}
+
+// MetaUrl 指向其他元数据的地址 例如MediaType为torrent的种子文件
+type MetaUrl struct {
+	Url       string
+	MediaType string
+	Priority  int
+	Name      string
+}
+
+// File 一个要下载的文件 Name是相对路径
+type File struct {
+	Name        string
+	Size        int64
+	Identity    string
+	Version     string
+	Description string
+	Language    []string
+	OS          []string
+	Hashes      []Hash
+	Pieces      *Pieces
+	Urls        []Url
+	MetaUrls    []MetaUrl
+}
+
+// Metalink 一个Metalink文档 Version是解析得到的格式版本 3或4
+type Metalink struct {
+	Version   int
+	Generator string
+	Published time.Time
+	Files     []File
+}
+
+// Hash 返回指定类型的校验值 类型用4.0的写法
+func (self *File) Hash(type_ string) (string, bool) {
+	for _, hash := range self.Hashes {
+		if hash.Type == type_ {
+			return hash.Value, true
+		}
+	}
+	return "", false
+}
+
+type xmlHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlV4 struct {
+	XMLName   xml.Name    `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Generator string      `xml:"generator,omitempty"`
+	Published string      `xml:"published,omitempty"`
+	Files     []xmlV4File `xml:"file"`
+}
+
+type xmlV4File struct {
+	Name        string         `xml:"name,attr"`
+	Size        int64          `xml:"size,omitempty"`
+	Identity    string         `xml:"identity,omitempty"`
+	Version     string         `xml:"version,omitempty"`
+	Description string         `xml:"description,omitempty"`
+	Language    []string       `xml:"language"`
+	OS          []string       `xml:"os"`
+	Hashes      []xmlHash      `xml:"hash"`
+	Pieces      []xmlV4Pieces  `xml:"pieces"`
+	Urls        []xmlV4Url     `xml:"url"`
+	MetaUrls    []xmlV4MetaUrl `xml:"metaurl"`
+}
+
+type xmlV4Pieces struct {
+	Length int64    `xml:"length,attr"`
+	Type   string   `xml:"type,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+type xmlV4Url struct {
+	Location string `xml:"location,attr,omitempty"`
+	Priority int    `xml:"priority,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type xmlV4MetaUrl struct {
+	MediaType string `xml:"mediatype,attr"`
+	Priority  int    `xml:"priority,attr,omitempty"`
+	Name      string `xml:"name,attr,omitempty"`
+	Value     string `xml:",chardata"`
+}
+
+type xmlV3 struct {
+	XMLName   xml.Name    `xml:"http://www.metalinker.org/ metalink"`
+	Version   string      `xml:"version,attr"`
+	Generator string      `xml:"generator,attr,omitempty"`
+	Pubdate   string      `xml:"pubdate,attr,omitempty"`
+	Files     []xmlV3File `xml:"files>file"`
+}
+
+type xmlV3File struct {
+	Name         string             `xml:"name,attr"`
+	Size         int64              `xml:"size,omitempty"`
+	Identity     string             `xml:"identity,omitempty"`
+	Version      string             `xml:"version,omitempty"`
+	Description  string             `xml:"description,omitempty"`
+	Language     string             `xml:"language,omitempty"`
+	OS           string             `xml:"os,omitempty"`
+	Verification *xmlV3Verification `xml:"verification"`
+	Urls         []xmlV3Url         `xml:"resources>url"`
+}
+
+type xmlV3Verification struct {
+	Hashes []xmlHash    `xml:"hash"`
+	Pieces *xmlV3Pieces `xml:"pieces"`
+}
+
+type xmlV3Pieces struct {
+	Length int64        `xml:"length,attr"`
+	Type   string       `xml:"type,attr"`
+	Hashes []xmlV3Piece `xml:"hash"`
+}
+
+type xmlV3Piece struct {
+	Piece int    `xml:"piece,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlV3Url struct {
+	Type       string `xml:"type,attr"`
+	Location   string `xml:"location,attr,omitempty"`
+	Preference int    `xml:"preference,attr,omitempty"`
+	Value      string `xml:",chardata"`
+}
+
+// Parse 解析Metalink文档 根据根元素的命名空间判断版本
+func Parse(data []byte) (*Metalink, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("metalink: missing metalink element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "metalink" {
+			return nil, fmt.Errorf("metalink: unexpected root element %q", start.Name.Local)
+		}
+		switch start.Name.Space {
+		case NamespaceV4:
+			return parseV4(data)
+		case NamespaceV3:
+			return parseV3(data)
+		default:
+			return nil, fmt.Errorf("metalink: unknown namespace %q", start.Name.Space)
+		}
+	}
+}
+
+// ParseReader 读取全部数据后解析
+func ParseReader(r io.Reader) (*Metalink, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// ParseFile 解析.meta4或.metalink文件
+func ParseFile(path string) (*Metalink, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func parseV4(data []byte) (*Metalink, error) {
+	doc := xmlV4{}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	m := &Metalink{Version: 4, Generator: strings.TrimSpace(doc.Generator)}
+	if doc.Published != "" {
+		published, err := time.Parse(time.RFC3339, strings.TrimSpace(doc.Published))
+		if err != nil {
+			return nil, fmt.Errorf("metalink: invalid published %q", doc.Published)
+		}
+		m.Published = published
+	}
+	for _, f := range doc.Files {
+		file := File{
+			Name:        f.Name,
+			Size:        f.Size,
+			Identity:    strings.TrimSpace(f.Identity),
+			Version:     strings.TrimSpace(f.Version),
+			Description: strings.TrimSpace(f.Description),
+			Language:    trimAll(f.Language),
+			OS:          trimAll(f.OS),
+		}
+		for _, hash := range f.Hashes {
+			file.Hashes = append(file.Hashes, Hash{Type: hashTypeV4(hash.Type), Value: strings.TrimSpace(hash.Value)})
+		}
+		if len(f.Pieces) > 0 { // 有多种类型时取第一种
+			pieces := f.Pieces[0]
+			file.Pieces = &Pieces{Length: pieces.Length, Type: hashTypeV4(pieces.Type), Hashes: trimAll(pieces.Hashes)}
+		}
+		for _, u := range f.Urls {
+			file.Urls = append(file.Urls, Url{Url: strings.TrimSpace(u.Value), Location: u.Location, Priority: u.Priority})
+		}
+		for _, u := range f.MetaUrls {
+			file.MetaUrls = append(file.MetaUrls, MetaUrl{Url: strings.TrimSpace(u.Value), MediaType: u.MediaType, Priority: u.Priority, Name: u.Name})
+		}
+		m.Files = append(m.Files, file)
+	}
+	return m, m.validate()
+}
+
+func parseV3(data []byte) (*Metalink, error) {
+	doc := xmlV3{}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	m := &Metalink{Version: 3, Generator: doc.Generator}
+	if doc.Pubdate != "" {
+		published, err := time.Parse(time.RFC1123, doc.Pubdate)
+		if err != nil {
+			if published, err = time.Parse(time.RFC1123Z, doc.Pubdate); err != nil {
+				return nil, fmt.Errorf("metalink: invalid pubdate %q", doc.Pubdate)
+			}
+		}
+		m.Published = published
+	}
+	for _, f := range doc.Files {
+		file := File{
+			Name:        f.Name,
+			Size:        f.Size,
+			Identity:    strings.TrimSpace(f.Identity),
+			Version:     strings.TrimSpace(f.Version),
+			Description: strings.TrimSpace(f.Description),
+		}
+		if language := strings.TrimSpace(f.Language); language != "" {
+			file.Language = []string{language}
+		}
+		if system := strings.TrimSpace(f.OS); system != "" {
+			file.OS = []string{system}
+		}
+		if f.Verification != nil {
+			for _, hash := range f.Verification.Hashes {
+				file.Hashes = append(file.Hashes, Hash{Type: hashTypeV4(hash.Type), Value: strings.TrimSpace(hash.Value)})
+			}
+			if pieces := f.Verification.Pieces; pieces != nil {
+				hashes := make([]string, len(pieces.Hashes))
+				for _, piece := range pieces.Hashes {
+					if piece.Piece < 0 || piece.Piece >= len(hashes) {
+						return nil, fmt.Errorf("metalink: invalid piece index %d", piece.Piece)
+					}
+					hashes[piece.Piece] = strings.TrimSpace(piece.Value)
+				}
+				file.Pieces = &Pieces{Length: pieces.Length, Type: hashTypeV4(pieces.Type), Hashes: hashes}
+			}
+		}
+		for _, u := range f.Urls {
+			if u.Type == "bittorrent" {
+				file.MetaUrls = append(file.MetaUrls, MetaUrl{Url: strings.TrimSpace(u.Value), MediaType: "torrent", Priority: priorityV4(u.Preference)})
+				continue
+			}
+			file.Urls = append(file.Urls, Url{Url: strings.TrimSpace(u.Value), Location: u.Location, Priority: priorityV4(u.Preference)})
+		}
+		m.Files = append(m.Files, file)
+	}
+	return m, m.validate()
+}
+
+func (self *Metalink) validate() error {
+	if len(self.Files) == 0 {
+		return errors.New("metalink: no file")
+	}
+	for _, f := range self.Files {
+		if f.Name == "" || strings.HasPrefix(f.Name, "/") || strings.Contains("/"+f.Name+"/", "/../") {
+			return fmt.Errorf("metalink: invalid file name %q", f.Name)
+		}
+		if len(f.Urls) == 0 && len(f.MetaUrls) == 0 {
+			return fmt.Errorf("metalink: file %q has no url", f.Name)
+		}
+	}
+	return nil
+}
+
+// MarshalV4 生成Metalink 4.0文档
+func (self *Metalink) MarshalV4() ([]byte, error) {
+	if err := self.validate(); err != nil {
+		return nil, err
+	}
+	doc := xmlV4{Generator: self.Generator}
+	if !self.Published.IsZero() {
+		doc.Published = self.Published.UTC().Format(time.RFC3339)
+	}
+	for _, f := range self.Files {
+		file := xmlV4File{
+			Name:        f.Name,
+			Size:        f.Size,
+			Identity:    f.Identity,
+			Version:     f.Version,
+			Description: f.Description,
+			Language:    f.Language,
+			OS:          f.OS,
+		}
+		for _, hash := range f.Hashes {
+			file.Hashes = append(file.Hashes, xmlHash{Type: hashTypeV4(hash.Type), Value: hash.Value})
+		}
+		if f.Pieces != nil {
+			file.Pieces = []xmlV4Pieces{{Length: f.Pieces.Length, Type: hashTypeV4(f.Pieces.Type), Hashes: f.Pieces.Hashes}}
+		}
+		for _, u := range f.Urls {
+			file.Urls = append(file.Urls, xmlV4Url{Location: u.Location, Priority: u.Priority, Value: u.Url})
+		}
+		for _, u := range f.MetaUrls {
+			file.MetaUrls = append(file.MetaUrls, xmlV4MetaUrl{MediaType: u.MediaType, Priority: u.Priority, Name: u.Name, Value: u.Url})
+		}
+		doc.Files = append(doc.Files, file)
+	}
+	return marshal(doc)
+}
+
+// MarshalV3 生成Metalink 3.0文档 只保留每个文件的第一个语言和操作系统 种子类型的MetaUrl写成bittorrent类型的url
+func (self *Metalink) MarshalV3() ([]byte, error) {
+	if err := self.validate(); err != nil {
+		return nil, err
+	}
+	doc := xmlV3{Version: "3.0", Generator: self.Generator}
+	if !self.Published.IsZero() {
+		doc.Pubdate = self.Published.UTC().Format(time.RFC1123)
+	}
+	for _, f := range self.Files {
+		file := xmlV3File{
+			Name:        f.Name,
+			Size:        f.Size,
+			Identity:    f.Identity,
+			Version:     f.Version,
+			Description: f.Description,
+		}
+		if len(f.Language) > 0 {
+			file.Language = f.Language[0]
+		}
+		if len(f.OS) > 0 {
+			file.OS = f.OS[0]
+		}
+		if len(f.Hashes) > 0 || f.Pieces != nil {
+			verification := &xmlV3Verification{}
+			for _, hash := range f.Hashes {
+				verification.Hashes = append(verification.Hashes, xmlHash{Type: hashTypeV3(hash.Type), Value: hash.Value})
+			}
+			if f.Pieces != nil {
+				pieces := &xmlV3Pieces{Length: f.Pieces.Length, Type: hashTypeV3(f.Pieces.Type)}
+				for i, hash := range f.Pieces.Hashes {
+					pieces.Hashes = append(pieces.Hashes, xmlV3Piece{Piece: i, Value: hash})
+				}
+				verification.Pieces = pieces
+			}
+			file.Verification = verification
+		}
+		for _, u := range f.Urls {
+			file.Urls = append(file.Urls, xmlV3Url{Type: protocol(u.Url), Location: u.Location, Preference: preferenceV3(u.Priority), Value: u.Url})
+		}
+		for _, u := range f.MetaUrls {
+			if u.MediaType == "torrent" {
+				file.Urls = append(file.Urls, xmlV3Url{Type: "bittorrent", Preference: preferenceV3(u.Priority), Value: u.Url})
+			}
+		}
+		doc.Files = append(doc.Files, file)
+	}
+	return marshal(doc)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+func trimAll(list []string) []string {
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// hashTypeV4 sha256 -> sha-256
+func hashTypeV4(type_ string) string {
+	type_ = strings.ToLower(strings.TrimSpace(type_))
+	if strings.HasPrefix(type_, "sha") && !strings.HasPrefix(type_, "sha-") {
+		return "sha-" + type_[3:]
+	}
+	return type_
+}
+
+// hashTypeV3 sha-256 -> sha256
+func hashTypeV3(type_ string) string {
+	return strings.Replace(hashTypeV4(type_), "sha-", "sha", 1)
+}
+
+// 3.0的preference是1到100 越大越优先 4.0的priority越小越优先
+func priorityV4(preference int) int {
+	if preference <= 0 {
+		return 0
+	}
+	if preference > 100 {
+		preference = 100
+	}
+	return 101 - preference
+}
+
+func preferenceV3(priority int) int {
+	if priority <= 0 {
+		return 0
+	}
+	if priority > 100 {
+		return 1
+	}
+	return 101 - priority
+}
+
+func protocol(u string) string {
+	if i := strings.Index(u, "://"); i > 0 {
+		return strings.ToLower(u[:i])
+	}
+	return "http"
+}
@@ -0,0 +1,98 @@
+package metalink
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func sample() *Metalink {
+	return &Metalink{
+		Generator: "goaria2",
+		Published: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Files: []File{{
+			Name:     "dir/file.iso",
+			Size:     2048,
+			Language: []string{"en"},
+			OS:       []string{"Linux-x86"},
+			Hashes:   []Hash{{Type: "sha256", Value: "abcd"}},
+			Pieces:   &Pieces{Length: 1024, Type: "sha-1", Hashes: []string{"p0", "p1"}},
+			Urls: []Url{
+				{Url: "http://a.example/file.iso", Location: "de", Priority: 1},
+				{Url: "ftp://b.example/file.iso", Priority: 10},
+			},
+			MetaUrls: []MetaUrl{{Url: "http://a.example/file.torrent", MediaType: "torrent", Priority: 2}},
+		}},
+	}
+}
+
+func TestRoundTripV4(t *testing.T) {
+	data, err := sample().MarshalV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 4 || m.Generator != "goaria2" || !m.Published.Equal(sample().Published) {
+		t.Fatalf("metadata %+v", m)
+	}
+	f := m.Files[0]
+	// 哈希类型统一成4.0的写法
+	if v, ok := f.Hash("sha-256"); !ok || v != "abcd" {
+		t.Fatalf("hashes %+v", f.Hashes)
+	}
+	if f.Pieces == nil || f.Pieces.Type != "sha-1" || strings.Join(f.Pieces.Hashes, " ") != "p0 p1" {
+		t.Fatalf("pieces %+v", f.Pieces)
+	}
+	if len(f.Urls) != 2 || f.Urls[0] != (Url{Url: "http://a.example/file.iso", Location: "de", Priority: 1}) {
+		t.Fatalf("urls %+v", f.Urls)
+	}
+	if len(f.MetaUrls) != 1 || f.MetaUrls[0].MediaType != "torrent" {
+		t.Fatalf("metaurls %+v", f.MetaUrls)
+	}
+}
+
+func TestRoundTripV3(t *testing.T) {
+	data, err := sample().MarshalV3()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `type="sha256"`) || !strings.Contains(string(data), `type="bittorrent"`) {
+		t.Fatalf("v3 document:\n%s", data)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 3 || !m.Published.Equal(sample().Published) {
+		t.Fatalf("metadata %+v", m)
+	}
+	f := m.Files[0]
+	if v, _ := f.Hash("sha-256"); v != "abcd" || strings.Join(f.Pieces.Hashes, " ") != "p0 p1" {
+		t.Fatalf("verification %+v %+v", f.Hashes, f.Pieces)
+	}
+	// preference和priority互相转换 种子类型的url变回MetaUrl
+	if len(f.Urls) != 2 || f.Urls[0].Priority != 1 || f.Urls[1].Priority != 10 {
+		t.Fatalf("urls %+v", f.Urls)
+	}
+	if len(f.MetaUrls) != 1 || f.MetaUrls[0].MediaType != "torrent" || f.MetaUrls[0].Priority != 2 {
+		t.Fatalf("metaurls %+v", f.MetaUrls)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, doc := range []string{
+		`<?xml version="1.0"?><other xmlns="urn:ietf:params:xml:ns:metalink"/>`,
+		`<metalink xmlns="urn:example"/>`,
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`,
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="../x"><url>http://a/x</url></file></metalink>`,
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="x"></file></metalink>`,
+		``,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s parsed without error", doc)
+		}
+	}
+}
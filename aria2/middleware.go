@@ -0,0 +1,163 @@
+package aria2
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Invoker 发送一个rpc请求并返回响应
+type Invoker func(ctx context.Context, req RpcRequest) (RpcResponse, error)
+
+// Interceptor 拦截器 可以修改请求、记录结果、重试 调用next把请求交给下一层
+// http websocket以及batch(Flush) 的请求都会经过同一条链
+type Interceptor func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error)
+
+// ChainInterceptors 把多个拦截器合成一个 第一个在最外层
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		invoker := next
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], invoker
+			invoker = func(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+				return interceptor(ctx, req, inner)
+			}
+		}
+		return invoker(ctx, req)
+	}
+}
+
+// LoggingInterceptor 记录每次调用的方法名、耗时和错误 不会输出参数 避免把token写进日志
+//:param logf: 例如log.Printf
+func LoggingInterceptor(logf func(format string, args ...interface{})) Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		if err != nil {
+			logf("aria2: %s id=%v failed after %s: %v", req.Method, req.Id, time.Since(start), err)
+		} else {
+			logf("aria2: %s id=%v ok in %s", req.Method, req.Id, time.Since(start))
+		}
+		return res, err
+	}
+}
+
+// MetricsInterceptor 每次调用结束后回调observe 用来接入prometheus之类的指标
+func MetricsInterceptor(observe func(method string, elapsed time.Duration, err error)) Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		observe(req.Method, time.Since(start), err)
+		return res, err
+	}
+}
+
+// TimeoutInterceptor 给没有deadline的调用加上超时
+func TimeoutInterceptor(timeout time.Duration) Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return next(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return next(ctx, req)
+	}
+}
+
+// TokenInterceptor 每次调用时用token()的返回值重写rpc密钥 适合密钥会轮换的场景
+// 已有的"token:"参数会被替换 system.multicall里的每个子调用都会处理
+// system.listMethods和system.listNotifications不需要密钥 保持原样
+func TokenInterceptor(token func() string) Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		tokenStr := "token:" + token()
+		switch req.Method {
+		case "system.listMethods", "system.listNotifications":
+		case "system.multicall":
+			if len(req.Params) > 0 {
+				if calls, ok := req.Params[0].([]interface{}); ok {
+					newCalls := make([]interface{}, 0, len(calls))
+					for _, call := range calls {
+						m, ok := call.(map[string]interface{})
+						if !ok {
+							newCalls = append(newCalls, call)
+							continue
+						}
+						newCall := make(map[string]interface{}, len(m))
+						for k, v := range m {
+							newCall[k] = v
+						}
+						params, _ := m["params"].([]interface{})
+						newCall["params"] = replaceToken(params, tokenStr)
+						newCalls = append(newCalls, newCall)
+					}
+					req.Params = append([]interface{}{newCalls}, req.Params[1:]...)
+				}
+			}
+		default:
+			req.Params = replaceToken(req.Params, tokenStr)
+		}
+		return next(ctx, req)
+	}
+}
+
+func replaceToken(params []interface{}, tokenStr string) []interface{} {
+	newParams := make([]interface{}, 0, len(params)+1)
+	newParams = append(newParams, tokenStr)
+	if len(params) > 0 {
+		if s, ok := params[0].(string); ok && strings.HasPrefix(s, "token:") {
+			params = params[1:]
+		}
+	}
+	return append(newParams, params...)
+}
+
+// Record Recorder记录下的一次调用
+type Record struct {
+	Request  RpcRequest
+	Response RpcResponse
+	Err      error
+	Start    time.Time
+	Elapsed  time.Duration
+}
+
+// Recorder 记录经过它的请求和响应 用于调试和回放
+type Recorder struct {
+	Limit   int // 最多保留多少条 0表示不限制
+	records []Record
+	lock    sync.Mutex
+}
+
+// NewRecorder limit为0表示不限制条数
+func NewRecorder(limit int) *Recorder {
+	return &Recorder{Limit: limit}
+}
+
+// Interceptor 返回记录用的拦截器
+func (self *Recorder) Interceptor() Interceptor {
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		self.lock.Lock()
+		self.records = append(self.records, Record{Request: req, Response: res, Err: err, Start: start, Elapsed: time.Since(start)})
+		if self.Limit > 0 && len(self.records) > self.Limit {
+			self.records = append(self.records[:0:0], self.records[len(self.records)-self.Limit:]...)
+		}
+		self.lock.Unlock()
+		return res, err
+	}
+}
+
+// Records 返回已记录内容的拷贝
+func (self *Recorder) Records() []Record {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]Record(nil), self.records...)
+}
+
+// Reset 清空记录
+func (self *Recorder) Reset() {
+	self.lock.Lock()
+	self.records = nil
+	self.lock.Unlock()
+}
@@ -0,0 +1,180 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// multicallHandler system.multicall逐个交给handle 其余方法直接交给handle
+func multicallHandler(handle func(method string, params []interface{}) (interface{}, *ErrorMsg)) func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	return func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		if method != "system.multicall" {
+			return handle(method, params)
+		}
+		results := make([]interface{}, 0)
+		for _, call := range params[0].([]interface{}) {
+			m := call.(map[string]interface{})
+			result, err := handle(m["methodName"].(string), stripToken(m["params"].([]interface{})))
+			if err != nil {
+				results = append(results, map[string]interface{}{"code": err.Code, "message": err.Message})
+			} else {
+				results = append(results, []interface{}{result})
+			}
+		}
+		return results, nil
+	}
+}
+
+func okHandler(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	switch method {
+	case "aria2.tellStatus":
+		if params[0] != "2089b05ecca3d829" {
+			return nil, &ErrorMsg{Code: 1, Message: "GID " + fmt.Sprint(params[0]) + " is not found"}
+		}
+		return map[string]interface{}{"gid": params[0], "status": "active"}, nil
+	case "aria2.pause", "aria2.unpause":
+		return params[0], nil
+	case "aria2.getGlobalStat":
+		return map[string]interface{}{"numActive": "1"}, nil
+	case "system.listMethods":
+		return []interface{}{"aria2.addUri"}, nil
+	}
+	return versionHandler(method, params)
+}
+
+func TestChainInterceptorsOrder(t *testing.T) {
+	fake := newFakeAria2(t, okHandler)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	var trace []string
+	var lock sync.Mutex
+	named := func(name string) Interceptor {
+		return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+			lock.Lock()
+			trace = append(trace, name+">")
+			lock.Unlock()
+			res, err := next(ctx, req)
+			lock.Lock()
+			trace = append(trace, "<"+name)
+			lock.Unlock()
+			return res, err
+		}
+	}
+	// 先添加的在外层 ChainInterceptors内部同样是第一个在外层
+	client.Use(named("a"), ChainInterceptors(named("b"), named("c")), named("d"))
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trace, " "); got != "a> b> c> d> <d <c <b <a" {
+		t.Fatalf("interceptor order: %s", got)
+	}
+
+	// 拦截器可以不调用next直接返回
+	trace = nil
+	shortCircuit := func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		return RpcResponse{Result: "cached"}, nil
+	}
+	res, err := ChainInterceptors(named("x"), shortCircuit, named("y"))(context.Background(), RpcRequest{Method: "aria2.getVersion"}, nil)
+	if err != nil || res.Result != "cached" || strings.Join(trace, " ") != "x> <x" {
+		t.Fatalf("short circuit: res=%v err=%v trace=%v", res, err, trace)
+	}
+}
+
+func TestTokenInterceptor(t *testing.T) {
+	fake := newFakeAria2(t, multicallHandler(okHandler))
+	old := "old"
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, &old, nil, NewNetHttpRequestHandler(nil))
+	current := "first"
+	client.Use(TokenInterceptor(func() string { return current }))
+
+	if _, err := client.TellStatus("2089b05ecca3d829", nil); err != nil {
+		t.Fatal(err)
+	}
+	current = "second"
+	if _, err := client.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListMethods(); err != nil {
+		t.Fatal(err)
+	}
+	// 已有的token被替换 listMethods保持原样
+	if got := fmt.Sprint(fake.Tokens()); got != "[first second old]" {
+		t.Fatalf("tokens sent: %q", fake.Tokens())
+	}
+	if got := fmt.Sprint(fake.Params()[0]); got != "[2089b05ecca3d829]" {
+		t.Fatalf("token not replaced in place: %v", got)
+	}
+
+	// batch模式Flush发出的system.multicall本身不带token 每个子调用的token都被替换
+	current = "third"
+	*client.Mode = "batch"
+	client.TellStatus("2089b05ecca3d829", nil)
+	client.Pause("2089b05ecca3d829")
+	client.GetGlobalStat()
+	*client.Mode = "normal"
+	responses, err := client.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 || responses[0].Error != nil || responses[1].Result != "2089b05ecca3d829" {
+		t.Fatalf("multicall responses: %+v", responses)
+	}
+	params := fake.Params()
+	if tokens := fake.Tokens(); tokens[len(tokens)-1] != "" {
+		t.Fatalf("multicall itself got token %q", tokens[len(tokens)-1])
+	}
+	calls := params[len(params)-1][0].([]interface{})
+	want := []string{"[token:third 2089b05ecca3d829]", "[token:third 2089b05ecca3d829]", "[token:third]"}
+	for i, call := range calls {
+		if got := fmt.Sprint(call.(map[string]interface{})["params"]); got != want[i] {
+			t.Errorf("multicall call %d params %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestRecorderLimit(t *testing.T) {
+	fake := newFakeAria2(t, okHandler)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	recorder := NewRecorder(2)
+	client.Use(recorder.Interceptor())
+
+	client.GetVersion()
+	client.GetGlobalStat()
+	if _, err := client.TellStatus("ffffffffffffffff", nil); err == nil {
+		t.Fatal("expected rpc error")
+	}
+	records := recorder.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	// 最早的getVersion被淘汰
+	if records[0].Request.Method != "aria2.getGlobalStat" || records[0].Err != nil || records[0].Response.Result == nil {
+		t.Errorf("first record: %+v", records[0])
+	}
+	if records[1].Request.Method != "aria2.tellStatus" || records[1].Err == nil {
+		t.Errorf("second record: %+v", records[1])
+	}
+	if records[1].Start.Before(records[0].Start) || records[1].Elapsed <= 0 {
+		t.Errorf("timing: %v %v", records[1].Start, records[1].Elapsed)
+	}
+	// Records返回拷贝
+	records[0].Request.Method = "changed"
+	if recorder.Records()[0].Request.Method != "aria2.getGlobalStat" {
+		t.Error("Records did not return a copy")
+	}
+
+	recorder.Reset()
+	if len(recorder.Records()) != 0 {
+		t.Fatal("Reset did not clear records")
+	}
+	// Limit为0时不限制
+	recorder.Limit = 0
+	for i := 0; i < 5; i++ {
+		client.GetVersion()
+	}
+	if len(recorder.Records()) != 5 {
+		t.Fatalf("unlimited recorder kept %d records", len(recorder.Records()))
+	}
+}
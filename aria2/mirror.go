@@ -0,0 +1,397 @@
+package aria2
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MirrorStats 一个uri的采样结果
+type MirrorStats struct {
+	Uri     string
+	Samples int   // 采样窗口内的次数
+	Average int64 // 采样窗口内的平均速度 字节/秒
+	Last    int64 // 最近一次采样的速度
+}
+
+// MirrorChange 一次changeUri调用
+type MirrorChange struct {
+	Gid     string
+	Index   int // 文件序号 从1开始
+	Removed []string
+	Added   []string
+	Err     error
+}
+
+type mirrorKey struct {
+	gid   string
+	index int
+}
+
+type mirrorTrack struct {
+	pool []string        // 还没用过的备用uri
+	used map[string]bool // 加过或者去掉过的uri 不会再从pool里加回来
+}
+
+// MirrorOptimizer 定时用getServers采样多镜像下载中每个uri的速度
+// 一直明显慢于最快镜像的uri用changeUri去掉 并从Track时给出的pool里补上同样数量的新uri
+// 只处理HTTP/FTP下载 pool用于下载的每个文件 通常用于单文件下载
+//
+//	optimizer := aria2.NewMirrorOptimizer(client)
+//	optimizer.Track(gid, "https://mirror3/file.iso", "https://mirror4/file.iso")
+//	go optimizer.Run(ctx)
+type MirrorOptimizer struct {
+	Window     int     // 每个uri保留的采样数 默认6
+	MinSamples int     // 最近MinSamples次采样都慢才算慢 默认3
+	SlowRatio  float64 // 低于最快镜像平均速度的SlowRatio倍算慢 默认0.25
+	MinSpeed   int64   // 低于这个速度(字节/秒)也算慢 0表示不使用
+	MinMirrors int     // 每个文件至少保留的uri数 默认1
+	// Restart 修改之后暂停再恢复下载 让aria2断开被去掉的uri的连接 默认true
+	Restart  bool
+	Interval time.Duration // Run的采样间隔 默认20秒
+	Clock    Clock         // 默认SystemClock
+	OnChange func(change MirrorChange)
+
+	client  *Aria2Client
+	lock    sync.Mutex
+	polling sync.Mutex // 同一时间只有一个Poll
+	tracks  map[string]*mirrorTrack
+	samples map[mirrorKey]map[string][]int64
+}
+
+// NewMirrorOptimizer 使用默认设置 需要用Track指定要优化的下载
+func NewMirrorOptimizer(client *Aria2Client) *MirrorOptimizer {
+	return &MirrorOptimizer{
+		Window:     6,
+		MinSamples: 3,
+		SlowRatio:  0.25,
+		MinMirrors: 1,
+		Restart:    true,
+		Interval:   20 * time.Second,
+		Clock:      SystemClock,
+		client:     client,
+		tracks:     make(map[string]*mirrorTrack),
+		samples:    make(map[mirrorKey]map[string][]int64),
+	}
+}
+
+// Track 开始优化gid pool是可以补充的备用uri 按顺序使用
+func (self *MirrorOptimizer) Track(gid string, pool ...string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	track, ok := self.tracks[gid]
+	if !ok {
+		track = &mirrorTrack{used: make(map[string]bool)}
+		self.tracks[gid] = track
+	}
+	track.pool = append(track.pool, pool...)
+}
+
+// Untrack 停止优化gid 并丢弃它的采样
+func (self *MirrorOptimizer) Untrack(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.untrack(gid)
+}
+
+func (self *MirrorOptimizer) untrack(gid string) {
+	delete(self.tracks, gid)
+	for key := range self.samples {
+		if key.gid == gid {
+			delete(self.samples, key)
+		}
+	}
+}
+
+// Rank 返回gid第index个文件各个uri的采样结果 按平均速度从快到慢
+func (self *MirrorOptimizer) Rank(gid string, index int) []MirrorStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.rank(mirrorKey{gid, index})
+}
+
+func (self *MirrorOptimizer) rank(key mirrorKey) []MirrorStats {
+	ranked := make([]MirrorStats, 0, len(self.samples[key]))
+	for uri, speeds := range self.samples[key] {
+		if len(speeds) == 0 {
+			continue
+		}
+		var sum int64
+		for _, speed := range speeds {
+			sum += speed
+		}
+		ranked = append(ranked, MirrorStats{
+			Uri:     uri,
+			Samples: len(speeds),
+			Average: sum / int64(len(speeds)),
+			Last:    speeds[len(speeds)-1],
+		})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Average != ranked[j].Average {
+			return ranked[i].Average > ranked[j].Average
+		}
+		return ranked[i].Uri < ranked[j].Uri
+	})
+	return ranked
+}
+
+// Poll 对每个跟踪的下载采样一次 并替换慢的uri 已经结束的下载会被自动Untrack
+// 只在记录采样和决定替换时持有lock changeUri和重启都在释放lock之后进行
+func (self *MirrorOptimizer) Poll(ctx context.Context) error {
+	self.polling.Lock()
+	defer self.polling.Unlock()
+	client := self.client.WithContext(ctx)
+	self.lock.Lock()
+	gids := make([]string, 0, len(self.tracks))
+	for gid := range self.tracks {
+		gids = append(gids, gid)
+	}
+	self.lock.Unlock()
+	sort.Strings(gids)
+	var firstErr error
+	for _, gid := range gids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := self.poll(client, gid); err != nil {
+			if status, serr := client.tellStatus(gid, &[]string{"gid", "status"}); serr == nil && status.Status.IsTerminal() {
+				self.Untrack(gid)
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (self *MirrorOptimizer) poll(client *Aria2Client, gid string) error {
+	files, err := client.getServers(gid)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	slow := self.sample(gid, files)
+	self.lock.Unlock()
+	if len(slow) == 0 {
+		return nil
+	}
+	current, err := self.fileUris(client, gid)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	changes := self.plan(gid, slow, current)
+	self.lock.Unlock()
+
+	var firstErr error
+	restart := false
+	for _, change := range changes {
+		_, change.Err = client.ChangeUri(gid, change.Index, change.Removed, change.Added, nil)
+		self.lock.Lock()
+		self.commit(change)
+		self.lock.Unlock()
+		if self.OnChange != nil {
+			self.OnChange(change)
+		}
+		if change.Err != nil {
+			if firstErr == nil {
+				firstErr = change.Err
+			}
+			continue
+		}
+		restart = restart || len(change.Removed) > 0
+	}
+	if restart && self.Restart {
+		if err := client.restart(gid); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sample 记录一次getServers的结果 返回每个文件的慢uri 调用时持有lock
+func (self *MirrorOptimizer) sample(gid string, files []FileServers) map[int][]string {
+	window := self.Window
+	if window <= 0 {
+		window = 6
+	}
+	present := make(map[int]bool, len(files))
+	for _, file := range files {
+		key := mirrorKey{gid, file.Index}
+		present[file.Index] = true
+		// 同一个uri可能有多个连接 速度相加
+		speeds := make(map[string]int64)
+		for _, server := range file.Servers {
+			speeds[server.Uri] += server.DownloadSpeed
+		}
+		samples, ok := self.samples[key]
+		if !ok {
+			samples = make(map[string][]int64)
+			self.samples[key] = samples
+		}
+		// 不在getServers里的uri(被去掉、连接断开或者aria2换了镜像)丢弃采样 重新出现时从头采样
+		for uri := range samples {
+			if _, ok := speeds[uri]; !ok {
+				delete(samples, uri)
+			}
+		}
+		for uri, speed := range speeds {
+			list := append(samples[uri], speed)
+			if len(list) > window {
+				list = list[len(list)-window:]
+			}
+			samples[uri] = list
+		}
+	}
+	for key := range self.samples {
+		if key.gid == gid && !present[key.index] {
+			delete(self.samples, key)
+		}
+	}
+	slow := make(map[int][]string)
+	for _, file := range files {
+		if uris := self.slowUris(mirrorKey{gid, file.Index}); len(uris) > 0 {
+			slow[file.Index] = uris
+		}
+	}
+	return slow
+}
+
+// plan 决定每个文件要去掉和补上的uri 补上的uri先从pool里取出 调用时持有lock
+func (self *MirrorOptimizer) plan(gid string, slow map[int][]string, current map[int]map[string]bool) []MirrorChange {
+	track, ok := self.tracks[gid]
+	if !ok {
+		return nil
+	}
+	minMirrors := self.MinMirrors
+	if minMirrors < 1 {
+		minMirrors = 1
+	}
+	indexes := make([]int, 0, len(slow))
+	for index := range slow {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	changes := make([]MirrorChange, 0, len(indexes))
+	for _, index := range indexes {
+		remove := slow[index]
+		added := make([]string, 0)
+		for len(added) < len(remove) && len(track.pool) > 0 {
+			uri := track.pool[0]
+			track.pool = track.pool[1:]
+			if !track.used[uri] && !current[index][uri] {
+				added = append(added, uri)
+			}
+		}
+		// 没有足够的替换时 去掉之后至少留下MinMirrors个
+		if keep := len(current[index]) - len(remove) + len(added); keep < minMirrors {
+			drop := len(remove) - (minMirrors - keep)
+			if drop < 0 {
+				drop = 0
+			}
+			remove = remove[:drop]
+		}
+		if len(remove) == 0 && len(added) == 0 {
+			continue
+		}
+		changes = append(changes, MirrorChange{Gid: gid, Index: index, Removed: remove, Added: added})
+	}
+	return changes
+}
+
+// commit 记录changeUri的结果 失败时把取出的uri放回pool 调用时持有lock
+func (self *MirrorOptimizer) commit(change MirrorChange) {
+	track, ok := self.tracks[change.Gid]
+	if !ok {
+		return
+	}
+	if change.Err != nil {
+		track.pool = append(append([]string(nil), change.Added...), track.pool...)
+		return
+	}
+	for _, uri := range change.Removed {
+		track.used[uri] = true
+		delete(self.samples[mirrorKey{change.Gid, change.Index}], uri)
+	}
+	for _, uri := range change.Added {
+		track.used[uri] = true
+	}
+}
+
+// slowUris 最近MinSamples次采样都低于阈值的uri 从最慢的开始 最快的uri不会被选中
+func (self *MirrorOptimizer) slowUris(key mirrorKey) []string {
+	ranked := self.rank(key)
+	if len(ranked) < 2 {
+		return nil
+	}
+	minSamples := self.MinSamples
+	if minSamples <= 0 {
+		minSamples = 3
+	}
+	ratio := self.SlowRatio
+	if ratio <= 0 {
+		ratio = 0.25
+	}
+	threshold := int64(float64(ranked[0].Average) * ratio)
+	if self.MinSpeed > threshold {
+		threshold = self.MinSpeed
+	}
+	slow := make([]string, 0)
+	for i := len(ranked) - 1; i > 0; i-- {
+		speeds := self.samples[key][ranked[i].Uri]
+		if len(speeds) < minSamples {
+			continue
+		}
+		consistent := true
+		for _, speed := range speeds[len(speeds)-minSamples:] {
+			if speed >= threshold {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			slow = append(slow, ranked[i].Uri)
+		}
+	}
+	return slow
+}
+
+// fileUris 每个文件当前的所有uri 包括还没有连接的
+func (self *MirrorOptimizer) fileUris(client *Aria2Client, gid string) (map[int]map[string]bool, error) {
+	files, err := client.getFiles(gid)
+	if err != nil {
+		return nil, err
+	}
+	uris := make(map[int]map[string]bool, len(files))
+	for _, file := range files {
+		uris[file.Index] = make(map[string]bool, len(file.Uris))
+		for _, uri := range file.Uris {
+			uris[file.Index][uri.Uri] = true
+		}
+	}
+	return uris, nil
+}
+
+// Run 每隔Interval调用一次Poll 直到ctx结束 Poll出错不会退出
+func (self *MirrorOptimizer) Run(ctx context.Context) error {
+	clock := self.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	interval := self.Interval
+	if interval <= 0 {
+		interval = 20 * time.Second
+	}
+	for {
+		self.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(interval):
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeMirrors 单文件多镜像的下载 servers是正在连接的uri和速度
+type fakeMirrors struct {
+	lock    sync.Mutex
+	gid     string
+	status  Status
+	uris    []string
+	servers map[string]int64
+}
+
+func (self *fakeMirrors) setSpeed(uri string, speed int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.servers[uri] = speed
+}
+
+func (self *fakeMirrors) disconnect(uri string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.servers, uri)
+}
+
+func (self *fakeMirrors) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	switch method {
+	case "aria2.getServers":
+		servers := make([]interface{}, 0, len(self.servers))
+		for uri, speed := range self.servers {
+			servers = append(servers, map[string]interface{}{"uri": uri, "currentUri": uri, "downloadSpeed": fmt.Sprint(speed)})
+		}
+		return []interface{}{map[string]interface{}{"index": "1", "servers": servers}}, nil
+	case "aria2.getFiles":
+		uris := make([]interface{}, 0, len(self.uris))
+		for _, uri := range self.uris {
+			uris = append(uris, map[string]interface{}{"uri": uri, "status": "used"})
+		}
+		return []interface{}{map[string]interface{}{"index": "1", "path": "/tmp/file.iso", "uris": uris}}, nil
+	case "aria2.changeUri":
+		removed, added := 0, 0
+		for _, uri := range params[2].([]interface{}) {
+			for i, u := range self.uris {
+				if u == uri {
+					self.uris = append(self.uris[:i], self.uris[i+1:]...)
+					delete(self.servers, u)
+					removed++
+					break
+				}
+			}
+		}
+		for _, uri := range params[3].([]interface{}) {
+			self.uris = append(self.uris, uri.(string))
+			added++
+		}
+		return []interface{}{removed, added}, nil
+	case "aria2.tellStatus":
+		return map[string]interface{}{"gid": self.gid, "status": string(self.status)}, nil
+	case "aria2.forcePause":
+		self.status = StatusPaused
+		return self.gid, nil
+	case "aria2.unpause":
+		self.status = StatusActive
+		return self.gid, nil
+	}
+	return versionHandler(method, params)
+}
+
+func TestMirrorOptimizerReplacesSlowUri(t *testing.T) {
+	download := &fakeMirrors{
+		gid:     "2089b05ecca3d829",
+		status:  StatusActive,
+		uris:    []string{"http://fast/f", "http://slow/f"},
+		servers: map[string]int64{"http://fast/f": 100000, "http://slow/f": 100},
+	}
+	fake := newFakeAria2(t, download.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	optimizer := NewMirrorOptimizer(client)
+	optimizer.Track(download.gid, "http://spare/f")
+	var changes []MirrorChange
+	optimizer.OnChange = func(change MirrorChange) {
+		// 回调里可以读取采样 Poll不能在调用changeUri时持有lock
+		optimizer.Rank(change.Gid, change.Index)
+		changes = append(changes, change)
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := optimizer.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(changes) != 1 || changes[0].Err != nil {
+		t.Fatalf("expected one change, got %+v", changes)
+	}
+	if fmt.Sprint(changes[0].Removed) != "[http://slow/f]" || fmt.Sprint(changes[0].Added) != "[http://spare/f]" {
+		t.Fatalf("unexpected change %+v", changes[0])
+	}
+	download.lock.Lock()
+	uris := append([]string(nil), download.uris...)
+	download.lock.Unlock()
+	sort.Strings(uris)
+	if fmt.Sprint(uris) != "[http://fast/f http://spare/f]" {
+		t.Fatalf("uris after change: %v", uris)
+	}
+	for _, stats := range optimizer.Rank(download.gid, 1) {
+		if stats.Uri == "http://slow/f" {
+			t.Fatal("samples of removed uri kept")
+		}
+	}
+}
+
+func TestMirrorOptimizerExpiresVanishedUris(t *testing.T) {
+	download := &fakeMirrors{
+		gid:     "2089b05ecca3d829",
+		status:  StatusActive,
+		uris:    []string{"http://a/f", "http://b/f"},
+		servers: map[string]int64{"http://a/f": 5000, "http://b/f": 4000},
+	}
+	fake := newFakeAria2(t, download.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	optimizer := NewMirrorOptimizer(client)
+	optimizer.Track(download.gid)
+	ctx := context.Background()
+	poll := func() {
+		t.Helper()
+		if err := optimizer.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samples := func(uri string) int {
+		for _, stats := range optimizer.Rank(download.gid, 1) {
+			if stats.Uri == uri {
+				return stats.Samples
+			}
+		}
+		return 0
+	}
+
+	poll()
+	poll()
+	if samples("http://b/f") != 2 {
+		t.Fatalf("expected 2 samples, got %d", samples("http://b/f"))
+	}
+	// b断开后它的旧采样不能留到重新连接时
+	download.disconnect("http://b/f")
+	poll()
+	if samples("http://b/f") != 0 {
+		t.Fatal("samples of disconnected uri kept")
+	}
+	download.setSpeed("http://b/f", 10)
+	poll()
+	if samples("http://b/f") != 1 || samples("http://a/f") != 4 {
+		t.Fatalf("unexpected samples a=%d b=%d", samples("http://a/f"), samples("http://b/f"))
+	}
+}
@@ -0,0 +1,385 @@
+// Package process 启动并看管一个本地的aria2c进程
+// 负责拼命令行、生成rpc密钥、选择空闲端口、等待rpc可用、转发日志、崩溃后按退避重启
+// 以及通过saveSession+shutdown优雅退出
+package process
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2"
+	"io"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotRunning Supervisor没有在运行
+var ErrNotRunning = errors.New("process: aria2c is not running")
+
+// ErrAlreadyRunning Start被调用了两次
+var ErrAlreadyRunning = errors.New("process: aria2c is already running")
+
+// errStopping 重启时发现Stop已经被调用
+var errStopping = errors.New("process: supervisor is stopping")
+
+// Options aria2c的常用参数 零值表示不传给aria2c
+type Options struct {
+	Dir                    string
+	RpcListenPort          int    // 0表示自动选择一个空闲端口
+	RpcSecret              string // 空表示随机生成
+	RpcListenAll           bool
+	RpcAllowOriginAll      bool
+	MaxConcurrentDownloads int
+	Continue               bool
+	InputFile              string
+	SaveSession            string
+	SaveSessionInterval    time.Duration
+	ConfPath               string // 空表示--no-conf 不读取~/.aria2/aria2.conf
+	LogLevel               string // console-log-level debug/info/notice/warn/error
+	// Extra 其余参数 key不带-- 例如 {"max-overall-download-limit": "2M"}
+	Extra map[string]string
+}
+
+// Args 生成aria2c的命令行参数 结果是确定的 便于比较和记录
+func (self Options) Args() []string {
+	args := []string{"--enable-rpc=true"}
+	add := func(key string, value string) {
+		args = append(args, "--"+key+"="+value)
+	}
+	if self.RpcListenPort > 0 {
+		add("rpc-listen-port", strconv.Itoa(self.RpcListenPort))
+	}
+	if self.RpcSecret != "" {
+		add("rpc-secret", self.RpcSecret)
+	}
+	if self.RpcListenAll {
+		add("rpc-listen-all", "true")
+	}
+	if self.RpcAllowOriginAll {
+		add("rpc-allow-origin-all", "true")
+	}
+	if self.Dir != "" {
+		add("dir", self.Dir)
+	}
+	if self.MaxConcurrentDownloads > 0 {
+		add("max-concurrent-downloads", strconv.Itoa(self.MaxConcurrentDownloads))
+	}
+	if self.Continue {
+		add("continue", "true")
+	}
+	if self.InputFile != "" {
+		add("input-file", self.InputFile)
+	}
+	if self.SaveSession != "" {
+		add("save-session", self.SaveSession)
+	}
+	if self.SaveSessionInterval > 0 {
+		add("save-session-interval", strconv.Itoa(int(self.SaveSessionInterval/time.Second)))
+	}
+	if self.ConfPath != "" {
+		add("conf-path", self.ConfPath)
+	} else {
+		add("no-conf", "true")
+	}
+	if self.LogLevel != "" {
+		add("console-log-level", self.LogLevel)
+	}
+	keys := make([]string, 0, len(self.Extra))
+	for key := range self.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(key, self.Extra[key])
+	}
+	return args
+}
+
+// Supervisor 管理一个aria2c进程
+type Supervisor struct {
+	// Executable aria2c的路径 测试时可以换成一个假的可执行文件
+	Executable string
+	Options    Options
+	Env        []string // nil表示继承当前环境变量
+	// Stdout Stderr aria2c的输出转发到这里 nil表示丢弃
+	Stdout io.Writer
+	Stderr io.Writer
+	// StartTimeout 等待getVersion成功的最长时间 0表示10s
+	StartTimeout time.Duration
+	// StopTimeout Stop时等待进程退出的最长时间 超时后强制结束 0表示10s
+	StopTimeout time.Duration
+	// AutoRestart 进程意外退出后是否重启 MaxRestarts为0表示不限次数
+	AutoRestart    bool
+	MaxRestarts    int
+	RestartBackoff aria2.Backoff
+	// OnExit 进程意外退出时调用
+	OnExit func(err error)
+	// OnRestart 重启成功后调用 attempt从1开始
+	OnRestart func(attempt int)
+
+	client   *aria2.Aria2Client
+	cmd      *exec.Cmd
+	exited   chan struct{} // 当前进程退出时关闭
+	done     chan struct{} // Supervisor停止工作时关闭
+	stopping bool
+	lock     sync.Mutex
+}
+
+// NewSupervisor executable为空时使用PATH里的aria2c
+func NewSupervisor(executable string, options Options) *Supervisor {
+	if executable == "" {
+		executable = "aria2c"
+	}
+	return &Supervisor{Executable: executable, Options: options}
+}
+
+// Url rpc地址 Start之后可用
+func (self *Supervisor) Url() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/jsonrpc", self.Options.RpcListenPort)
+}
+
+// Secret rpc密钥 Start之后可用
+func (self *Supervisor) Secret() string {
+	return self.Options.RpcSecret
+}
+
+// Client 连接到这个aria2c的客户端 Start之后可用
+func (self *Supervisor) Client() *aria2.Aria2Client {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.client
+}
+
+// Done Supervisor停止工作(Stop或者不再重启)时关闭
+func (self *Supervisor) Done() <-chan struct{} {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.done
+}
+
+// Start 启动aria2c并等待rpc可用
+func (self *Supervisor) Start(ctx context.Context) error {
+	self.lock.Lock()
+	if self.cmd != nil {
+		self.lock.Unlock()
+		return ErrAlreadyRunning
+	}
+	if self.Options.RpcListenPort == 0 {
+		port, err := freePort()
+		if err != nil {
+			self.lock.Unlock()
+			return err
+		}
+		self.Options.RpcListenPort = port
+	}
+	if self.Options.RpcSecret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			self.lock.Unlock()
+			return err
+		}
+		self.Options.RpcSecret = secret
+	}
+	secret := self.Options.RpcSecret
+	self.client = aria2.NewAria2Client(self.Url(), nil, nil, &secret, nil, aria2.NewNetHttpRequestHandler(nil))
+	self.stopping = false
+	self.done = make(chan struct{})
+	self.lock.Unlock()
+
+	if err := self.launch(ctx); err != nil {
+		self.lock.Lock()
+		self.cmd = nil
+		close(self.done)
+		self.lock.Unlock()
+		return err
+	}
+	go self.supervise()
+	return nil
+}
+
+// launch 启动一个aria2c进程并等待getVersion成功
+func (self *Supervisor) launch(ctx context.Context) error {
+	cmd := exec.Command(self.Executable, self.Options.Args()...)
+	cmd.Env = self.Env
+	cmd.Stdout = self.Stdout
+	cmd.Stderr = self.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+	self.lock.Lock()
+	// Stop可能在supervise检查stopping之后、进程启动之前被调用 这时Stop看不到这个进程 只能在这里结束它
+	if self.stopping {
+		self.lock.Unlock()
+		cmd.Process.Kill()
+		<-exited
+		return errStopping
+	}
+	self.cmd = cmd
+	self.exited = exited
+	self.lock.Unlock()
+	if err := self.waitReady(ctx, exited); err != nil {
+		cmd.Process.Kill()
+		<-exited
+		if waitErr != nil {
+			return fmt.Errorf("%w (aria2c: %v)", err, waitErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (self *Supervisor) waitReady(ctx context.Context, exited <-chan struct{}) error {
+	timeout := self.StartTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client := self.Client().WithContext(ctx)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := client.GetVersion(); err == nil {
+			return nil
+		}
+		select {
+		case <-exited:
+			return errors.New("process: aria2c exited before rpc became ready")
+		case <-ctx.Done():
+			return fmt.Errorf("process: waiting for aria2c rpc: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// supervise 等待进程退出 意外退出时按照策略重启
+func (self *Supervisor) supervise() {
+	backoff := self.RestartBackoff
+	if backoff == (aria2.Backoff{}) {
+		backoff = aria2.DefaultBackoff
+	}
+	restarts := 0
+	for {
+		self.lock.Lock()
+		cmd, exited := self.cmd, self.exited
+		self.lock.Unlock()
+		<-exited
+		self.lock.Lock()
+		stopping := self.stopping
+		self.lock.Unlock()
+		if stopping {
+			break
+		}
+		if self.OnExit != nil {
+			self.OnExit(exitError(cmd))
+		}
+		if !self.AutoRestart {
+			break
+		}
+		restarted := false
+		for !restarted {
+			restarts++
+			if self.MaxRestarts > 0 && restarts > self.MaxRestarts {
+				break
+			}
+			time.Sleep(backoff.Delay(restarts))
+			self.lock.Lock()
+			stopping = self.stopping
+			self.lock.Unlock()
+			if stopping {
+				break
+			}
+			err := self.launch(context.Background())
+			if err == errStopping {
+				break
+			}
+			if err == nil {
+				restarted = true
+				if self.OnRestart != nil {
+					self.OnRestart(restarts)
+				}
+			}
+		}
+		if !restarted {
+			break
+		}
+	}
+	self.lock.Lock()
+	self.cmd = nil
+	close(self.done)
+	self.lock.Unlock()
+}
+
+func exitError(cmd *exec.Cmd) error {
+	if cmd.ProcessState == nil || cmd.ProcessState.Success() {
+		return nil
+	}
+	return fmt.Errorf("process: aria2c exited: %s", cmd.ProcessState)
+}
+
+// Stop 先saveSession(设置了SaveSession时) 再shutdown 等待进程退出
+// ctx结束或者超过StopTimeout还没退出时依次尝试forceShutdown和kill
+func (self *Supervisor) Stop(ctx context.Context) error {
+	self.lock.Lock()
+	if self.cmd == nil {
+		self.lock.Unlock()
+		return ErrNotRunning
+	}
+	self.stopping = true
+	cmd, exited, done, client := self.cmd, self.exited, self.done, self.client
+	self.lock.Unlock()
+
+	timeout := self.StopTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client = client.WithContext(ctx)
+	var err error
+	if self.Options.SaveSession != "" {
+		if _, saveErr := client.SaveSession(); saveErr != nil {
+			err = fmt.Errorf("process: save session: %w", saveErr)
+		}
+	}
+	if _, shutdownErr := client.Shutdown(); shutdownErr != nil {
+		client.ForceShutdown()
+	}
+	select {
+	case <-exited:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-exited
+	}
+	<-done
+	return err
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,186 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/synodriver/goaria2/aria2"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 设置了这个环境变量时测试二进制本身充当aria2c
+const fakeAria2Env = "GOARIA2_FAKE_ARIA2C"
+
+// 这个文件存在时假的aria2c在rpc可用后删除它并崩溃 用来模拟一次意外退出
+const fakeCrashEnv = "GOARIA2_FAKE_CRASH_FILE"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeAria2Env) == "1" {
+		os.Exit(fakeAria2c(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeAria2c 只实现getVersion saveSession shutdown forceShutdown
+func fakeAria2c(args []string) int {
+	options := make(map[string]string)
+	for _, arg := range args {
+		if kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2); len(kv) == 2 {
+			options[kv[0]] = kv[1]
+		}
+	}
+	crash := false
+	if path := os.Getenv(fakeCrashEnv); path != "" {
+		if err := os.Remove(path); err == nil {
+			crash = true
+		}
+	}
+	exit := make(chan int, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jsonrpc", func(w http.ResponseWriter, r *http.Request) {
+		req := aria2.RpcRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := aria2.RpcResponse{ID: req.Id.(string), Jsonrpc: "2.0"}
+		if len(req.Params) == 0 || req.Params[0] != "token:"+options["rpc-secret"] {
+			res.Error = map[string]interface{}{"code": 1, "message": "Unauthorized"}
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		switch req.Method {
+		case "aria2.getVersion":
+			res.Result = map[string]interface{}{"version": "1.36.0", "enabledFeatures": []string{}}
+			if crash {
+				time.AfterFunc(200*time.Millisecond, func() { exit <- 1 })
+			}
+		case "aria2.saveSession":
+			res.Result = "OK"
+		case "aria2.shutdown", "aria2.forceShutdown":
+			res.Result = "OK"
+			time.AfterFunc(50*time.Millisecond, func() { exit <- 0 })
+		default:
+			res.Error = map[string]interface{}{"code": 1, "message": "method not found"}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	go http.ListenAndServe("127.0.0.1:"+options["rpc-listen-port"], mux)
+	return <-exit
+}
+
+func newFakeSupervisor(t *testing.T) (*Supervisor, string) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashFile := t.TempDir() + "/crash"
+	supervisor := NewSupervisor(executable, Options{})
+	supervisor.Env = append(os.Environ(), fakeAria2Env+"=1", fakeCrashEnv+"="+crashFile)
+	supervisor.StartTimeout = 5 * time.Second
+	supervisor.StopTimeout = 5 * time.Second
+	return supervisor, crashFile
+}
+
+func TestSupervisorStartStop(t *testing.T) {
+	supervisor, _ := newFakeSupervisor(t)
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := supervisor.Client().GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if err := supervisor.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-supervisor.Done():
+	default:
+		t.Fatal("Done not closed after Stop")
+	}
+	if err := supervisor.Stop(context.Background()); err != ErrNotRunning {
+		t.Fatalf("expected ErrNotRunning, got %v", err)
+	}
+}
+
+func TestSupervisorRestartAfterCrash(t *testing.T) {
+	supervisor, crashFile := newFakeSupervisor(t)
+	if err := os.WriteFile(crashFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	supervisor.AutoRestart = true
+	supervisor.RestartBackoff = aria2.Backoff{Initial: 50 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 1}
+	var exits int32
+	restarted := make(chan int, 1)
+	supervisor.OnExit = func(err error) {
+		atomic.AddInt32(&exits, 1)
+		if err == nil {
+			t.Error("expected exit error for crash")
+		}
+	}
+	supervisor.OnRestart = func(attempt int) { restarted <- attempt }
+
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case attempt := <-restarted:
+		if attempt != 1 {
+			t.Fatalf("expected first restart, got attempt %d", attempt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("aria2c was not restarted")
+	}
+	if _, err := supervisor.Client().GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- supervisor.Stop(context.Background()) }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop hung after restart")
+	}
+	if n := atomic.LoadInt32(&exits); n != 1 {
+		t.Fatalf("expected 1 unexpected exit, got %d", n)
+	}
+}
+
+func TestSupervisorStopDuringRestart(t *testing.T) {
+	supervisor, crashFile := newFakeSupervisor(t)
+	if err := os.WriteFile(crashFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	supervisor.AutoRestart = true
+	supervisor.RestartBackoff = aria2.Backoff{Initial: 300 * time.Millisecond, Max: 300 * time.Millisecond, Multiplier: 1}
+	crashed := make(chan struct{})
+	supervisor.OnExit = func(err error) { close(crashed) }
+	supervisor.OnRestart = func(attempt int) { t.Error("restarted after Stop") }
+
+	if err := supervisor.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-crashed
+	// 进程已经退出 Stop的shutdown会失败 但仍然要等到supervise放弃重启
+	stopped := make(chan error, 1)
+	go func() { stopped <- supervisor.Stop(context.Background()) }()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop hung while a restart was pending")
+	}
+	select {
+	case <-supervisor.Done():
+	default:
+		t.Fatal("Done not closed after Stop")
+	}
+}
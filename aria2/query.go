@@ -0,0 +1,298 @@
+package aria2
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SortField Query结果的排序字段
+type SortField string
+
+const (
+	SortByGid           SortField = "gid"
+	SortByName          SortField = "name"
+	SortBySize          SortField = "size"
+	SortByCompleted     SortField = "completed"
+	SortByProgress      SortField = "progress"
+	SortByDownloadSpeed SortField = "downloadSpeed"
+	SortByUploadSpeed   SortField = "uploadSpeed"
+)
+
+// 每个排序字段需要向aria2请求的keys
+var sortKeys = map[SortField][]string{
+	SortByGid:           nil,
+	SortByName:          {"files", "bittorrent"},
+	SortBySize:          {"totalLength"},
+	SortByCompleted:     {"completedLength"},
+	SortByProgress:      {"completedLength", "totalLength"},
+	SortByDownloadSpeed: {"downloadSpeed"},
+	SortByUploadSpeed:   {"uploadSpeed"},
+}
+
+func (self SortField) less(a *DownloadStatus, b *DownloadStatus) bool {
+	switch self {
+	case SortByName:
+		return a.Name() < b.Name()
+	case SortBySize:
+		return a.TotalLength < b.TotalLength
+	case SortByCompleted:
+		return a.CompletedLength < b.CompletedLength
+	case SortByProgress:
+		return a.Progress() < b.Progress()
+	case SortByDownloadSpeed:
+		return a.DownloadSpeed < b.DownloadSpeed
+	case SortByUploadSpeed:
+		return a.UploadSpeed < b.UploadSpeed
+	}
+	return a.Gid < b.Gid
+}
+
+// Query 在所有下载(活动、等待、已停止)中按条件查找
+//
+//	statuses, err := client.Query().Status(aria2.StatusPaused).Dir("/data/iso").MinSize(1 << 30).Run(ctx)
+//
+// 只向aria2请求过滤和排序用到的keys 用了Where又没有指定Keys时请求全部字段
+// 不支持按添加或完成时间过滤: tellStatus不返回这类时间(bittorrent.creationDate是种子的制作时间)
+// 需要时可以在OnDownloadStart/OnDownloadComplete回调里自己记录时间 再用Where按GID过滤
+type Query struct {
+	client     *Aria2Client
+	statuses   []Status
+	dir        string
+	minSize    int64
+	maxSize    int64
+	pattern    string
+	errorCodes []ErrorCode
+	where      []func(DownloadStatus) bool
+	keys       []string
+	sortBy     SortField
+	desc       bool
+	limit      int
+	pageSize   int
+}
+
+// Query 创建查询 不加条件时返回所有下载
+func (self *Aria2Client) Query() *Query {
+	return &Query{client: self, minSize: -1, maxSize: -1}
+}
+
+// Status 只返回这些状态的下载 只会请求包含这些状态的列表
+func (self *Query) Status(statuses ...Status) *Query {
+	self.statuses = append(self.statuses, statuses...)
+	return self
+}
+
+// Dir 下载目录是prefix或者在prefix之下
+func (self *Query) Dir(prefix string) *Query {
+	self.dir = prefix
+	return self
+}
+
+// MinSize 总大小不小于n字节
+func (self *Query) MinSize(n int64) *Query {
+	self.minSize = n
+	return self
+}
+
+// MaxSize 总大小不大于n字节
+func (self *Query) MaxSize(n int64) *Query {
+	self.maxSize = n
+	return self
+}
+
+// NameMatches 名字匹配glob 语法同path.Match 名字的取法见DownloadStatus.Name
+func (self *Query) NameMatches(glob string) *Query {
+	self.pattern = glob
+	return self
+}
+
+// ErrorCode 以这些错误码结束的下载 通常和Status(StatusError)一起使用
+func (self *Query) ErrorCode(codes ...ErrorCode) *Query {
+	self.errorCodes = append(self.errorCodes, codes...)
+	return self
+}
+
+// Where 自定义条件 可以多次调用 全部满足才返回
+func (self *Query) Where(fn func(DownloadStatus) bool) *Query {
+	self.where = append(self.where, fn)
+	return self
+}
+
+// Keys 结果中额外需要的字段
+func (self *Query) Keys(keys ...string) *Query {
+	self.keys = append(self.keys, keys...)
+	return self
+}
+
+// SortBy 结果排序 默认按aria2返回的顺序 即活动、等待、已停止
+func (self *Query) SortBy(field SortField, desc bool) *Query {
+	self.sortBy = field
+	self.desc = desc
+	return self
+}
+
+// Limit 最多返回n个结果 在排序之后截断
+func (self *Query) Limit(n int) *Query {
+	self.limit = n
+	return self
+}
+
+// PageSize 遍历等待队列和已停止列表时每页的条数
+func (self *Query) PageSize(n int) *Query {
+	self.pageSize = n
+	return self
+}
+
+// requestKeys 过滤和排序需要的keys 返回nil表示请求全部字段
+func (self *Query) requestKeys() *[]string {
+	if len(self.where) > 0 && len(self.keys) == 0 {
+		return nil
+	}
+	keys := []string{"gid", "status"}
+	keys = append(keys, self.keys...)
+	if self.dir != "" {
+		keys = append(keys, "dir")
+	}
+	if self.minSize >= 0 || self.maxSize >= 0 {
+		keys = append(keys, "totalLength")
+	}
+	if self.pattern != "" {
+		keys = append(keys, "files", "bittorrent")
+	}
+	if len(self.errorCodes) > 0 {
+		keys = append(keys, "errorCode")
+	}
+	keys = append(keys, sortKeys[self.sortBy]...)
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	return &unique
+}
+
+func (self *Query) wants(check func(Status) bool) bool {
+	if len(self.statuses) == 0 {
+		return true
+	}
+	for _, status := range self.statuses {
+		if check(status) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Query) match(status *DownloadStatus) bool {
+	if len(self.statuses) > 0 {
+		ok := false
+		for _, s := range self.statuses {
+			if status.Status == s {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if self.dir != "" {
+		prefix := strings.TrimRight(self.dir, "/")
+		if status.Dir != self.dir && status.Dir != prefix && !strings.HasPrefix(status.Dir, prefix+"/") {
+			return false
+		}
+	}
+	if self.minSize >= 0 && status.TotalLength < self.minSize {
+		return false
+	}
+	if self.maxSize >= 0 && status.TotalLength > self.maxSize {
+		return false
+	}
+	if self.pattern != "" {
+		if ok, _ := path.Match(self.pattern, status.Name()); !ok {
+			return false
+		}
+	}
+	if len(self.errorCodes) > 0 {
+		ok := false
+		for _, code := range self.errorCodes {
+			if status.ErrorCode == code {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, fn := range self.where {
+		if !fn(*status) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run 执行查询 下载在两次请求之间从一个列表移动到另一个列表时只保留第一次看到的状态
+func (self *Query) Run(ctx context.Context) ([]DownloadStatus, error) {
+	if self.pattern != "" {
+		if _, err := path.Match(self.pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	client := self.client.WithContext(ctx)
+	keys := self.requestKeys()
+	results := make([]DownloadStatus, 0)
+	seen := make(map[string]bool)
+	collect := func(status DownloadStatus) {
+		if seen[status.Gid] {
+			return
+		}
+		seen[status.Gid] = true
+		if self.match(&status) {
+			results = append(results, status)
+		}
+	}
+
+	if self.wants(func(s Status) bool { return s == StatusActive }) {
+		active, err := client.tellActive(keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range active {
+			collect(status)
+		}
+	}
+	iterators := make([]*StatusIterator, 0, 2)
+	if self.wants(Status.IsQueued) {
+		iterators = append(iterators, client.IterWaiting(self.pageSize, keys))
+	}
+	if self.wants(Status.IsTerminal) {
+		iterators = append(iterators, client.IterStopped(self.pageSize, keys))
+	}
+	for _, it := range iterators {
+		for it.Next() {
+			collect(it.Status())
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if self.sortBy != "" {
+		sort.SliceStable(results, func(i, j int) bool {
+			if self.desc {
+				return self.sortBy.less(&results[j], &results[i])
+			}
+			return self.sortBy.less(&results[i], &results[j])
+		})
+	}
+	if self.limit > 0 && len(results) > self.limit {
+		results = results[:self.limit]
+	}
+	return results, nil
+}
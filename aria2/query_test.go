@@ -0,0 +1,158 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQueryMatch(t *testing.T) {
+	iso := DownloadStatus{Gid: "1", Status: StatusPaused, Dir: "/data/iso", TotalLength: 1 << 30,
+		Files: []FileStatus{{Path: "/data/iso/ubuntu.iso"}}}
+	nested := DownloadStatus{Gid: "2", Status: StatusWaiting, Dir: "/data/iso/old", TotalLength: 100,
+		Files: []FileStatus{{Path: "C:\\data\\old\\debian.iso"}}}
+	sibling := DownloadStatus{Gid: "3", Status: StatusActive, Dir: "/data/isos", TotalLength: 0,
+		Bittorrent: &BittorrentInfo{Info: struct {
+			Name string `json:"name"`
+		}{Name: "album"}}}
+	failed := DownloadStatus{Gid: "4", Status: StatusError, Dir: "/data", ErrorCode: CodeNetworkProblem,
+		Files: []FileStatus{{Uris: []UriStatus{{Uri: "http://a.example/x.zip"}}}}}
+	all := []DownloadStatus{iso, nested, sibling, failed}
+
+	client := &Aria2Client{}
+	cases := []struct {
+		name  string
+		query *Query
+		want  string
+	}{
+		{"no conditions", client.Query(), "1 2 3 4"},
+		{"status", client.Query().Status(StatusPaused, StatusActive), "1 3"},
+		// 前缀按目录匹配 /data/isos不在/data/iso之下
+		{"dir", client.Query().Dir("/data/iso"), "1 2"},
+		{"dir trailing slash", client.Query().Dir("/data/iso/"), "1 2"},
+		{"min size inclusive", client.Query().MinSize(100), "1 2"},
+		{"max size inclusive", client.Query().MaxSize(100), "2 3 4"},
+		{"size range", client.Query().MinSize(1).MaxSize(1 << 30), "1 2"},
+		// 种子用info.name 否则用文件名 windows路径也能取到文件名
+		{"name glob", client.Query().NameMatches("*.iso"), "1 2"},
+		{"torrent name", client.Query().NameMatches("alb?m"), "3"},
+		{"uri name", client.Query().NameMatches("http://a.example/*.zip"), "4"},
+		{"error code", client.Query().ErrorCode(CodeTimeout, CodeNetworkProblem), "4"},
+		{"error code mismatch", client.Query().Status(StatusError).ErrorCode(CodeTimeout), ""},
+		{"where", client.Query().Where(func(s DownloadStatus) bool { return s.Gid != "1" }).Dir("/data/iso"), "2"},
+		{"all where", client.Query().
+			Where(func(s DownloadStatus) bool { return s.Gid > "1" }).
+			Where(func(s DownloadStatus) bool { return s.Gid < "4" }), "2 3"},
+	}
+	for _, c := range cases {
+		gids := make([]string, 0)
+		for i := range all {
+			if c.query.match(&all[i]) {
+				gids = append(gids, all[i].Gid)
+			}
+		}
+		if got := strings.Join(gids, " "); got != c.want {
+			t.Errorf("%s: matched %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestQueryRequestKeys(t *testing.T) {
+	client := &Aria2Client{}
+	cases := []struct {
+		query *Query
+		want  string
+	}{
+		{client.Query(), "[gid status]"},
+		{client.Query().Dir("/data").MinSize(1).SortBy(SortBySize, false), "[gid status dir totalLength]"},
+		{client.Query().NameMatches("*").ErrorCode(CodeTimeout).Keys("files"), "[gid status files bittorrent errorCode]"},
+		{client.Query().SortBy(SortByProgress, true), "[gid status completedLength totalLength]"},
+		// Where需要完整的状态 没有指定Keys时请求全部字段
+		{client.Query().Where(func(DownloadStatus) bool { return true }), "<nil>"},
+		{client.Query().Where(func(DownloadStatus) bool { return true }).Keys("dir"), "[gid status dir]"},
+	}
+	for i, c := range cases {
+		keys := c.query.requestKeys()
+		got := "<nil>"
+		if keys != nil {
+			got = fmt.Sprint(*keys)
+		}
+		if got != c.want {
+			t.Errorf("case %d: keys %s, want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestQueryRun(t *testing.T) {
+	status := func(gid string, s Status, size int) map[string]interface{} {
+		return map[string]interface{}{"gid": gid, "status": string(s), "totalLength": fmt.Sprint(size)}
+	}
+	page := func(list []interface{}, params []interface{}) []interface{} {
+		offset, num := int(params[0].(float64)), int(params[1].(float64))
+		if offset >= len(list) {
+			return []interface{}{}
+		}
+		if offset+num > len(list) {
+			num = len(list) - offset
+		}
+		return list[offset : offset+num]
+	}
+	active := []interface{}{status("a1", StatusActive, 300)}
+	waiting := []interface{}{status("w1", StatusWaiting, 100), status("w2", StatusPaused, 500), status("w3", StatusWaiting, 200)}
+	// a1在两次请求之间结束 在已停止列表中又出现一次
+	stopped := []interface{}{status("a1", StatusComplete, 300), status("s1", StatusError, 400)}
+	fake := newFakeAria2(t, func(method string, params []interface{}) (interface{}, *ErrorMsg) {
+		switch method {
+		case "aria2.tellActive":
+			return active, nil
+		case "aria2.tellWaiting":
+			return page(waiting, params), nil
+		case "aria2.tellStopped":
+			return page(stopped, params), nil
+		}
+		return versionHandler(method, params)
+	})
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	gids := func(statuses []DownloadStatus) string {
+		list := make([]string, 0, len(statuses))
+		for _, s := range statuses {
+			list = append(list, fmt.Sprintf("%s:%s", s.Gid, s.Status))
+		}
+		return strings.Join(list, " ")
+	}
+
+	statuses, err := client.Query().PageSize(2).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只保留第一次看到的a1
+	if got := gids(statuses); got != "a1:active w1:waiting w2:paused w3:waiting s1:error" {
+		t.Fatalf("all: %s", got)
+	}
+
+	statuses, err = client.Query().MinSize(200).SortBy(SortBySize, true).Limit(3).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gids(statuses); got != "w2:paused s1:error a1:active" {
+		t.Fatalf("sorted: %s", got)
+	}
+
+	// 只要暂停的下载时只请求等待队列
+	calls := len(fake.Calls())
+	statuses, err = client.Query().Status(StatusPaused).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gids(statuses); got != "w2:paused" {
+		t.Fatalf("paused: %s", got)
+	}
+	if got := fake.Calls()[calls:]; fmt.Sprint(got) != "[aria2.tellWaiting]" {
+		t.Fatalf("calls %v", got)
+	}
+
+	if _, err := client.Query().NameMatches("[").Run(context.Background()); err == nil {
+		t.Fatal("bad pattern accepted")
+	}
+}
@@ -0,0 +1,387 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// QuotaSource 流量的统计方式
+type QuotaSource int
+
+const (
+	// QuotaPerDownload 累加每个下载completedLength(以及uploadLength)的增量 比较准确 但每次要请求所有下载
+	QuotaPerDownload QuotaSource = iota
+	// QuotaGlobalStat 用getGlobalStat的速度乘以时间估算 开销小但只是近似值
+	QuotaGlobalStat
+)
+
+// QuotaAction 用完流量后的处理方式
+type QuotaAction int
+
+const (
+	// QuotaPause 用forcePauseAll暂停所有下载 新周期开始时unpauseAll 之前手动暂停的下载也会被恢复
+	QuotaPause QuotaAction = iota
+	// QuotaThrottle 把全局限速改成ThrottleDownload/ThrottleUpload 新周期开始时恢复原来的值
+	QuotaThrottle
+)
+
+const (
+	optionDownloadLimit = "max-overall-download-limit"
+	optionUploadLimit   = "max-overall-upload-limit"
+)
+
+// quotaState QuotaManager持久化的内容
+type quotaState struct {
+	Day        string            `json:"day"`
+	DayBytes   int64             `json:"dayBytes"`
+	Month      string            `json:"month"`
+	MonthBytes int64             `json:"monthBytes"`
+	Last       map[string]int64  `json:"last"`
+	LastPoll   time.Time         `json:"lastPoll"`
+	Enforced   bool              `json:"enforced"`
+	Saved      map[string]string `json:"saved,omitempty"` // 限速前的全局选项
+}
+
+// QuotaManager 按天和按月统计流量 超过限额后暂停或限速 进入新的周期后自动恢复
+type QuotaManager struct {
+	DailyLimit   int64 // 每天的字节数 0表示不限
+	MonthlyLimit int64 // 每月的字节数 0表示不限
+	Source       QuotaSource
+	CountUpload  bool // 上传流量也计入限额
+	Action       QuotaAction
+	// ThrottleDownload ThrottleUpload Action为QuotaThrottle时使用的限速 例如"50K"
+	ThrottleDownload string
+	ThrottleUpload   string
+	Location         *time.Location // 划分天和月使用的时区 默认time.Local
+	Clock            Clock          // 默认SystemClock
+	Interval         time.Duration  // Run的统计间隔 默认10秒
+	// StatePath 保存计数的文件 为空时不持久化
+	StatePath string
+	// OnExceeded 限额用完时调用 period是day或month
+	OnExceeded func(period string, used int64, limit int64)
+	// OnResume 新周期开始并解除限制后调用
+	OnResume func()
+
+	client  *Aria2Client
+	state   quotaState
+	lock    sync.Mutex
+	polling sync.Mutex // 同一时间只有一个Poll
+}
+
+// NewQuotaManager statePath非空且文件存在时读取之前的计数
+func NewQuotaManager(client *Aria2Client, dailyLimit int64, monthlyLimit int64, statePath string) (*QuotaManager, error) {
+	manager := &QuotaManager{
+		DailyLimit:   dailyLimit,
+		MonthlyLimit: monthlyLimit,
+		Location:     time.Local,
+		Clock:        SystemClock,
+		Interval:     10 * time.Second,
+		StatePath:    statePath,
+		client:       client,
+	}
+	if statePath != "" {
+		if _, err := readJsonFile(statePath, &manager.state); err != nil {
+			return nil, err
+		}
+	}
+	return manager, nil
+}
+
+// Usage 当天和当月已经使用的字节数
+func (self *QuotaManager) Usage() (day int64, month int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.state.DayBytes, self.state.MonthBytes
+}
+
+// Enforced 当前是否因为超额处于暂停或限速状态
+func (self *QuotaManager) Enforced() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.state.Enforced
+}
+
+func (self *QuotaManager) now() time.Time {
+	clock := self.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	loc := self.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return clock.Now().In(loc)
+}
+
+// quotaDecision Poll在持有lock时做出的决定 在释放lock之后执行
+type quotaDecision int
+
+const (
+	quotaNothing quotaDecision = iota
+	quotaEnforce
+	quotaReassert
+	quotaRelease
+)
+
+// Poll 统计一次流量 必要时切换周期、执行或解除限制 然后保存状态
+// 只在更新计数时持有lock rpc和OnExceeded/OnResume都在释放lock之后进行 回调里可以调用Usage和Enforced
+func (self *QuotaManager) Poll(ctx context.Context) error {
+	self.polling.Lock()
+	defer self.polling.Unlock()
+	client := self.client.WithContext(ctx)
+	now := self.now()
+
+	var stat GlobalStat
+	var statuses []DownloadStatus
+	var err error
+	if self.Source == QuotaGlobalStat {
+		stat, err = client.globalStat()
+	} else {
+		statuses, err = self.statuses(client)
+	}
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	// 切换周期
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	if self.state.Day != day {
+		self.state.Day, self.state.DayBytes = day, 0
+	}
+	if self.state.Month != month {
+		self.state.Month, self.state.MonthBytes = month, 0
+	}
+	var delta int64
+	if self.Source == QuotaGlobalStat {
+		delta = self.sampleStat(stat, now)
+	} else {
+		delta = self.sample(statuses)
+	}
+	self.state.DayBytes += delta
+	self.state.MonthBytes += delta
+	self.state.LastPoll = now
+
+	period, used, limit := self.exceeded()
+	decision := quotaNothing
+	switch {
+	case period != "" && !self.state.Enforced:
+		decision = quotaEnforce
+	case period != "" && self.state.Enforced:
+		decision = quotaReassert
+	case period == "" && self.state.Enforced:
+		decision = quotaRelease
+	}
+	saved := make(map[string]string, len(self.state.Saved))
+	for k, v := range self.state.Saved {
+		saved[k] = v
+	}
+	self.lock.Unlock()
+
+	switch decision {
+	case quotaEnforce:
+		saved, err := self.enforce(client)
+		if err != nil {
+			return err
+		}
+		self.lock.Lock()
+		self.state.Enforced = true
+		self.state.Saved = saved
+		self.lock.Unlock()
+	case quotaReassert:
+		if err := self.reassert(client); err != nil {
+			return err
+		}
+	case quotaRelease:
+		if err := self.release(client, saved); err != nil {
+			return err
+		}
+		self.lock.Lock()
+		self.state.Enforced = false
+		self.state.Saved = nil
+		self.lock.Unlock()
+	}
+	if err := self.save(); err != nil {
+		return err
+	}
+	if decision == quotaEnforce && self.OnExceeded != nil {
+		self.OnExceeded(period, used, limit)
+	}
+	if decision == quotaRelease && self.OnResume != nil {
+		self.OnResume()
+	}
+	return nil
+}
+
+func (self *QuotaManager) exceeded() (string, int64, int64) {
+	if self.DailyLimit > 0 && self.state.DayBytes >= self.DailyLimit {
+		return "day", self.state.DayBytes, self.DailyLimit
+	}
+	if self.MonthlyLimit > 0 && self.state.MonthBytes >= self.MonthlyLimit {
+		return "month", self.state.MonthBytes, self.MonthlyLimit
+	}
+	return "", 0, 0
+}
+
+// sampleStat 用全局速度估算上次统计以来的字节数 调用时持有lock
+func (self *QuotaManager) sampleStat(stat GlobalStat, now time.Time) int64 {
+	if self.state.LastPoll.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(self.state.LastPoll)
+	// 进程挂起或长时间没有统计时不按当前速度外推整段时间
+	if interval := self.interval(); elapsed > 2*interval {
+		elapsed = 2 * interval
+	}
+	speed := stat.DownloadSpeed
+	if self.CountUpload {
+		speed += stat.UploadSpeed
+	}
+	return int64(float64(speed) * elapsed.Seconds())
+}
+
+// statuses 所有下载的completedLength和uploadLength
+func (self *QuotaManager) statuses(client *Aria2Client) ([]DownloadStatus, error) {
+	keys := &[]string{"gid", "completedLength", "uploadLength"}
+	statuses, err := client.tellActive(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range []*StatusIterator{client.IterWaiting(0, keys), client.IterStopped(0, keys)} {
+		rest, err := it.All()
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, rest...)
+	}
+	return statuses, nil
+}
+
+// sample 返回上次统计以来每个下载增长的字节数之和 调用时持有lock
+func (self *QuotaManager) sample(statuses []DownloadStatus) int64 {
+	last := make(map[string]int64, len(statuses))
+	var delta int64
+	for _, status := range statuses {
+		bytes := status.CompletedLength
+		if self.CountUpload {
+			bytes += status.UploadLength
+		}
+		last[status.Gid] = bytes
+		previous, ok := self.state.Last[status.Gid]
+		// 第一次看到的下载只记录基准 它的completedLength可能来自session恢复、continue=true续传
+		// 或者AddSeed的校验 并不是这段时间的流量 aria2重启后变小的也重新记录基准
+		// 代价是新下载在第一次统计之前的流量不计入
+		if ok && bytes > previous {
+			delta += bytes - previous
+		}
+	}
+	self.state.Last = last
+	return delta
+}
+
+func (self *QuotaManager) interval() time.Duration {
+	if self.Interval > 0 {
+		return self.Interval
+	}
+	return 10 * time.Second
+}
+
+// enforce 暂停或限速 返回限速前的全局选项
+func (self *QuotaManager) enforce(client *Aria2Client) (map[string]string, error) {
+	if self.Action == QuotaPause {
+		_, err := client.ForcePauseAll()
+		return nil, err
+	}
+	res, err := client.GetGlobalOption()
+	if err != nil {
+		return nil, err
+	}
+	live, _ := res.(map[string]interface{})
+	saved := make(map[string]string)
+	for _, key := range []string{optionDownloadLimit, optionUploadLimit} {
+		if v, ok := live[key]; ok {
+			saved[key] = fmt.Sprint(v)
+		}
+	}
+	return saved, self.throttle(client, live)
+}
+
+// reassert 超额期间新加入或被手动恢复的下载也要暂停 aria2重启或者限速被别人改掉之后重新限速
+func (self *QuotaManager) reassert(client *Aria2Client) error {
+	if self.Action == QuotaPause {
+		if stat, err := client.globalStat(); err == nil && stat.NumActive > 0 {
+			if _, err := client.ForcePauseAll(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	res, err := client.GetGlobalOption()
+	if err != nil {
+		return err
+	}
+	live, _ := res.(map[string]interface{})
+	return self.throttle(client, live)
+}
+
+// throttle 把和ThrottleDownload/ThrottleUpload不一致的全局限速改掉 live是aria2当前的全局选项
+func (self *QuotaManager) throttle(client *Aria2Client, live map[string]interface{}) error {
+	options := make(map[string]interface{})
+	for key, value := range map[string]string{optionDownloadLimit: self.ThrottleDownload, optionUploadLimit: self.ThrottleUpload} {
+		if value == "" {
+			continue
+		}
+		if current, ok := live[key]; ok && sameOption(fmt.Sprint(current), value) {
+			continue
+		}
+		options[key] = value
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	_, err := client.ChangeGlobalOption(options)
+	return err
+}
+
+func (self *QuotaManager) release(client *Aria2Client, saved map[string]string) error {
+	if self.Action == QuotaPause {
+		_, err := client.UnpauseAll()
+		return err
+	}
+	options := make(map[string]interface{})
+	for k, v := range saved {
+		options[k] = v
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	_, err := client.ChangeGlobalOption(options)
+	return err
+}
+
+func (self *QuotaManager) save() error {
+	if self.StatePath == "" {
+		return nil
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return writeJsonFile(self.StatePath, self.state)
+}
+
+// Run 每隔Interval调用一次Poll 直到ctx结束 Poll出错不会退出
+func (self *QuotaManager) Run(ctx context.Context) error {
+	clock := self.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	for {
+		self.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(self.interval()):
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package aria2
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQuotaAria2 所有下载都在活动列表里 completedLength由测试设置
+type fakeQuotaAria2 struct {
+	*fakeGlobalOptions
+	lock      sync.Mutex
+	completed map[string]int64
+}
+
+func newFakeQuotaAria2() *fakeQuotaAria2 {
+	return &fakeQuotaAria2{
+		fakeGlobalOptions: &fakeGlobalOptions{options: map[string]string{optionDownloadLimit: "0", optionUploadLimit: "0"}},
+		completed:         make(map[string]int64),
+	}
+}
+
+func (self *fakeQuotaAria2) setCompleted(gid string, n int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.completed[gid] = n
+}
+
+func (self *fakeQuotaAria2) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	switch method {
+	case "aria2.tellActive":
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		statuses := make([]interface{}, 0, len(self.completed))
+		for gid, n := range self.completed {
+			statuses = append(statuses, map[string]interface{}{"gid": gid, "completedLength": strconv.FormatInt(n, 10), "uploadLength": "0"})
+		}
+		return statuses, nil
+	case "aria2.tellWaiting", "aria2.tellStopped":
+		return []interface{}{}, nil
+	}
+	return self.fakeGlobalOptions.handle(method, params)
+}
+
+func newTestQuotaManager(t *testing.T, fake *fakeQuotaAria2, clock *ManualClock) *QuotaManager {
+	server := newFakeAria2(t, fake.handle)
+	client := NewAria2Client(server.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	manager, err := NewQuotaManager(client, 0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Location = time.UTC
+	manager.Clock = clock
+	return manager
+}
+
+func TestQuotaBaselineForNewDownloads(t *testing.T) {
+	fake := newFakeQuotaAria2()
+	clock := NewManualClock(friday(12, 0, time.UTC))
+	manager := newTestQuotaManager(t, fake, clock)
+	ctx := context.Background()
+	poll := func(want int64) {
+		t.Helper()
+		clock.Advance(10 * time.Second)
+		if err := manager.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if day, _ := manager.Usage(); day != want {
+			t.Fatalf("usage = %d, want %d", day, want)
+		}
+	}
+
+	// 从session恢复的下载 已有的completedLength不算流量
+	fake.setCompleted("a", 1000)
+	poll(0)
+	fake.setCompleted("a", 1500)
+	poll(500)
+	// 统计开始之后才出现的下载(例如AddSeed校验完成) 同样只记录基准
+	fake.setCompleted("b", 5000)
+	poll(500)
+	fake.setCompleted("b", 5200)
+	poll(700)
+	// aria2重启后completedLength变小 重新记录基准
+	fake.setCompleted("a", 100)
+	poll(700)
+	fake.setCompleted("a", 300)
+	poll(900)
+}
+
+func TestQuotaThrottleReasserted(t *testing.T) {
+	fake := newFakeQuotaAria2()
+	clock := NewManualClock(friday(12, 0, time.UTC))
+	manager := newTestQuotaManager(t, fake, clock)
+	manager.DailyLimit = 1000
+	manager.Action = QuotaThrottle
+	manager.ThrottleDownload = "50K"
+	ctx := context.Background()
+
+	fake.setCompleted("a", 0)
+	if err := manager.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.setCompleted("a", 2000)
+	clock.Advance(10 * time.Second)
+	if err := manager.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !manager.Enforced() || fake.get(optionDownloadLimit) != "51200" {
+		t.Fatalf("throttle not applied: enforced=%v limit=%s", manager.Enforced(), fake.get(optionDownloadLimit))
+	}
+
+	// 模拟aria2重启 全局限速回到启动参数
+	fake.set(optionDownloadLimit, "0")
+	clock.Advance(10 * time.Second)
+	if err := manager.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fake.get(optionDownloadLimit) != "51200" {
+		t.Fatalf("throttle not re-asserted, limit=%s", fake.get(optionDownloadLimit))
+	}
+	changes := fake.changeCount()
+	clock.Advance(10 * time.Second)
+	if err := manager.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fake.changeCount() != changes {
+		t.Fatal("throttle re-sent although aria2 already has it")
+	}
+
+	// 第二天解除限速 恢复原来的值
+	clock.Set(friday(24, 0, time.UTC))
+	if err := manager.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if manager.Enforced() || fake.get(optionDownloadLimit) != "0" {
+		t.Fatalf("throttle not released: enforced=%v limit=%s", manager.Enforced(), fake.get(optionDownloadLimit))
+	}
+}
+
+func TestQuotaCallbacksCanReadState(t *testing.T) {
+	fake := newFakeQuotaAria2()
+	clock := NewManualClock(friday(12, 0, time.UTC))
+	manager := newTestQuotaManager(t, fake, clock)
+	manager.DailyLimit = 1000
+	manager.Action = QuotaThrottle
+	manager.ThrottleDownload = "50K"
+	var exceededUsage int64
+	var exceededEnforced, resumed bool
+	// 回调在释放lock之后调用 读取Usage和Enforced不会死锁
+	manager.OnExceeded = func(period string, used int64, limit int64) {
+		exceededUsage, _ = manager.Usage()
+		exceededEnforced = manager.Enforced()
+	}
+	manager.OnResume = func() {
+		resumed = !manager.Enforced()
+	}
+	ctx := context.Background()
+	poll := func() {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- manager.Poll(ctx) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Poll deadlocked")
+		}
+	}
+
+	fake.setCompleted("a", 0)
+	poll()
+	fake.setCompleted("a", 1500)
+	clock.Advance(10 * time.Second)
+	poll()
+	if exceededUsage != 1500 || !exceededEnforced {
+		t.Fatalf("OnExceeded saw usage=%d enforced=%v", exceededUsage, exceededEnforced)
+	}
+	clock.Set(friday(24, 0, time.UTC))
+	poll()
+	if !resumed {
+		t.Fatal("OnResume did not see the released state")
+	}
+}
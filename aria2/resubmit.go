@@ -0,0 +1,120 @@
+package aria2
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+// 等待forceRemove/forcePause生效的最长时间和轮询间隔
+const (
+	settleTimeout  = 10 * time.Second
+	settleInterval = 500 * time.Millisecond
+)
+
+// sourceUris 重新添加下载时使用的uri 种子用info-hash构造磁力链接 否则取第一个文件的uri
+func sourceUris(status DownloadStatus) []string {
+	if status.InfoHash != "" {
+		link := "magnet:?xt=urn:btih:" + status.InfoHash
+		if status.Bittorrent != nil {
+			for _, tier := range status.Bittorrent.AnnounceList {
+				for _, tracker := range tier {
+					link += "&tr=" + url.QueryEscape(tracker)
+				}
+			}
+		}
+		return []string{link}
+	}
+	seen := make(map[string]bool)
+	uris := make([]string, 0)
+	if len(status.Files) > 0 {
+		for _, uri := range status.Files[0].Uris {
+			if !seen[uri.Uri] {
+				seen[uri.Uri] = true
+				uris = append(uris, uri.Uri)
+			}
+		}
+	}
+	return uris
+}
+
+// waitStatus 轮询直到下载的状态满足done 或者超时
+func (self *Aria2Client) waitStatus(gid string, done func(Status) bool) error {
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		status, err := self.tellStatus(gid, &[]string{"gid", "status"})
+		if err != nil {
+			return err
+		}
+		if done(status.Status) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		if err := sleepContext(self.Context(), settleInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// restart 暂停后立即恢复 让aria2断开现有连接重新连接服务器或peer
+func (self *Aria2Client) restart(gid string) error {
+	if _, err := self.ForcePause(gid); err != nil {
+		return err
+	}
+	if err := self.waitStatus(gid, func(s Status) bool { return s == StatusPaused || s.IsTerminal() }); err != nil {
+		return err
+	}
+	_, err := self.Unpause(gid)
+	return err
+}
+
+// resubmit 删除gid表示的下载 用uris和原来的选项重新添加 返回新的GID
+// uris为nil时使用原来的uri 种子下载会变成同一个info-hash的磁力链接 extra中的选项覆盖原来的选项
+func (self *Aria2Client) resubmit(gid string, uris []string, extra map[string]interface{}) (string, error) {
+	status, err := self.tellStatus(gid, &[]string{"gid", "status", "files", "infoHash", "bittorrent"})
+	if err != nil {
+		return "", err
+	}
+	if uris == nil {
+		uris = sourceUris(status)
+	}
+	if len(uris) == 0 {
+		return "", errors.New("aria2: no uri to resubmit " + gid)
+	}
+	res, err := self.GetOption(gid)
+	if err != nil {
+		return "", err
+	}
+	options := make(map[string]interface{})
+	if m, ok := res.(map[string]interface{}); ok {
+		for k, v := range m {
+			options[k] = v
+		}
+	}
+	delete(options, "gid")
+	for k, v := range extra {
+		options[k] = v
+	}
+
+	if !status.Status.IsTerminal() {
+		if _, err := self.ForceRemove(gid); err != nil {
+			return "", err
+		}
+		// 旧的下载还没停下来时添加同一个文件会报DUPLICATE_DOWNLOAD
+		if err := self.waitStatus(gid, Status.IsTerminal); err != nil {
+			return "", err
+		}
+	}
+	self.RemoveDownloadResult(gid)
+	res, err = self.AddUri(uris, &options, nil)
+	if err != nil {
+		return "", err
+	}
+	newGid, ok := res.(string)
+	if !ok {
+		return "", errors.New("aria2: unexpected addUri result")
+	}
+	return newGid, nil
+}
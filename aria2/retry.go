@@ -0,0 +1,256 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Backoff 指数退避 第n次重试等待 Initial*Multiplier^(n-1) 不超过Max
+// Jitter是随机抖动的比例 0.2表示在±20%之间浮动
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultBackoff 200ms起 每次翻倍 最多10s
+var DefaultBackoff = Backoff{Initial: 200 * time.Millisecond, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.2}
+
+// Delay 返回第attempt次重试前的等待时间 attempt从1开始
+func (self Backoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := self.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(self.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if self.Max > 0 && delay > float64(self.Max) {
+		delay = float64(self.Max)
+	}
+	if self.Jitter > 0 {
+		delay += delay * self.Jitter * (rand.Float64()*2 - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// sleepContext 等待d 或者ctx结束
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 重复调用不会产生额外副作用的方法
+var idempotentMethods = map[string]bool{
+	"aria2.tellStatus":          true,
+	"aria2.getUris":             true,
+	"aria2.getFiles":            true,
+	"aria2.getPeers":            true,
+	"aria2.getServers":          true,
+	"aria2.tellActive":          true,
+	"aria2.tellWaiting":         true,
+	"aria2.tellStopped":         true,
+	"aria2.getOption":           true,
+	"aria2.changeOption":        true,
+	"aria2.getGlobalOption":     true,
+	"aria2.changeGlobalOption":  true,
+	"aria2.getGlobalStat":       true,
+	"aria2.purgeDownloadResult": true,
+	"aria2.getVersion":          true,
+	"aria2.getSessionInfo":      true,
+	"aria2.saveSession":         true,
+	"system.listMethods":        true,
+	"system.listNotifications":  true,
+}
+
+// IsIdempotent 判断一个请求能否安全地重发
+// 查询类方法总是安全的 addUri/addTorrent/addMetalink只有在options里指定了gid时才安全
+// (重复提交会因为gid冲突而失败 不会多出一个下载) changePosition只有POS_SET/POS_END安全
+// system.multicall要求每个子调用都安全
+func IsIdempotent(req RpcRequest) bool {
+	return isIdempotentCall(req.Method, req.Params)
+}
+
+func isIdempotentCall(method string, params []interface{}) bool {
+	if idempotentMethods[method] {
+		return true
+	}
+	params = stripToken(params)
+	switch method {
+	case "aria2.addUri", "aria2.addTorrent", "aria2.addMetalink":
+		for _, param := range params {
+			if options, ok := param.(map[string]interface{}); ok {
+				gid, _ := options["gid"].(string)
+				return gid != ""
+			}
+		}
+		return false
+	case "aria2.changePosition":
+		if len(params) == 3 {
+			how, _ := params[2].(string)
+			return how == "POS_SET" || how == "POS_END"
+		}
+		return false
+	case "system.multicall":
+		if len(params) == 0 {
+			return false
+		}
+		calls, ok := params[0].([]interface{})
+		if !ok {
+			return false
+		}
+		for _, call := range calls {
+			m, ok := call.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			name, _ := m["methodName"].(string)
+			callParams, _ := m["params"].([]interface{})
+			if !isIdempotentCall(name, callParams) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func stripToken(params []interface{}) []interface{} {
+	if len(params) > 0 {
+		if s, ok := params[0].(string); ok && strings.HasPrefix(s, "token:") {
+			return params[1:]
+		}
+	}
+	return params
+}
+
+// IsTransient 判断错误是否是暂时性的 连接被拒绝或重置、aria2正在重启、websocket断线、超时等
+// aria2返回的rpc错误(*ErrorMsg)和ctx被取消都不算
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rpcErr *ErrorMsg
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == 429
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrNotConnected) || errors.Is(err, ErrConnectionLost) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return true
+	}
+	// 只有超时算暂时性的 http.Client把所有错误都包装成*url.Error(它实现了net.Error)
+	// 证书错误、不支持的scheme、错误的url和域名不存在重试也不会成功
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// fasthttp的部分错误没有实现net.Error
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection closed")
+}
+
+// RetryEvent 传给重试钩子的信息
+type RetryEvent struct {
+	Request RpcRequest
+	Attempt int           // 刚刚失败的是第几次尝试 从1开始
+	Err     error         // 这次尝试的错误
+	Delay   time.Duration // 下次重试前的等待时间 放弃时为0
+	Reason  string        // 放弃时的原因 "not idempotent" "not retryable" "max attempts" "context done"
+}
+
+// RetryPolicy 重试策略
+type RetryPolicy struct {
+	MaxAttempts int     // 包括第一次在内的最多尝试次数 小于等于1表示不重试
+	Backoff     Backoff // 零值时使用DefaultBackoff
+	// Idempotent 判断请求能否重发 nil时使用IsIdempotent
+	Idempotent func(req RpcRequest) bool
+	// Retryable 判断错误是否值得重试 nil时使用IsTransient
+	Retryable func(err error) bool
+	// OnRetry 每次决定重试时调用
+	OnRetry func(event RetryEvent)
+	// OnGiveUp 出错但不再重试时调用
+	OnGiveUp func(event RetryEvent)
+}
+
+// RetryInterceptor 按照policy重试失败的调用
+func RetryInterceptor(policy RetryPolicy) Interceptor {
+	if policy.Backoff == (Backoff{}) {
+		policy.Backoff = DefaultBackoff
+	}
+	if policy.Idempotent == nil {
+		policy.Idempotent = IsIdempotent
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsTransient
+	}
+	return func(ctx context.Context, req RpcRequest, next Invoker) (RpcResponse, error) {
+		for attempt := 1; ; attempt++ {
+			res, err := next(ctx, req)
+			if err == nil {
+				return res, nil
+			}
+			event := RetryEvent{Request: req, Attempt: attempt, Err: err}
+			switch {
+			case !policy.Retryable(err):
+				event.Reason = "not retryable"
+			case !policy.Idempotent(req):
+				event.Reason = "not idempotent"
+			case attempt >= policy.MaxAttempts:
+				event.Reason = "max attempts"
+			}
+			if event.Reason == "" {
+				event.Delay = policy.Backoff.Delay(attempt)
+				if policy.OnRetry != nil {
+					policy.OnRetry(event)
+				}
+				if sleepContext(ctx, event.Delay) == nil {
+					continue
+				}
+				event.Delay = 0
+				event.Reason = "context done"
+			}
+			if policy.OnGiveUp != nil {
+				policy.OnGiveUp(event)
+			}
+			return res, err
+		}
+	}
+}
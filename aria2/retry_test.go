@@ -0,0 +1,180 @@
+package aria2
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError 实现net.Error的超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	post := func(err error) error {
+		return &url.Error{Op: "Post", URL: "http://127.0.0.1:6800/jsonrpc", Err: err}
+	}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"timeout", ErrTimeout, true},
+		{"not connected", ErrNotConnected, true},
+		{"connection lost", fmt.Errorf("call: %w", ErrConnectionLost), true},
+		{"unexpected eof", post(io.ErrUnexpectedEOF), true},
+		{"connection refused", post(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"connection reset", post(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), true},
+		{"broken pipe", post(&net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}), true},
+		{"net timeout", post(timeoutError{}), true},
+		{"http 503", &HttpStatusError{StatusCode: 503}, true},
+		{"http 429", &HttpStatusError{StatusCode: 429}, true},
+		{"http 401", &HttpStatusError{StatusCode: 401}, false},
+		{"rpc error", &ErrorMsg{Code: 1, Message: "GID not found"}, false},
+		{"canceled", post(context.Canceled), false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"x509", post(x509.UnknownAuthorityError{}), false},
+		{"nxdomain", post(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "aria2.invalid", IsNotFound: true}}), false},
+		{"bad url", &url.Error{Op: "parse", URL: "http://[::1", Err: errors.New("missing ']' in host")}, false},
+	}
+	for _, c := range cases {
+		if got := IsTransient(c.err); got != c.want {
+			t.Errorf("%s: IsTransient(%v) = %v, want %v", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+func TestIsTransientRealClientErrors(t *testing.T) {
+	// 不支持的scheme
+	_, err := http.Post("ftp://127.0.0.1/jsonrpc", "application/json", strings.NewReader("{}"))
+	if err == nil || IsTransient(err) {
+		t.Errorf("unsupported scheme classified as transient: %v", err)
+	}
+	// 连接被拒绝
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	_, err = http.Post("http://"+addr+"/jsonrpc", "application/json", strings.NewReader("{}"))
+	if err == nil || !IsTransient(err) {
+		t.Errorf("connection refused not classified as transient: %v", err)
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	call := func(method string, params ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"methodName": method, "params": params}
+	}
+	cases := []struct {
+		name   string
+		method string
+		params []interface{}
+		want   bool
+	}{
+		{"tellStatus", "aria2.tellStatus", []interface{}{"token:x", "2089b05ecca3d829"}, true},
+		{"remove", "aria2.remove", []interface{}{"2089b05ecca3d829"}, false},
+		{"addUri without gid", "aria2.addUri", []interface{}{[]interface{}{"http://a/f"}, map[string]interface{}{"dir": "/tmp"}}, false},
+		{"addUri without options", "aria2.addUri", []interface{}{[]interface{}{"http://a/f"}}, false},
+		{"addUri with gid", "aria2.addUri", []interface{}{"token:x", []interface{}{"http://a/f"}, map[string]interface{}{"gid": "2089b05ecca3d829"}}, true},
+		{"changePosition POS_SET", "aria2.changePosition", []interface{}{"2089b05ecca3d829", 0, "POS_SET"}, true},
+		{"changePosition POS_END", "aria2.changePosition", []interface{}{"token:x", "2089b05ecca3d829", 0, "POS_END"}, true},
+		{"changePosition POS_CUR", "aria2.changePosition", []interface{}{"2089b05ecca3d829", -1, "POS_CUR"}, false},
+		{"multicall of queries", "system.multicall", []interface{}{[]interface{}{
+			call("aria2.tellStatus", "token:x", "2089b05ecca3d829"),
+			call("aria2.getVersion", "token:x"),
+		}}, true},
+		{"multicall with pause", "system.multicall", []interface{}{[]interface{}{
+			call("aria2.tellStatus", "token:x", "2089b05ecca3d829"),
+			call("aria2.pause", "token:x", "2089b05ecca3d829"),
+		}}, false},
+		{"empty multicall", "system.multicall", nil, false},
+	}
+	for _, c := range cases {
+		if got := IsIdempotent(RpcRequest{Method: c.method, Params: c.params}); got != c.want {
+			t.Errorf("%s: IsIdempotent = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRetryInterceptorReasons(t *testing.T) {
+	query := RpcRequest{Method: "aria2.tellStatus", Params: []interface{}{"2089b05ecca3d829"}}
+	pause := RpcRequest{Method: "aria2.pause", Params: []interface{}{"2089b05ecca3d829"}}
+	cases := []struct {
+		name     string
+		req      RpcRequest
+		err      error
+		failures int           // 前几次调用失败 之后成功
+		timeout  time.Duration // 不为0时ctx在这之后结束
+		retries  int
+		reason   string
+	}{
+		{name: "recovers", req: query, err: ErrConnectionLost, failures: 2, retries: 2},
+		{name: "max attempts", req: query, err: ErrConnectionLost, failures: 10, retries: 2, reason: "max attempts"},
+		{name: "not retryable", req: query, err: &ErrorMsg{Code: 1}, failures: 10, reason: "not retryable"},
+		{name: "not idempotent", req: pause, err: ErrConnectionLost, failures: 10, reason: "not idempotent"},
+		{name: "context done", req: query, err: ErrConnectionLost, failures: 10, retries: 1, reason: "context done", timeout: 50 * time.Millisecond},
+	}
+	for _, c := range cases {
+		var retries []RetryEvent
+		var gaveUp *RetryEvent
+		backoff := Backoff{Initial: time.Millisecond, Multiplier: 1}
+		if c.timeout > 0 {
+			backoff = Backoff{Initial: time.Hour}
+		}
+		interceptor := RetryInterceptor(RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     backoff,
+			OnRetry:     func(event RetryEvent) { retries = append(retries, event) },
+			OnGiveUp:    func(event RetryEvent) { gaveUp = &event },
+		})
+		calls := 0
+		next := func(ctx context.Context, req RpcRequest) (RpcResponse, error) {
+			calls++
+			if calls <= c.failures {
+				return RpcResponse{}, c.err
+			}
+			return RpcResponse{Result: "OK"}, nil
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if c.timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+		}
+		_, err := interceptor(ctx, c.req, next)
+		cancel()
+		if len(retries) != c.retries {
+			t.Errorf("%s: OnRetry called %d times, want %d", c.name, len(retries), c.retries)
+		}
+		for i, event := range retries {
+			if event.Attempt != i+1 || event.Delay <= 0 || event.Reason != "" {
+				t.Errorf("%s: unexpected retry event %+v", c.name, event)
+			}
+		}
+		if c.reason == "" {
+			if err != nil || gaveUp != nil {
+				t.Errorf("%s: err=%v gaveUp=%+v", c.name, err, gaveUp)
+			}
+			continue
+		}
+		if gaveUp == nil || gaveUp.Reason != c.reason || gaveUp.Delay != 0 || gaveUp.Err != c.err {
+			t.Errorf("%s: OnGiveUp = %+v, want reason %q", c.name, gaveUp, c.reason)
+		}
+		if err != c.err {
+			t.Errorf("%s: returned %v, want %v", c.name, err, c.err)
+		}
+	}
+}
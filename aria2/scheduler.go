@@ -0,0 +1,331 @@
+package aria2
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job 交给Scheduler排序的一个下载
+type Job struct {
+	Gid      string
+	Category string
+	Priority int       // 越大越优先 ByPriority使用
+	Deadline time.Time // 零值表示没有期限 DeadlineFirst使用
+	Added    time.Time // Track时为零值则设置为当前时间
+	Size     int64     // 总大小 每次Apply时用tellWaiting的totalLength更新 0表示未知
+}
+
+// QueueStrategy 决定等待队列的顺序 Less为true时a排在b前面
+type QueueStrategy interface {
+	Less(a *Job, b *Job) bool
+}
+
+// QueueStrategyFunc 函数形式的QueueStrategy
+type QueueStrategyFunc func(a *Job, b *Job) bool
+
+func (self QueueStrategyFunc) Less(a *Job, b *Job) bool {
+	return self(a, b)
+}
+
+func fifo(a *Job, b *Job) bool {
+	return a.Added.Before(b.Added)
+}
+
+// SmallestFirst 总大小小的先下载 大小未知的排在最后
+func SmallestFirst() QueueStrategy {
+	return QueueStrategyFunc(func(a *Job, b *Job) bool {
+		if a.Size != b.Size {
+			if a.Size == 0 || b.Size == 0 {
+				return b.Size == 0
+			}
+			return a.Size < b.Size
+		}
+		return fifo(a, b)
+	})
+}
+
+// FIFOPerCategory 按categories给出的顺序排分类 同一分类内先加入的先下载 没有列出的分类排在最后
+func FIFOPerCategory(categories ...string) QueueStrategy {
+	rank := make(map[string]int, len(categories))
+	for i, category := range categories {
+		rank[category] = i
+	}
+	rankOf := func(category string) int {
+		if r, ok := rank[category]; ok {
+			return r
+		}
+		return len(categories)
+	}
+	return QueueStrategyFunc(func(a *Job, b *Job) bool {
+		if ra, rb := rankOf(a.Category), rankOf(b.Category); ra != rb {
+			return ra < rb
+		}
+		return fifo(a, b)
+	})
+}
+
+// DeadlineFirst 期限早的先下载 没有期限的排在最后
+func DeadlineFirst() QueueStrategy {
+	return QueueStrategyFunc(func(a *Job, b *Job) bool {
+		if !a.Deadline.Equal(b.Deadline) {
+			if a.Deadline.IsZero() || b.Deadline.IsZero() {
+				return b.Deadline.IsZero()
+			}
+			return a.Deadline.Before(b.Deadline)
+		}
+		return fifo(a, b)
+	})
+}
+
+// ByPriority Priority大的先下载
+func ByPriority() QueueStrategy {
+	return QueueStrategyFunc(func(a *Job, b *Job) bool {
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return fifo(a, b)
+	})
+}
+
+// Scheduler 让aria2等待队列中被跟踪的下载按Strategy排列
+// 只在被跟踪的下载当前占据的位置之间重新排列 其余下载的位置不变
+// 通过最长递增子序列找出不需要移动的下载 changePosition的调用次数最少
+type Scheduler struct {
+	Strategy QueueStrategy
+	// OnMove 每次调用changePosition之后调用 pos是移动到的位置
+	OnMove func(gid string, pos int)
+	// OnApply 每次Apply结束后调用 moves是移动的次数
+	OnApply func(moves int, err error)
+
+	client   *Aria2Client
+	jobs     map[string]*Job
+	lock     sync.Mutex
+	applying sync.Mutex
+	attached bool
+}
+
+// NewScheduler strategy为nil时使用ByPriority
+func NewScheduler(client *Aria2Client, strategy QueueStrategy) *Scheduler {
+	if strategy == nil {
+		strategy = ByPriority()
+	}
+	return &Scheduler{Strategy: strategy, client: client, jobs: make(map[string]*Job)}
+}
+
+// Track 跟踪一个下载 已经跟踪的会被覆盖
+func (self *Scheduler) Track(job Job) {
+	if job.Added.IsZero() {
+		job.Added = time.Now()
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.jobs[job.Gid] = &job
+}
+
+// Untrack 不再跟踪
+func (self *Scheduler) Untrack(gid string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.jobs, gid)
+}
+
+// Job 返回跟踪中的下载
+func (self *Scheduler) Job(gid string) (Job, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if job, ok := self.jobs[gid]; ok {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// Jobs 所有跟踪中的下载
+func (self *Scheduler) Jobs() []Job {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	jobs := make([]Job, 0, len(self.jobs))
+	for _, job := range self.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// AddUri 添加下载并按job跟踪 job.Gid会被设置成aria2返回的GID 随后重新排列队列
+func (self *Scheduler) AddUri(ctx context.Context, uris []string, options *map[string]interface{}, job Job) (string, error) {
+	res, err := self.client.WithContext(ctx).AddUri(uris, options, nil)
+	if err != nil {
+		return "", err
+	}
+	gid, _ := res.(string)
+	job.Gid = gid
+	self.Track(job)
+	_, err = self.Apply(ctx)
+	return gid, err
+}
+
+// Attach 注册通知回调 下载结束后停止跟踪并重新排列 只需要调用一次
+// 需要支持通知的Handler 例如WebsocketRequestHandler
+func (self *Scheduler) Attach() {
+	self.lock.Lock()
+	if self.attached {
+		self.lock.Unlock()
+		return
+	}
+	self.attached = true
+	self.lock.Unlock()
+	finished := func(client *Aria2Client, data RpcRequest) {
+		gid := notificationGid(data)
+		if _, ok := self.Job(gid); !ok {
+			return
+		}
+		self.Untrack(gid)
+		self.Apply(context.Background())
+	}
+	self.client.OnDownloadComplete(finished)
+	self.client.OnDownloadError(finished)
+	self.client.OnDownloadStop(finished)
+	// 有下载开始说明队列头部发生了变化
+	self.client.OnDownloadStart(func(client *Aria2Client, data RpcRequest) {
+		self.Apply(context.Background())
+	})
+}
+
+// notificationGid 取出通知参数中的gid [{"gid":"..."}]
+func notificationGid(data RpcRequest) string {
+	if len(data.Params) == 0 {
+		return ""
+	}
+	if m, ok := data.Params[0].(map[string]interface{}); ok {
+		gid, _ := m["gid"].(string)
+		return gid
+	}
+	return ""
+}
+
+// Apply 读取等待队列 把被跟踪的下载按Strategy重新排列 返回changePosition的调用次数
+// 已经不在队列里的下载(开始或结束了)不会被删除跟踪 由Attach注册的回调处理
+func (self *Scheduler) Apply(ctx context.Context) (moves int, err error) {
+	self.applying.Lock()
+	defer self.applying.Unlock()
+	defer func() {
+		if self.OnApply != nil {
+			self.OnApply(moves, err)
+		}
+	}()
+	client := self.client.WithContext(ctx)
+	waiting, err := client.IterWaiting(0, &[]string{"gid", "totalLength"}).All()
+	if err != nil {
+		return 0, err
+	}
+
+	current := make([]string, len(waiting))
+	slots := make([]int, 0)
+	tracked := make([]*Job, 0)
+	self.lock.Lock()
+	for i, status := range waiting {
+		current[i] = status.Gid
+		if job, ok := self.jobs[status.Gid]; ok {
+			job.Size = status.TotalLength
+			copied := *job
+			tracked = append(tracked, &copied)
+			slots = append(slots, i)
+		}
+	}
+	self.lock.Unlock()
+	sort.SliceStable(tracked, func(i, j int) bool { return self.Strategy.Less(tracked[i], tracked[j]) })
+
+	want := append([]string(nil), current...)
+	for i, slot := range slots {
+		want[slot] = tracked[i].Gid
+	}
+	for _, move := range planMoves(current, want) {
+		if _, err := client.ChangePosition(move.gid, move.pos, "POS_SET"); err != nil {
+			return moves, err
+		}
+		moves++
+		if self.OnMove != nil {
+			self.OnMove(move.gid, move.pos)
+		}
+	}
+	return moves, nil
+}
+
+type queueMove struct {
+	gid string
+	pos int
+}
+
+// planMoves 计算把current变成want所需的最少POS_SET移动 current和want包含相同的元素
+// want中位置构成最长递增子序列的元素保持不动 其余元素按want的顺序逐个插到它在want中前一个元素之后
+func planMoves(current []string, want []string) []queueMove {
+	rank := make(map[string]int, len(want))
+	for i, gid := range want {
+		rank[gid] = i
+	}
+	keep := make(map[string]bool)
+	for _, i := range longestIncreasing(current, rank) {
+		keep[current[i]] = true
+	}
+	sim := append([]string(nil), current...)
+	moves := make([]queueMove, 0)
+	for k, gid := range want {
+		if keep[gid] {
+			continue
+		}
+		sim = removeString(sim, gid)
+		pos := 0
+		if k > 0 {
+			pos = indexString(sim, want[k-1]) + 1
+		}
+		sim = append(sim[:pos], append([]string{gid}, sim[pos:]...)...)
+		moves = append(moves, queueMove{gid: gid, pos: pos})
+	}
+	return moves
+}
+
+// longestIncreasing 返回seq中按rank严格递增的最长子序列的下标 O(n log n)
+func longestIncreasing(seq []string, rank map[string]int) []int {
+	tails := make([]int, 0) // tails[k]是长度为k+1的递增子序列末尾元素的下标
+	prev := make([]int, len(seq))
+	for i, gid := range seq {
+		r := rank[gid]
+		k := sort.Search(len(tails), func(j int) bool { return rank[seq[tails[j]]] >= r })
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	result := make([]int, len(tails))
+	for i, k := len(tails)-1, -1; i >= 0; i-- {
+		if i == len(tails)-1 {
+			k = tails[i]
+		}
+		result[i] = k
+		k = prev[k]
+	}
+	return result
+}
+
+func indexString(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeString(list []string, s string) []string {
+	if i := indexString(list, s); i >= 0 {
+		return append(list[:i], list[i+1:]...)
+	}
+	return list
+}
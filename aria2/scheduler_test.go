@@ -0,0 +1,174 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// applyMoves 按照POS_SET的语义执行移动 先移除再插入到pos
+func applyMoves(queue []string, moves []queueMove) []string {
+	queue = append([]string(nil), queue...)
+	for _, move := range moves {
+		queue = removeString(queue, move.gid)
+		queue = append(queue[:move.pos], append([]string{move.gid}, queue[move.pos:]...)...)
+	}
+	return queue
+}
+
+// lisLength O(n^2)的最长递增子序列长度 用来核对longestIncreasing
+func lisLength(current []string, want []string) int {
+	rank := make(map[string]int, len(want))
+	for i, gid := range want {
+		rank[gid] = i
+	}
+	best := 0
+	length := make([]int, len(current))
+	for i := range current {
+		length[i] = 1
+		for j := 0; j < i; j++ {
+			if rank[current[j]] < rank[current[i]] && length[j]+1 > length[i] {
+				length[i] = length[j] + 1
+			}
+		}
+		if length[i] > best {
+			best = length[i]
+		}
+	}
+	return best
+}
+
+func TestPlanMoves(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"single", "a", "a"},
+		{"already sorted", "a b c d e f", "a b c d e f"},
+		{"fully reversed", "a b c d e f", "f e d c b a"},
+		{"last to front", "a b c d e", "e a b c d"},
+		{"first to back", "a b c d e", "b c d e a"},
+		{"swap ends", "a b c d e", "e b c d a"},
+		{"interleaved", "a b c d e f", "b a d c f e"},
+		{"two blocks", "a b c d e f", "d e f a b c"},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		gids := strings.Fields("a b c d e f g h i j")
+		shuffled := append([]string(nil), gids...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		cases = append(cases, struct {
+			name    string
+			current string
+			want    string
+		}{fmt.Sprintf("random %d", i), strings.Join(gids, " "), strings.Join(shuffled, " ")})
+	}
+	for _, c := range cases {
+		current, want := strings.Fields(c.current), strings.Fields(c.want)
+		moves := planMoves(current, want)
+		if got := applyMoves(current, moves); strings.Join(got, " ") != c.want {
+			t.Errorf("%s: moves %v produce %v, want %v", c.name, moves, got, want)
+		}
+		if n := len(current) - lisLength(current, want); len(moves) != n {
+			t.Errorf("%s: %d moves, want n-LIS = %d", c.name, len(moves), n)
+		}
+	}
+}
+
+func TestLongestIncreasing(t *testing.T) {
+	rank := map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5}
+	cases := []struct {
+		seq  string
+		want string
+	}{
+		{"", ""},
+		{"a b c d", "a b c d"},
+		{"d c b a", "a"},
+		{"c a d b e", "a b e"},
+		{"b f a c d e", "a c d e"},
+	}
+	for _, c := range cases {
+		seq := strings.Fields(c.seq)
+		got := make([]string, 0)
+		for _, i := range longestIncreasing(seq, rank) {
+			got = append(got, seq[i])
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("longestIncreasing(%s) = %v, want %s", c.seq, got, c.want)
+		}
+	}
+}
+
+// fakeQueue 等待队列 changePosition按POS_SET移动
+type fakeQueue struct {
+	lock  sync.Mutex
+	queue []string
+}
+
+func (self *fakeQueue) gids() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return strings.Join(self.queue, " ")
+}
+
+func (self *fakeQueue) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	switch method {
+	case "aria2.tellWaiting":
+		offset, num := int(params[0].(float64)), int(params[1].(float64))
+		statuses := make([]interface{}, 0)
+		for i := offset; i < len(self.queue) && i < offset+num; i++ {
+			statuses = append(statuses, map[string]interface{}{"gid": self.queue[i], "totalLength": "0"})
+		}
+		return statuses, nil
+	case "aria2.changePosition":
+		gid, pos := params[0].(string), int(params[1].(float64))
+		self.queue = applyMoves(self.queue, []queueMove{{gid: gid, pos: pos}})
+		return pos, nil
+	}
+	return versionHandler(method, params)
+}
+
+func TestSchedulerApplyDuplicatePriorities(t *testing.T) {
+	added := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name       string
+		queue      string
+		priorities map[string]int // 没有列出的下载不被跟踪
+		want       string
+		moves      int
+	}{
+		// 优先级相同并且加入时间相同时保持原来的顺序
+		{"all equal", "a b c d", map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, "a b c d", 0},
+		{"ties keep order", "a b c d", map[string]int{"a": 1, "b": 2, "c": 2, "d": 1}, "b c a d", 1},
+		{"reversed groups", "a b c d e f", map[string]int{"a": 1, "b": 1, "c": 2, "d": 2, "e": 3, "f": 3}, "e f c d a b", 4},
+		// 未跟踪的x位置不变
+		{"untracked stays", "a x b c", map[string]int{"a": 1, "b": 2, "c": 2}, "b x c a", 2},
+	}
+	for _, c := range cases {
+		queue := &fakeQueue{queue: strings.Fields(c.queue)}
+		fake := newFakeAria2(t, queue.handle)
+		client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+		scheduler := NewScheduler(client, ByPriority())
+		for gid, priority := range c.priorities {
+			scheduler.Track(Job{Gid: gid, Priority: priority, Added: added})
+		}
+		moves, err := scheduler.Apply(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := queue.gids(); got != c.want {
+			t.Errorf("%s: queue %q, want %q", c.name, got, c.want)
+		}
+		if moves != c.moves {
+			t.Errorf("%s: %d moves, want %d", c.name, moves, c.moves)
+		}
+	}
+}
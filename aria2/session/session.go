@@ -0,0 +1,220 @@
+// Package session 读写aria2的--input-file/--save-session格式
+//
+// 每个下载占一行 同一个资源的多个镜像uri用TAB分隔
+// 紧接着的以空白开头的行是这个下载的选项 key=value
+// 空行和以#开头的行是注释
+//
+//	http://a.example/f.iso	http://b.example/f.iso
+//	  dir=/data/iso
+//	  out=f.iso
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2"
+	"io"
+	"os"
+	"strings"
+)
+
+// Option 一条下载选项 同一个key可以出现多次 例如header
+type Option struct {
+	Key   string
+	Value string
+}
+
+// Options 保持原有顺序的选项列表
+type Options []Option
+
+// Get 返回key第一次出现的值
+func (self Options) Get(key string) (string, bool) {
+	for _, option := range self {
+		if option.Key == key {
+			return option.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll 返回key的所有值
+func (self Options) GetAll(key string) []string {
+	values := make([]string, 0)
+	for _, option := range self {
+		if option.Key == key {
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// Set 替换key的所有值 key不存在时追加
+func (self *Options) Set(key string, value string) {
+	options := make(Options, 0, len(*self)+1)
+	replaced := false
+	for _, option := range *self {
+		if option.Key != key {
+			options = append(options, option)
+		} else if !replaced {
+			options = append(options, Option{Key: key, Value: value})
+			replaced = true
+		}
+	}
+	if !replaced {
+		options = append(options, Option{Key: key, Value: value})
+	}
+	*self = options
+}
+
+// Add 追加一个值 不影响已有的值
+func (self *Options) Add(key string, value string) {
+	*self = append(*self, Option{Key: key, Value: value})
+}
+
+// Del 删除key的所有值
+func (self *Options) Del(key string) {
+	options := (*self)[:0]
+	for _, option := range *self {
+		if option.Key != key {
+			options = append(options, option)
+		}
+	}
+	*self = options
+}
+
+// Map 转换成aria2.addUri需要的options 出现多次的key变成字符串数组
+func (self Options) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(self))
+	for _, option := range self {
+		aria2.MergeOption(m, option.Key, option.Value)
+	}
+	return m
+}
+
+// Entry 一个下载 Uris是同一个资源的镜像
+type Entry struct {
+	Uris    []string
+	Options Options
+}
+
+// ParseError 带行号的解析错误
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (self *ParseError) Error() string {
+	return fmt.Sprintf("session: line %d: %s", self.Line, self.Msg)
+}
+
+// Parse 解析input-file/save-session格式
+func Parse(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(entries) == 0 {
+				return nil, &ParseError{Line: lineNo, Msg: "option without uri"}
+			}
+			i := strings.IndexByte(trimmed, '=')
+			if i <= 0 {
+				return nil, &ParseError{Line: lineNo, Msg: fmt.Sprintf("invalid option %q", trimmed)}
+			}
+			entry := &entries[len(entries)-1]
+			entry.Options = append(entry.Options, Option{Key: trimmed[:i], Value: trimmed[i+1:]})
+			continue
+		}
+		uris := make([]string, 0, 1)
+		for _, uri := range strings.Split(line, "\t") {
+			if uri = strings.TrimSpace(uri); uri != "" {
+				uris = append(uris, uri)
+			}
+		}
+		entries = append(entries, Entry{Uris: uris})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ParseFile 解析文件
+func ParseFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Write 以aria2能读取的格式写出
+func Write(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		if len(entry.Uris) == 0 {
+			return fmt.Errorf("session: entry without uri")
+		}
+		for _, uri := range entry.Uris {
+			if strings.ContainsAny(uri, "\t\n") {
+				return fmt.Errorf("session: invalid uri %q", uri)
+			}
+		}
+		bw.WriteString(strings.Join(entry.Uris, "\t"))
+		bw.WriteByte('\n')
+		for _, option := range entry.Options {
+			if strings.ContainsAny(option.Key, "=\n") || strings.Contains(option.Value, "\n") {
+				return fmt.Errorf("session: invalid option %q", option.Key)
+			}
+			bw.WriteString(" " + option.Key + "=" + option.Value + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteFile 写到文件 先写临时文件再改名 避免aria2读到写了一半的文件
+func WriteFile(path string, entries []Entry) error {
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		return err
+	}
+	return aria2.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// SubmitResult 提交一个Entry的结果
+type SubmitResult struct {
+	Entry Entry
+	Gid   string
+	Err   error
+}
+
+// Submit 把每个Entry用AddUri连同选项提交给aria2 某一项失败不影响其余项
+// save-session里的gid选项会原样提交 aria2里已经有同样gid的下载时这一项会失败
+func Submit(client *aria2.Aria2Client, entries []Entry) []SubmitResult {
+	results := make([]SubmitResult, 0, len(entries))
+	for _, entry := range entries {
+		result := SubmitResult{Entry: entry}
+		var options *map[string]interface{}
+		if len(entry.Options) > 0 {
+			m := entry.Options.Map()
+			options = &m
+		}
+		res, err := client.AddUri(entry.Uris, options, nil)
+		if err != nil {
+			result.Err = err
+		} else if gid, ok := res.(string); ok {
+			result.Gid = gid
+		}
+		results = append(results, result)
+	}
+	return results
+}
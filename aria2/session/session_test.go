@@ -0,0 +1,121 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2"
+	"github.com/synodriver/goaria2/aria2/internal/fakearia2"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sessionText = `# 注释和空行会被跳过
+http://a/f.iso	http://b/f.iso	http://c/f.iso
+ dir=/data
+ header=Authorization: Basic eA==
+ header=X-Mirror: 1
+ split=4
+
+magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567
+	dir=/data/bt
+`
+
+func TestRoundTrip(t *testing.T) {
+	entries, err := Parse(strings.NewReader(sessionText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if fmt.Sprint(entries[0].Uris) != "[http://a/f.iso http://b/f.iso http://c/f.iso]" {
+		t.Fatalf("mirror uris: %q", entries[0].Uris)
+	}
+	if headers := entries[0].Options.GetAll("header"); fmt.Sprint(headers) != "[Authorization: Basic eA== X-Mirror: 1]" {
+		t.Fatalf("headers: %q", headers)
+	}
+	if dir, _ := entries[1].Options.Get("dir"); dir != "/data/bt" {
+		t.Fatalf("tab-indented option: %q", dir)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Write(buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "http://a/f.iso\thttp://b/f.iso\thttp://c/f.iso\n") {
+		t.Fatalf("mirrors not written tab separated:\n%s", buf.String())
+	}
+	again, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, again) {
+		t.Fatalf("round trip changed entries:\n%+v\n%+v", entries, again)
+	}
+
+	// 原子写入文件之后同样能读回来
+	path := filepath.Join(t.TempDir(), "aria2.session")
+	if err := WriteFile(path, entries); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, fromFile) {
+		t.Fatalf("file round trip changed entries:\n%+v\n%+v", entries, fromFile)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse(strings.NewReader(" dir=/data\nhttp://a/f\n"))
+	if _, ok := err.(*ParseError); !ok {
+		t.Fatalf("option before uri: %v", err)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	fake := fakearia2.New(t, func(method string, params []interface{}) (interface{}, *fakearia2.Error) {
+		if method != "aria2.addUri" {
+			return fakearia2.Version(method, params)
+		}
+		uris := params[0].([]interface{})
+		if strings.HasPrefix(uris[0].(string), "magnet:") {
+			return nil, &fakearia2.Error{Code: 1, Message: "bittorrent disabled"}
+		}
+		return "2089b05ecca3d829", nil
+	})
+	client := aria2.NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, aria2.NewNetHttpRequestHandler(nil))
+	entries, err := Parse(strings.NewReader(sessionText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := Submit(client, entries)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Gid != "2089b05ecca3d829" || results[0].Err != nil {
+		t.Fatalf("first result: %+v", results[0])
+	}
+	if results[1].Gid != "" || results[1].Err == nil {
+		t.Fatalf("second result should fail: %+v", results[1])
+	}
+
+	params := fake.Params()
+	if len(params) != 2 {
+		t.Fatalf("expected 2 calls, got %v", fake.Calls())
+	}
+	if fmt.Sprint(params[0][0]) != "[http://a/f.iso http://b/f.iso http://c/f.iso]" {
+		t.Fatalf("uris sent: %v", params[0][0])
+	}
+	options := params[0][1].(map[string]interface{})
+	if options["dir"] != "/data" || options["split"] != "4" {
+		t.Fatalf("options sent: %v", options)
+	}
+	// 重复的header以数组发送
+	if fmt.Sprint(options["header"]) != "[Authorization: Basic eA== X-Mirror: 1]" {
+		t.Fatalf("headers sent: %#v", options["header"])
+	}
+}
@@ -0,0 +1,227 @@
+package aria2
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// GlobalStat aria2.getGlobalStat的结果 aria2返回的数字都是字符串
+type GlobalStat struct {
+	DownloadSpeed   int64 `json:"downloadSpeed,string"`
+	UploadSpeed     int64 `json:"uploadSpeed,string"`
+	NumActive       int   `json:"numActive,string"`
+	NumWaiting      int   `json:"numWaiting,string"`
+	NumStopped      int   `json:"numStopped,string"`
+	NumStoppedTotal int   `json:"numStoppedTotal,string"`
+}
+
+// UriStatus aria2.getUris的一项 Status是used或者waiting
+type UriStatus struct {
+	Uri    string `json:"uri"`
+	Status string `json:"status"`
+}
+
+// FileStatus aria2.getFiles的一项 Index从1开始
+type FileStatus struct {
+	Index           int         `json:"index,string"`
+	Path            string      `json:"path"`
+	Length          int64       `json:"length,string"`
+	CompletedLength int64       `json:"completedLength,string"`
+	Selected        bool        `json:"selected,string"`
+	Uris            []UriStatus `json:"uris"`
+}
+
+// ServerInfo aria2.getServers中正在使用的一个服务器 Uri是原始uri CurrentUri是重定向之后的
+type ServerInfo struct {
+	Uri           string `json:"uri"`
+	CurrentUri    string `json:"currentUri"`
+	DownloadSpeed int64  `json:"downloadSpeed,string"`
+}
+
+// FileServers aria2.getServers的一项 Index从1开始
+type FileServers struct {
+	Index   int          `json:"index,string"`
+	Servers []ServerInfo `json:"servers"`
+}
+
+// BittorrentInfo tellStatus结果中的bittorrent字段
+type BittorrentInfo struct {
+	AnnounceList [][]string `json:"announceList"`
+	Comment      string     `json:"comment"`
+	CreationDate int64      `json:"creationDate"`
+	Mode         string     `json:"mode"`
+	Info         struct {
+		Name string `json:"name"`
+	} `json:"info"`
+}
+
+// DownloadStatus aria2.tellStatus/tellActive/tellWaiting/tellStopped返回的单个下载
+// 指定了keys时没有返回的字段保持零值
+type DownloadStatus struct {
+	Gid                    string          `json:"gid"`
+	Status                 Status          `json:"status"`
+	TotalLength            int64           `json:"totalLength,string"`
+	CompletedLength        int64           `json:"completedLength,string"`
+	UploadLength           int64           `json:"uploadLength,string"`
+	Bitfield               string          `json:"bitfield"`
+	DownloadSpeed          int64           `json:"downloadSpeed,string"`
+	UploadSpeed            int64           `json:"uploadSpeed,string"`
+	InfoHash               string          `json:"infoHash"`
+	NumSeeders             int             `json:"numSeeders,string"`
+	Seeder                 bool            `json:"seeder,string"`
+	PieceLength            int64           `json:"pieceLength,string"`
+	NumPieces              int             `json:"numPieces,string"`
+	Connections            int             `json:"connections,string"`
+	ErrorCode              ErrorCode       `json:"errorCode,string"`
+	ErrorMessage           string          `json:"errorMessage"`
+	FollowedBy             []string        `json:"followedBy"`
+	Following              string          `json:"following"`
+	BelongsTo              string          `json:"belongsTo"`
+	Dir                    string          `json:"dir"`
+	Files                  []FileStatus    `json:"files"`
+	Bittorrent             *BittorrentInfo `json:"bittorrent"`
+	VerifiedLength         int64           `json:"verifiedLength,string"`
+	VerifyIntegrityPending bool            `json:"verifyIntegrityPending,string"`
+}
+
+// Name 下载的名字 种子取info.name 否则取第一个文件的文件名 还没有文件路径时取第一个uri
+func (self *DownloadStatus) Name() string {
+	if self.Bittorrent != nil && self.Bittorrent.Info.Name != "" {
+		return self.Bittorrent.Info.Name
+	}
+	if len(self.Files) == 0 {
+		return ""
+	}
+	if p := self.Files[0].Path; p != "" {
+		return path.Base(strings.ReplaceAll(p, "\\", "/"))
+	}
+	if len(self.Files[0].Uris) > 0 {
+		return self.Files[0].Uris[0].Uri
+	}
+	return ""
+}
+
+// Progress 已完成的比例 0到1 总大小未知时返回0
+func (self *DownloadStatus) Progress() float64 {
+	if self.TotalLength <= 0 {
+		return 0
+	}
+	return float64(self.CompletedLength) / float64(self.TotalLength)
+}
+
+// DownloadError 以error状态结束的下载
+type DownloadError struct {
+	Gid     string
+	Code    ErrorCode
+	Message string
+}
+
+func (self *DownloadError) Error() string {
+	return fmt.Sprintf("aria2: download %s failed: %s (%s)", self.Gid, self.Message, self.Code.Name())
+}
+
+// Err 状态为error时返回*DownloadError 否则返回nil
+func (self *DownloadStatus) Err() error {
+	if self.Status != StatusError {
+		return nil
+	}
+	return &DownloadError{Gid: self.Gid, Code: self.ErrorCode, Message: self.ErrorMessage}
+}
+
+// DecodeResult 把方法返回的interface{}结果转换成具体类型 例如
+// res, _ := client.TellStatus(gid, nil)
+// var status aria2.DownloadStatus
+// err := aria2.DecodeResult(res, &status)
+func DecodeResult(result interface{}, v interface{}) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// withGid 保证keys里包含gid 否则合并、去重时无法区分下载
+func withGid(keys *[]string) *[]string {
+	if keys == nil || len(*keys) == 0 {
+		return keys
+	}
+	for _, key := range *keys {
+		if key == "gid" {
+			return keys
+		}
+	}
+	newKeys := append(append(make([]string, 0, len(*keys)+1), *keys...), "gid")
+	return &newKeys
+}
+
+func (self *Aria2Client) globalStat() (GlobalStat, error) {
+	stat := GlobalStat{}
+	res, err := self.GetGlobalStat()
+	if err != nil {
+		return stat, err
+	}
+	err = DecodeResult(res, &stat)
+	return stat, err
+}
+
+func (self *Aria2Client) tellStatus(gid string, keys *[]string) (DownloadStatus, error) {
+	status := DownloadStatus{}
+	res, err := self.TellStatus(gid, keys)
+	if err != nil {
+		return status, err
+	}
+	err = DecodeResult(res, &status)
+	return status, err
+}
+
+func (self *Aria2Client) getServers(gid string) ([]FileServers, error) {
+	res, err := self.GetServers(gid)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]FileServers, 0)
+	err = DecodeResult(res, &servers)
+	return servers, err
+}
+
+func (self *Aria2Client) getFiles(gid string) ([]FileStatus, error) {
+	res, err := self.GetFiles(gid)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileStatus, 0)
+	err = DecodeResult(res, &files)
+	return files, err
+}
+
+func (self *Aria2Client) tellActive(keys *[]string) ([]DownloadStatus, error) {
+	res, err := self.TellActive(keys)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DownloadStatus, 0)
+	err = DecodeResult(res, &statuses)
+	return statuses, err
+}
+
+func (self *Aria2Client) tellWaiting(offset int, num int, keys *[]string) ([]DownloadStatus, error) {
+	res, err := self.TellWaiting(offset, num, keys)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DownloadStatus, 0)
+	err = DecodeResult(res, &statuses)
+	return statuses, err
+}
+
+func (self *Aria2Client) tellStopped(offset int, num int, keys *[]string) ([]DownloadStatus, error) {
+	res, err := self.TellStopped(offset, num, keys)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]DownloadStatus, 0)
+	err = DecodeResult(res, &statuses)
+	return statuses, err
+}
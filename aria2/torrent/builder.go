@@ -0,0 +1,232 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"github.com/synodriver/goaria2/aria2/bencode"
+	"github.com/synodriver/goaria2/aria2/internal/atomicfile"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	minPieceLength = 16 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	// 自动选择分块大小时的目标分块数
+	targetPieces = 1500
+)
+
+// Builder 把本地文件或目录制作成v1种子
+type Builder struct {
+	PieceLength int64      // 分块大小 必须是2的幂 0表示根据总大小自动选择
+	Trackers    [][]string // announce-list 每个元素是一层 第一层的第一个同时写入announce
+	WebSeeds    []string   // BEP 19 url-list
+	Private     bool
+	// Name 种子名 默认是文件或目录名 和文件或目录名不同时 做种(AddSeed)前需要把内容放在dir/Name下 否则aria2会重新下载
+	Name      string
+	Comment   string
+	CreatedBy string
+	Source    string // 写入info的source字段 私有tracker常用来区分站点
+	// CreationDate 零值表示使用当前时间
+	CreationDate time.Time
+	// SkipHidden 跳过以.开头的文件和目录
+	SkipHidden bool
+}
+
+// NewBuilder 每个tracker单独一层
+func NewBuilder(trackers ...string) *Builder {
+	builder := &Builder{CreatedBy: "goaria2"}
+	for _, tracker := range trackers {
+		builder.Trackers = append(builder.Trackers, []string{tracker})
+	}
+	return builder
+}
+
+type sourceFile struct {
+	path string
+	File
+}
+
+// Build 读取path(文件或目录)计算分块哈希并生成种子 返回值的Raw是bencode编码后的.torrent数据
+// 目录中的文件按路径排序 与aria2的select-file序号一致 符号链接和空目录会被忽略
+func (self *Builder) Build(ctx context.Context, path string) (*MetaInfo, error) {
+	path = filepath.Clean(path)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	name := self.Name
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	files, err := self.collect(path, stat)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	pieceLength := self.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	} else if pieceLength < minPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, errors.New("torrent: piece length must be a power of two and at least 16KiB")
+	}
+	pieces, err := hashPieces(ctx, files, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	if stat.IsDir() {
+		list := make([]interface{}, 0, len(files))
+		for _, f := range files {
+			list = append(list, map[string]interface{}{"length": f.Length, "path": f.Path})
+		}
+		info["files"] = list
+	} else {
+		info["length"] = total
+	}
+	if self.Private {
+		info["private"] = 1
+	}
+	if self.Source != "" {
+		info["source"] = self.Source
+	}
+
+	root := map[string]interface{}{"info": info}
+	tiers := make([][]string, 0, len(self.Trackers))
+	for _, tier := range self.Trackers {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) > 0 {
+		root["announce"] = tiers[0][0]
+		if len(tiers) > 1 || len(tiers[0]) > 1 {
+			root["announce-list"] = tiers
+		}
+	}
+	if len(self.WebSeeds) > 0 {
+		root["url-list"] = self.WebSeeds
+	}
+	if self.Comment != "" {
+		root["comment"] = self.Comment
+	}
+	if self.CreatedBy != "" {
+		root["created by"] = self.CreatedBy
+	}
+	date := self.CreationDate
+	if date.IsZero() {
+		date = time.Now()
+	}
+	root["creation date"] = date.Unix()
+
+	data, err := bencode.Encode(root)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// WriteFile 生成种子并原子地写到dst 与aria2.WriteFileAtomic相同
+func (self *Builder) WriteFile(ctx context.Context, path string, dst string) (*MetaInfo, error) {
+	m, err := self.Build(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(dst, m.Raw, 0644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (self *Builder) collect(path string, stat os.FileInfo) ([]sourceFile, error) {
+	if !stat.IsDir() {
+		return []sourceFile{{path: path, File: File{Path: []string{stat.Name()}, Length: stat.Size()}}}, nil
+	}
+	files := make([]sourceFile, 0)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if self.SkipHidden && p != path && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, sourceFile{path: p, File: File{Path: strings.Split(filepath.ToSlash(rel), "/"), Length: info.Size()}})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("torrent: no files to add")
+	}
+	return files, nil
+}
+
+func choosePieceLength(total int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// hashPieces 把所有文件首尾相连后按pieceLength分块计算sha1
+func hashPieces(ctx context.Context, files []sourceFile, pieceLength int64) (string, error) {
+	var pieces strings.Builder
+	buf := make([]byte, pieceLength)
+	filled := 0
+	for _, f := range files {
+		fp, err := os.Open(f.path)
+		if err != nil {
+			return "", err
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				fp.Close()
+				return "", err
+			}
+			n, err := io.ReadFull(fp, buf[filled:])
+			filled += n
+			if filled == len(buf) {
+				sum := sha1.Sum(buf)
+				pieces.Write(sum[:])
+				filled = 0
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				fp.Close()
+				return "", err
+			}
+		}
+		fp.Close()
+	}
+	if filled > 0 {
+		sum := sha1.Sum(buf[:filled])
+		pieces.Write(sum[:])
+	}
+	return pieces.String(), nil
+}
@@ -0,0 +1,125 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/bencode"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuilderRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "album")
+	big := string(bytes.Repeat([]byte("0123456789abcdef"), 3000)) // 48000字节 跨越多个分块
+	writeTree(t, src, map[string]string{
+		"b/02.flac":    big,
+		"a.txt":        "hello",
+		"b/01.flac":    "short",
+		".hidden/skip": "x",
+	})
+	builder := NewBuilder("http://t1.example/announce", "udp://t2.example:6969")
+	builder.PieceLength = 16 * 1024
+	builder.WebSeeds = []string{"http://seed.example/"}
+	builder.Private = true
+	builder.Comment = "test"
+	builder.SkipHidden = true
+	builder.CreationDate = time.Unix(1700000000, 0)
+	dst := filepath.Join(dir, "album.torrent")
+	built, err := builder.WriteFile(context.Background(), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件按路径排序 与select-file序号一致
+	if got := paths(parsed); got != "a.txt b/01.flac b/02.flac" {
+		t.Fatalf("files %q", got)
+	}
+	for i, length := range []int64{5, 5, 48000} {
+		if parsed.Files[i].Length != length {
+			t.Errorf("file %d length %d, want %d", i, parsed.Files[i].Length, length)
+		}
+	}
+	if parsed.InfoHash != built.InfoHash || parsed.InfoHash == "" {
+		t.Fatalf("info-hash %q, built %q", parsed.InfoHash, built.InfoHash)
+	}
+	// 独立计算info字典的sha1
+	raw, err := bencode.DecodeRawDict(parsed.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum(raw["info"])
+	if hex.EncodeToString(sum[:]) != parsed.InfoHash {
+		t.Fatalf("info-hash does not match sha1 of info dictionary")
+	}
+	// 48010字节 每块16KiB
+	if parsed.NumPieces != 3 || parsed.PieceLength != 16*1024 {
+		t.Fatalf("pieces %d x %d", parsed.NumPieces, parsed.PieceLength)
+	}
+	if parsed.Name != "album" || !parsed.Private || parsed.Comment != "test" || parsed.CreatedBy != "goaria2" {
+		t.Fatalf("metadata %+v", parsed)
+	}
+	if fmt.Sprint(parsed.Trackers()) != "[http://t1.example/announce udp://t2.example:6969]" || fmt.Sprint(parsed.UrlList) != "[http://seed.example/]" {
+		t.Fatalf("trackers %v url-list %v", parsed.Trackers(), parsed.UrlList)
+	}
+	if !parsed.CreationDate.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("creation date %v", parsed.CreationDate)
+	}
+
+	// 原子写入不留下临时文件
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected files in %s: %v", dir, entries)
+	}
+}
+
+func TestBuilderSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"file.bin": "0123456789"})
+	built, err := NewBuilder().Build(context.Background(), filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(built.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths(parsed) != "file.bin" || parsed.TotalLength() != 10 || parsed.InfoHash != built.InfoHash {
+		t.Fatalf("parsed %+v", parsed)
+	}
+	// 只有一块 pieces就是整个文件的sha1
+	raw, _ := bencode.DecodeRawDict(built.Raw)
+	info, _ := bencode.Decode(raw["info"])
+	sum := sha1.Sum([]byte("0123456789"))
+	if info.(map[string]interface{})["pieces"] != string(sum[:]) {
+		t.Fatal("piece hash mismatch")
+	}
+	if _, err := (&Builder{PieceLength: 1000}).Build(context.Background(), dir); err == nil {
+		t.Fatal("piece length that is not a power of two accepted")
+	}
+}
@@ -0,0 +1,315 @@
+// Package torrent 解析.torrent元信息(BitTorrent v1、v2以及混合种子)
+// 用于在AddTorrent之前预览文件列表、挑选select-file、拒绝不需要的种子
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/bencode"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File 种子中的一个文件 Path是各级目录名和文件名 不包含种子名
+type File struct {
+	Path       []string
+	Length     int64
+	Padding    bool   // BEP 47填充文件 aria2会跳过
+	PiecesRoot []byte // v2文件的merkle根
+}
+
+// PathString 用/连接的相对路径
+func (self File) PathString() string {
+	return strings.Join(self.Path, "/")
+}
+
+// MetaInfo .torrent文件的内容
+type MetaInfo struct {
+	Name        string
+	InfoHash    string // v1 info-hash 40位十六进制 纯v2种子为空
+	InfoHashV2  string // v2 info-hash 64位十六进制 纯v1种子为空
+	PieceLength int64
+	NumPieces   int
+	Private     bool
+	// Files 文件列表 顺序与aria2 select-file的序号一致(从1开始) 单文件种子只有一项 Path为[Name]
+	Files        []File
+	Announce     string
+	AnnounceList [][]string
+	UrlList      []string // BEP 19 web seed
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	MetaVersion  int // 1或2 混合种子是2
+	// Raw 原始的.torrent数据
+	Raw []byte
+}
+
+// IsHybrid 同时包含v1和v2信息
+func (self *MetaInfo) IsHybrid() bool {
+	return self.InfoHash != "" && self.InfoHashV2 != ""
+}
+
+// TotalLength 所有非填充文件的大小之和
+func (self *MetaInfo) TotalLength() int64 {
+	var total int64
+	for _, f := range self.Files {
+		if !f.Padding {
+			total += f.Length
+		}
+	}
+	return total
+}
+
+// Trackers 所有tracker 去重后按announce-list的顺序排列
+func (self *MetaInfo) Trackers() []string {
+	seen := make(map[string]bool)
+	trackers := make([]string, 0)
+	add := func(tracker string) {
+		if tracker != "" && !seen[tracker] {
+			seen[tracker] = true
+			trackers = append(trackers, tracker)
+		}
+	}
+	for _, tier := range self.AnnounceList {
+		for _, tracker := range tier {
+			add(tracker)
+		}
+	}
+	add(self.Announce)
+	return trackers
+}
+
+// SelectFiles 返回满足条件的文件对应的select-file选项值 例如"1,3-5" 没有文件满足时返回空字符串
+// index从1开始 填充文件不会被选中
+func (self *MetaInfo) SelectFiles(match func(index int, f File) bool) string {
+	indexes := make([]int, 0)
+	for i, f := range self.Files {
+		if !f.Padding && match(i+1, f) {
+			indexes = append(indexes, i+1)
+		}
+	}
+	return formatRanges(indexes)
+}
+
+func formatRanges(indexes []int) string {
+	parts := make([]string, 0)
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && indexes[j+1] == indexes[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(indexes[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indexes[i], indexes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse 解析.torrent数据
+func Parse(data []byte) (*MetaInfo, error) {
+	raw, err := bencode.DecodeRawDict(data)
+	if err != nil {
+		return nil, err
+	}
+	rawInfo, ok := raw["info"]
+	if !ok {
+		return nil, errors.New("torrent: missing info dictionary")
+	}
+	v, err := bencode.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	root := v.(map[string]interface{})
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent: info is not a dictionary")
+	}
+
+	m := &MetaInfo{Raw: data, MetaVersion: 1}
+	m.Name, _ = info["name"].(string)
+	if utf8Name, ok := info["name.utf-8"].(string); ok {
+		m.Name = utf8Name
+	}
+	m.PieceLength, _ = info["piece length"].(int64)
+	if m.PieceLength <= 0 {
+		return nil, errors.New("torrent: invalid piece length")
+	}
+	private, _ := info["private"].(int64)
+	m.Private = private == 1
+	if version, ok := info["meta version"].(int64); ok {
+		m.MetaVersion = int(version)
+	}
+
+	pieces, hasPieces := info["pieces"].(string)
+	if hasPieces {
+		if len(pieces)%sha1.Size != 0 {
+			return nil, errors.New("torrent: invalid pieces length")
+		}
+		m.NumPieces = len(pieces) / sha1.Size
+		sum := sha1.Sum(rawInfo)
+		m.InfoHash = hex.EncodeToString(sum[:])
+	}
+	if m.MetaVersion >= 2 {
+		sum := sha256.Sum256(rawInfo)
+		m.InfoHashV2 = hex.EncodeToString(sum[:])
+	}
+
+	if hasPieces { // v1或混合种子 以v1文件列表为准 与aria2的序号一致
+		if err := m.parseV1Files(info); err != nil {
+			return nil, err
+		}
+	} else if m.MetaVersion >= 2 {
+		tree, ok := info["file tree"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("torrent: missing file tree")
+		}
+		if err := m.parseFileTree(tree, nil); err != nil {
+			return nil, err
+		}
+		// v2的piece按文件对齐 每个文件单独向上取整
+		for _, f := range m.Files {
+			m.NumPieces += int((f.Length + m.PieceLength - 1) / m.PieceLength)
+		}
+	} else {
+		return nil, errors.New("torrent: missing pieces")
+	}
+
+	m.Announce, _ = root["announce"].(string)
+	if tiers, ok := root["announce-list"].([]interface{}); ok {
+		for _, tier := range tiers {
+			list, _ := tier.([]interface{})
+			trackers := make([]string, 0, len(list))
+			for _, tracker := range list {
+				if s, ok := tracker.(string); ok && s != "" {
+					trackers = append(trackers, s)
+				}
+			}
+			if len(trackers) > 0 {
+				m.AnnounceList = append(m.AnnounceList, trackers)
+			}
+		}
+	}
+	switch urlList := root["url-list"].(type) {
+	case string:
+		if urlList != "" {
+			m.UrlList = []string{urlList}
+		}
+	case []interface{}:
+		for _, u := range urlList {
+			if s, ok := u.(string); ok && s != "" {
+				m.UrlList = append(m.UrlList, s)
+			}
+		}
+	}
+	m.Comment, _ = root["comment"].(string)
+	m.CreatedBy, _ = root["created by"].(string)
+	if date, ok := root["creation date"].(int64); ok && date > 0 {
+		m.CreationDate = time.Unix(date, 0)
+	}
+	return m, nil
+}
+
+func (self *MetaInfo) parseV1Files(info map[string]interface{}) error {
+	files, multi := info["files"].([]interface{})
+	if !multi {
+		length, ok := info["length"].(int64)
+		if !ok || length < 0 {
+			return errors.New("torrent: missing length")
+		}
+		self.Files = []File{{Path: []string{self.Name}, Length: length}}
+		return nil
+	}
+	for i, item := range files {
+		f, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("torrent: file %d is not a dictionary", i)
+		}
+		length, ok := f["length"].(int64)
+		if !ok || length < 0 {
+			return fmt.Errorf("torrent: file %d has invalid length", i)
+		}
+		pathList, ok := f["path.utf-8"].([]interface{})
+		if !ok {
+			pathList, _ = f["path"].([]interface{})
+		}
+		path := make([]string, 0, len(pathList))
+		for _, p := range pathList {
+			s, _ := p.(string)
+			if !safeComponent(s) {
+				return fmt.Errorf("torrent: file %d has unsafe path", i)
+			}
+			path = append(path, s)
+		}
+		if len(path) == 0 {
+			return fmt.Errorf("torrent: file %d has empty path", i)
+		}
+		attr, _ := f["attr"].(string)
+		self.Files = append(self.Files, File{Path: path, Length: length, Padding: strings.Contains(attr, "p")})
+	}
+	return nil
+}
+
+// safeComponent 路径中的一级 不能为空、不能是.或..、不能包含路径分隔符 否则aria2可能写到dir之外
+func safeComponent(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\")
+}
+
+// parseFileTree 解析v2的file tree 目录按名字排序 与bencode字典顺序一致
+func (self *MetaInfo) parseFileTree(tree map[string]interface{}, prefix []string) error {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node, ok := tree[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("torrent: invalid file tree node %q", name)
+		}
+		if !safeComponent(name) {
+			return fmt.Errorf("torrent: unsafe path component %q in file tree", name)
+		}
+		path := append(append([]string(nil), prefix...), name)
+		if leaf, ok := node[""].(map[string]interface{}); ok {
+			length, ok := leaf["length"].(int64)
+			if !ok || length < 0 {
+				return fmt.Errorf("torrent: file %s has invalid length", strings.Join(path, "/"))
+			}
+			root, _ := leaf["pieces root"].(string)
+			self.Files = append(self.Files, File{Path: path, Length: length, PiecesRoot: []byte(root)})
+			continue
+		}
+		if err := self.parseFileTree(node, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseReader 读取全部数据后解析
+func ParseReader(r io.Reader) (*MetaInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// ParseFile 解析.torrent文件
+func ParseFile(path string) (*MetaInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
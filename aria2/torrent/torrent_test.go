@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/bencode"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseFixture(t *testing.T, name string) *MetaInfo {
+	t.Helper()
+	m, err := ParseFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// paths 文件路径 填充文件后面加上(pad)
+func paths(m *MetaInfo) string {
+	list := make([]string, 0, len(m.Files))
+	for _, f := range m.Files {
+		if f.Padding {
+			list = append(list, f.PathString()+"(pad)")
+		} else {
+			list = append(list, f.PathString())
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+func TestParseFixtures(t *testing.T) {
+	cases := []struct {
+		file       string
+		infoHash   string
+		infoHashV2 string
+		version    int
+		pieces     int
+		files      string
+		total      int64
+	}{
+		{"v1-single.torrent", "a8fdb9494ab0f432252b7082689a0f83582e355b", "", 1, 4, "file.iso", 100000},
+		{"v1-multi.torrent", "24104b2d0d738bc60b22dcdeddc39dbc6e940d01", "", 1, 2, "a/one.bin .pad/25536(pad) b.txt c/ü.txt", 41005},
+		// v2的piece按文件对齐 10字节占1块 70000字节占2块
+		{"v2.torrent", "", "96242e378f079f565163e510761eac8a3a363396c2360f454999c77e4635d1cf", 2, 3, "a.txt dir/b.bin", 70010},
+		{"hybrid.torrent", "7ec16b8c0365e61710a6726a243d0b9cff49850e", "6f9ae19dda5a83f08ac0166697c7381f174d0cdd7f57d3318d5c19c5484bbca1", 2, 3, "a.txt .pad/65526(pad) dir/b.bin", 70010},
+	}
+	for _, c := range cases {
+		m := parseFixture(t, c.file)
+		if m.InfoHash != c.infoHash || m.InfoHashV2 != c.infoHashV2 {
+			t.Errorf("%s: info-hash %q %q", c.file, m.InfoHash, m.InfoHashV2)
+		}
+		if m.MetaVersion != c.version || m.IsHybrid() != (c.infoHash != "" && c.infoHashV2 != "") {
+			t.Errorf("%s: meta version %d hybrid %v", c.file, m.MetaVersion, m.IsHybrid())
+		}
+		if m.NumPieces != c.pieces {
+			t.Errorf("%s: NumPieces = %d, want %d", c.file, m.NumPieces, c.pieces)
+		}
+		if got := paths(m); got != c.files {
+			t.Errorf("%s: files %q, want %q", c.file, got, c.files)
+		}
+		if m.TotalLength() != c.total {
+			t.Errorf("%s: TotalLength = %d, want %d", c.file, m.TotalLength(), c.total)
+		}
+	}
+}
+
+func TestParseV1MultiMetadata(t *testing.T) {
+	m := parseFixture(t, "v1-multi.torrent")
+	if m.Name != "multi" || !m.Private || m.PieceLength != 65536 {
+		t.Fatalf("name=%q private=%v piece length=%d", m.Name, m.Private, m.PieceLength)
+	}
+	if got := fmt.Sprint(m.Trackers()); got != "[http://t1.example/announce http://t2.example/announce udp://t3.example:6969]" {
+		t.Fatalf("trackers %s", got)
+	}
+	if fmt.Sprint(m.UrlList) != "[http://seed.example/multi/]" {
+		t.Fatalf("url-list %v", m.UrlList)
+	}
+	single := parseFixture(t, "v1-single.torrent")
+	if single.Comment != "single" || single.CreationDate.Unix() != 1700000000 {
+		t.Fatalf("comment=%q date=%v", single.Comment, single.CreationDate)
+	}
+}
+
+func TestSelectFiles(t *testing.T) {
+	all := func(int, File) bool { return true }
+	cases := []struct {
+		file  string
+		match func(index int, f File) bool
+		want  string
+	}{
+		// 填充文件占用序号但不会被选中
+		{"v1-multi.torrent", all, "1,3-4"},
+		{"v1-multi.torrent", func(_ int, f File) bool { return strings.HasSuffix(f.PathString(), ".txt") }, "3-4"},
+		{"v1-multi.torrent", func(_ int, f File) bool { return f.Length > 1<<20 }, ""},
+		{"v2.torrent", all, "1-2"},
+		{"v2.torrent", func(_ int, f File) bool { return f.Path[0] == "dir" }, "2"},
+		// 混合种子的序号以v1文件列表为准
+		{"hybrid.torrent", all, "1,3"},
+		{"hybrid.torrent", func(_ int, f File) bool { return f.PathString() == "dir/b.bin" }, "3"},
+	}
+	for _, c := range cases {
+		if got := parseFixture(t, c.file).SelectFiles(c.match); got != c.want {
+			t.Errorf("%s: SelectFiles = %q, want %q", c.file, got, c.want)
+		}
+	}
+}
+
+func TestParseRejectsUnsafePaths(t *testing.T) {
+	build := func(info map[string]interface{}) []byte {
+		info["name"] = "evil"
+		info["piece length"] = 16384
+		data, err := bencode.Encode(map[string]interface{}{"info": info})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	v1 := func(path ...interface{}) []byte {
+		return build(map[string]interface{}{
+			"pieces": strings.Repeat("x", 20),
+			"files":  []interface{}{map[string]interface{}{"length": 1, "path": path}},
+		})
+	}
+	v2 := func(tree map[string]interface{}) []byte {
+		return build(map[string]interface{}{"meta version": 2, "file tree": tree})
+	}
+	leaf := map[string]interface{}{"": map[string]interface{}{"length": 1}}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"files dotdot", v1("..", "etc", "passwd")},
+		{"files slash", v1("a/b")},
+		{"files absolute", v1("/etc")},
+		{"files backslash", v1("..\\x")},
+		{"files empty component", v1("a", "", "b")},
+		{"files empty path", v1()},
+		{"tree dotdot", v2(map[string]interface{}{"..": map[string]interface{}{"x": leaf}})},
+		{"tree slash", v2(map[string]interface{}{"a/b": leaf})},
+		{"tree nested dot", v2(map[string]interface{}{"a": map[string]interface{}{".": leaf}})},
+		{"tree empty component", v2(map[string]interface{}{"": map[string]interface{}{"length": 1}})},
+	}
+	for _, c := range cases {
+		if _, err := Parse(c.data); err == nil {
+			t.Errorf("%s: unsafe torrent accepted", c.name)
+		}
+	}
+}
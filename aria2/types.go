@@ -1,5 +1,7 @@
 package aria2
 
+import "fmt"
+
 type RpcRequest struct {
 	Jsonrpc string        `json:"jsonrpc"`
 	Id      interface{}   `json:"id"`
@@ -17,10 +19,51 @@ type RpcResponse struct {
 	Error   interface{} `json:"error"`
 }
 
+// Err 把响应中的error字段转换成*ErrorMsg 没有出错时返回nil
+func (self *RpcResponse) Err() error {
+	if self.Error == nil {
+		return nil
+	}
+	return newErrorMsg(self.Error)
+}
+
 type Callback func(client *Aria2Client, data RpcRequest)
 
+// ErrorMsg aria2返回的rpc错误 {"code":1,"message":"..."}
+// Code是JSON-RPC的错误码 和退出码共用同一套数值 下载失败的原因见DownloadStatus.ErrorCode
 type ErrorMsg struct {
-	Code    int
-	Message string
-	Data    string
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func newErrorMsg(v interface{}) *ErrorMsg {
+	e := &ErrorMsg{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if code, ok := v["code"].(float64); ok {
+			e.Code = int(code)
+		}
+		if message, ok := v["message"].(string); ok {
+			e.Message = message
+		}
+		if data, ok := v["data"]; ok && data != nil {
+			e.Data = fmt.Sprint(data)
+		}
+	case string:
+		e.Message = v
+	default:
+		e.Message = fmt.Sprint(v)
+	}
+	return e
+}
+
+func (self *ErrorMsg) Error() string {
+	return fmt.Sprintf("aria2: rpc error %d: %s", self.Code, self.Message)
+}
+
+// ErrorCode 把Code转换成ErrorCode 方便和codes.go中的常量比较
+// aria2对大多数rpc错误(例如GID不存在、参数错误)都返回1 也就是CodeUnknownError
+func (self *ErrorMsg) ErrorCode() ErrorCode {
+	return ErrorCode(self.Code)
 }
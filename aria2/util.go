@@ -2,6 +2,12 @@ package aria2
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/synodriver/goaria2/aria2/internal/atomicfile"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -34,3 +40,67 @@ func PutBuffer(buf *bytes.Buffer) {
 	}
 }
 
+
+// ParseSize 解析aria2的大小写法 1024 10K 2M 1G 单位是1024进制 大小写均可
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("aria2: invalid size %q", s)
+	}
+	var unit int64 = 1
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("aria2: invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// WriteFileAtomic 先写同目录下的唯一临时文件并fsync 再改名覆盖path
+// 进程中途退出不会留下写了一半的文件 多个写入者同时写同一个path也不会互相覆盖临时文件
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return atomicfile.WriteFile(path, data, perm)
+}
+
+// writeJsonFile 把v编码成json后原子地写到path
+func writeJsonFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
+
+// readJsonFile 读取path并解码到v 文件不存在时返回false和nil
+func readJsonFile(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// MergeOption 把一个key=value加到选项map里 重复出现的key(例如header)合并成[]string 与aria2接受的格式一致
+func MergeOption(options map[string]interface{}, key string, value string) {
+	switch v := options[key].(type) {
+	case nil:
+		options[key] = value
+	case string:
+		options[key] = []string{v, value}
+	case []string:
+		options[key] = append(v, value)
+	}
+}
@@ -0,0 +1,309 @@
+package aria2
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StallPolicy 下载停滞时的处理方式
+type StallPolicy int
+
+const (
+	// StallRestart 暂停后恢复 让aria2重新连接服务器和peer
+	StallRestart StallPolicy = iota
+	// StallDropSlowUris 用changeUri去掉速度低于SlowSpeed的uri 再重启下载 至少保留一个uri
+	StallDropSlowUris
+	// StallResubmit 删除后用同样的uri和选项重新添加 GID会改变
+	StallResubmit
+)
+
+func (self StallPolicy) String() string {
+	switch self {
+	case StallRestart:
+		return "restart"
+	case StallDropSlowUris:
+		return "drop-slow-uris"
+	case StallResubmit:
+		return "resubmit"
+	}
+	return "unknown"
+}
+
+// WatchdogEventType Watchdog事件的类型
+type WatchdogEventType string
+
+const (
+	WatchdogStalled   WatchdogEventType = "stalled"   // 超过StallAfter没有进展
+	WatchdogAction    WatchdogEventType = "action"    // 执行了Policy
+	WatchdogRecovered WatchdogEventType = "recovered" // 处理之后重新有了进展
+	WatchdogGaveUp    WatchdogEventType = "gave-up"   // 所有Policies都试过了仍然停滞
+)
+
+// WatchdogEvent Watchdog发出的事件
+type WatchdogEvent struct {
+	Type    WatchdogEventType
+	Gid     string
+	Policy  StallPolicy   // Type为WatchdogAction时有效
+	Stalled time.Duration // 已经停滞的时间
+	NewGid  string        // StallResubmit之后的新GID
+	Dropped []string      // StallDropSlowUris去掉的uri
+	Err     error         // 执行Policy失败时的错误
+}
+
+type watchedDownload struct {
+	completed    int64
+	lastProgress time.Time
+	lastChecked  time.Time // 上一次Check的时间 用来扣除排队的时间
+	attempts     int       // 已经执行的Policy数
+	since        int       // lastProgress重新计时的时候已经执行的Policy数 只有StallResubmit之后不为0
+	rebase       bool      // 下一次看到时只记录completedLength 不算进展
+	stalled      bool      // 已经发出过stalled事件
+	gaveUp       bool
+}
+
+// Watchdog 定时检查活动中的下载 completedLength长时间不增长时依次执行Policies
+// 每执行一个Policy后重新计时 再次停滞时执行下一个 全部执行完仍停滞时发出WatchdogGaveUp
+// 做种中的下载不检查
+type Watchdog struct {
+	StallAfter  time.Duration // 没有进展多久算停滞 默认5分钟
+	MinProgress int64         // 两次检查之间completedLength至少增长多少字节才算有进展 默认1
+	Policies    []StallPolicy // 默认依次StallRestart StallDropSlowUris StallResubmit
+	SlowSpeed   int64         // StallDropSlowUris中低于这个速度(字节/秒)的uri会被去掉 默认1024
+	Interval    time.Duration // Run的检查间隔 默认30秒
+	Clock       Clock         // 默认SystemClock
+	OnEvent     func(event WatchdogEvent)
+
+	client    *Aria2Client
+	downloads map[string]*watchedDownload
+	lock      sync.Mutex
+	checking  sync.Mutex // 同一时间只有一个Check
+}
+
+// NewWatchdog 使用默认设置
+func NewWatchdog(client *Aria2Client) *Watchdog {
+	return &Watchdog{
+		StallAfter:  5 * time.Minute,
+		MinProgress: 1,
+		Policies:    []StallPolicy{StallRestart, StallDropSlowUris, StallResubmit},
+		SlowSpeed:   1024,
+		Interval:    30 * time.Second,
+		Clock:       SystemClock,
+		client:      client,
+		downloads:   make(map[string]*watchedDownload),
+	}
+}
+
+func (self *Watchdog) clock() Clock {
+	if self.Clock != nil {
+		return self.Clock
+	}
+	return SystemClock
+}
+
+func (self *Watchdog) emit(event WatchdogEvent) {
+	if self.OnEvent != nil {
+		self.OnEvent(event)
+	}
+}
+
+// watchdogAction 一次Check中要执行的Policy 在释放lock之后执行
+type watchdogAction struct {
+	gid     string
+	policy  StallPolicy
+	stalled time.Duration
+	watched *watchedDownload
+}
+
+// Check 检查一次所有活动中的下载
+// 不在活动列表里的下载用tellStatus确认 还在排队(例如restart之后等待空位)时保留计数 排队的时间不算停滞
+// 已经结束或者aria2不认识的GID才会被忘掉
+func (self *Watchdog) Check(ctx context.Context) error {
+	self.checking.Lock()
+	defer self.checking.Unlock()
+	client := self.client.WithContext(ctx)
+	active, err := client.tellActive(&[]string{"gid", "status", "completedLength", "totalLength", "seeder"})
+	if err != nil {
+		return err
+	}
+	now := self.clock().Now()
+	self.lock.Lock()
+	events, actions, missing := self.update(active, now)
+	self.lock.Unlock()
+	for _, event := range events {
+		self.emit(event)
+	}
+
+	for _, gid := range missing {
+		status, err := client.tellStatus(gid, &[]string{"gid", "status"})
+		var rpcErr *ErrorMsg
+		if err != nil && !errors.As(err, &rpcErr) {
+			return err
+		}
+		self.lock.Lock()
+		if watched, ok := self.downloads[gid]; ok {
+			if err == nil && status.Status.IsQueued() {
+				watched.lastProgress = watched.lastProgress.Add(now.Sub(watched.lastChecked))
+				watched.lastChecked = now
+			} else {
+				delete(self.downloads, gid)
+			}
+		}
+		self.lock.Unlock()
+	}
+
+	for _, action := range actions {
+		event := self.apply(client, action.gid, action.policy)
+		event.Stalled = action.stalled
+		if event.NewGid != "" {
+			// 新的下载继承已经尝试过的次数 但completedLength从头开始 要重新记录基准和计时
+			// 否则新下载的completedLength和旧的不同会被当成进展 attempts被清零 永远不会GaveUp
+			self.lock.Lock()
+			if self.downloads[action.gid] == action.watched {
+				delete(self.downloads, action.gid)
+			}
+			now := self.clock().Now()
+			self.downloads[event.NewGid] = &watchedDownload{
+				lastProgress: now,
+				lastChecked:  now,
+				attempts:     action.watched.attempts,
+				since:        action.watched.attempts,
+				rebase:       true,
+				stalled:      action.watched.stalled,
+			}
+			self.lock.Unlock()
+		}
+		self.emit(event)
+	}
+	return nil
+}
+
+// update 根据活动列表更新计时 返回要发出的事件、要执行的Policy和不在活动列表里的GID 调用时持有lock
+func (self *Watchdog) update(active []DownloadStatus, now time.Time) ([]WatchdogEvent, []watchdogAction, []string) {
+	events := make([]WatchdogEvent, 0)
+	actions := make([]watchdogAction, 0)
+	seen := make(map[string]bool, len(active))
+	minProgress := self.MinProgress
+	if minProgress <= 0 {
+		minProgress = 1
+	}
+	stallAfter := self.StallAfter
+	if stallAfter <= 0 {
+		stallAfter = 5 * time.Minute
+	}
+	for _, status := range active {
+		seen[status.Gid] = true
+		if status.Seeder || (status.TotalLength > 0 && status.CompletedLength >= status.TotalLength) {
+			delete(self.downloads, status.Gid)
+			continue
+		}
+		watched, ok := self.downloads[status.Gid]
+		if !ok {
+			self.downloads[status.Gid] = &watchedDownload{completed: status.CompletedLength, lastProgress: now, lastChecked: now}
+			continue
+		}
+		watched.lastChecked = now
+		if watched.rebase {
+			watched.completed, watched.rebase = status.CompletedLength, false
+			continue
+		}
+		if status.CompletedLength-watched.completed >= minProgress || status.CompletedLength < watched.completed {
+			if watched.stalled {
+				events = append(events, WatchdogEvent{Type: WatchdogRecovered, Gid: status.Gid, Stalled: now.Sub(watched.lastProgress)})
+			}
+			watched.completed = status.CompletedLength
+			watched.lastProgress = now
+			watched.stalled, watched.gaveUp, watched.attempts, watched.since = false, false, 0, 0
+			continue
+		}
+		stalled := now.Sub(watched.lastProgress)
+		// 每执行一个Policy就要再等一个StallAfter
+		if watched.gaveUp || stalled < stallAfter*time.Duration(watched.attempts-watched.since+1) {
+			continue
+		}
+		if !watched.stalled {
+			watched.stalled = true
+			events = append(events, WatchdogEvent{Type: WatchdogStalled, Gid: status.Gid, Stalled: stalled})
+		}
+		if watched.attempts >= len(self.Policies) {
+			watched.gaveUp = true
+			events = append(events, WatchdogEvent{Type: WatchdogGaveUp, Gid: status.Gid, Stalled: stalled})
+			continue
+		}
+		actions = append(actions, watchdogAction{gid: status.Gid, policy: self.Policies[watched.attempts], stalled: stalled, watched: watched})
+		watched.attempts++
+	}
+	missing := make([]string, 0)
+	for gid := range self.downloads {
+		if !seen[gid] {
+			missing = append(missing, gid)
+		}
+	}
+	sort.Strings(missing)
+	return events, actions, missing
+}
+
+func (self *Watchdog) apply(client *Aria2Client, gid string, policy StallPolicy) WatchdogEvent {
+	event := WatchdogEvent{Type: WatchdogAction, Gid: gid, Policy: policy}
+	switch policy {
+	case StallRestart:
+		event.Err = client.restart(gid)
+	case StallDropSlowUris:
+		event.Dropped, event.Err = self.dropSlowUris(client, gid)
+		if event.Err == nil {
+			event.Err = client.restart(gid)
+		}
+	case StallResubmit:
+		event.NewGid, event.Err = client.resubmit(gid, nil, nil)
+	}
+	return event
+}
+
+// dropSlowUris 去掉每个文件中低于SlowSpeed的服务器对应的uri 每个文件至少保留最快的一个
+func (self *Watchdog) dropSlowUris(client *Aria2Client, gid string) ([]string, error) {
+	files, err := client.getServers(gid)
+	if err != nil {
+		return nil, err
+	}
+	slowSpeed := self.SlowSpeed
+	if slowSpeed <= 0 {
+		slowSpeed = 1024
+	}
+	dropped := make([]string, 0)
+	for _, file := range files {
+		servers := append([]ServerInfo(nil), file.Servers...)
+		sort.SliceStable(servers, func(i, j int) bool { return servers[i].DownloadSpeed > servers[j].DownloadSpeed })
+		remove := make([]string, 0)
+		for i, server := range servers {
+			if i > 0 && server.DownloadSpeed < slowSpeed {
+				remove = append(remove, server.Uri)
+			}
+		}
+		if len(remove) == 0 {
+			continue
+		}
+		if _, err := client.ChangeUri(gid, file.Index, remove, []string{}, nil); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, remove...)
+	}
+	return dropped, nil
+}
+
+// Run 每隔Interval调用一次Check 直到ctx结束 Check出错不会退出
+func (self *Watchdog) Run(ctx context.Context) error {
+	interval := self.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	for {
+		self.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-self.clock().After(interval):
+		}
+	}
+}
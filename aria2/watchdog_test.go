@@ -0,0 +1,215 @@
+package aria2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDownload 单个下载的假aria2 forcePause之后进入paused unpause之后进入waiting
+type fakeDownload struct {
+	lock   sync.Mutex
+	gid    string
+	status Status
+}
+
+func (self *fakeDownload) setStatus(status Status) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.status = status
+}
+
+func (self *fakeDownload) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	info := map[string]interface{}{"gid": self.gid, "status": string(self.status), "completedLength": "0", "totalLength": "1048576"}
+	switch method {
+	case "aria2.tellActive":
+		if self.status == StatusActive {
+			return []interface{}{info}, nil
+		}
+		return []interface{}{}, nil
+	case "aria2.tellStatus":
+		if params[0] != self.gid {
+			return nil, &ErrorMsg{Code: 1, Message: "GID " + params[0].(string) + " is not found"}
+		}
+		return info, nil
+	case "aria2.forcePause":
+		self.status = StatusPaused
+		return self.gid, nil
+	case "aria2.unpause":
+		self.status = StatusWaiting
+		return self.gid, nil
+	case "aria2.getServers":
+		return []interface{}{map[string]interface{}{"index": "1", "servers": []interface{}{
+			map[string]interface{}{"uri": "http://a/f", "currentUri": "http://a/f", "downloadSpeed": "0"},
+		}}}, nil
+	}
+	return versionHandler(method, params)
+}
+
+func TestWatchdogKeepsAttemptsWhileQueued(t *testing.T) {
+	download := &fakeDownload{gid: "2089b05ecca3d829", status: StatusActive}
+	fake := newFakeAria2(t, download.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	clock := NewManualClock(friday(12, 0, time.UTC))
+	watchdog := NewWatchdog(client)
+	watchdog.Clock = clock
+	watchdog.StallAfter = time.Minute
+	watchdog.Policies = []StallPolicy{StallRestart, StallDropSlowUris}
+	var events []WatchdogEvent
+	watchdog.OnEvent = func(event WatchdogEvent) {
+		if event.Err != nil {
+			t.Errorf("%s: %v", event.Policy, event.Err)
+		}
+		events = append(events, event)
+	}
+	check := func(advance time.Duration) {
+		t.Helper()
+		clock.Advance(advance)
+		if err := watchdog.Check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(0)
+	check(61 * time.Second)
+	if len(events) != 2 || events[1].Type != WatchdogAction || events[1].Policy != StallRestart {
+		t.Fatalf("expected stalled and restart, got %+v", events)
+	}
+	// restart之后下载在等待队列里 不能忘掉已经执行过的Policy 排队的时间也不算停滞
+	check(10 * time.Minute)
+	if len(watchdog.downloads) != 1 {
+		t.Fatal("queued download was forgotten")
+	}
+	download.setStatus(StatusActive)
+	check(30 * time.Second)
+	if len(events) != 2 {
+		t.Fatalf("time spent queued counted as stalled: %+v", events[2:])
+	}
+	check(2 * time.Minute)
+	if len(events) != 3 || events[2].Policy != StallDropSlowUris {
+		t.Fatalf("expected escalation to drop-slow-uris, got %+v", events)
+	}
+
+	// 结束之后才忘掉
+	download.setStatus(StatusComplete)
+	check(time.Minute)
+	if len(watchdog.downloads) != 0 {
+		t.Fatal("finished download still watched")
+	}
+}
+
+// fakeResubmit 每次forceRemove后addUri都会得到新的GID 新下载的completedLength从0开始并且一直停滞
+type fakeResubmit struct {
+	lock      sync.Mutex
+	current   string
+	completed string
+	next      int
+}
+
+func (self *fakeResubmit) gid() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.current
+}
+
+func (self *fakeResubmit) handle(method string, params []interface{}) (interface{}, *ErrorMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	status := func(gid string) map[string]interface{} {
+		state := "removed"
+		if gid == self.current {
+			state = "active"
+		}
+		return map[string]interface{}{
+			"gid": gid, "status": state, "completedLength": self.completed, "totalLength": "1048576",
+			"files": []interface{}{map[string]interface{}{"index": "1", "uris": []interface{}{map[string]interface{}{"uri": "http://a/f", "status": "used"}}}},
+		}
+	}
+	switch method {
+	case "aria2.tellActive":
+		return []interface{}{status(self.current)}, nil
+	case "aria2.tellStatus":
+		return status(params[0].(string)), nil
+	case "aria2.getOption":
+		return map[string]interface{}{"dir": "/tmp"}, nil
+	case "aria2.forceRemove":
+		self.current = ""
+		return params[0], nil
+	case "aria2.removeDownloadResult":
+		return "OK", nil
+	case "aria2.addUri":
+		self.next++
+		self.current = fmt.Sprintf("%016x", self.next)
+		self.completed = "0"
+		return self.current, nil
+	}
+	return versionHandler(method, params)
+}
+
+func TestWatchdogResubmitKeepsAttempts(t *testing.T) {
+	download := &fakeResubmit{current: "2089b05ecca3d829", completed: "5000"}
+	fake := newFakeAria2(t, download.handle)
+	client := NewAria2Client(fake.RpcUrl(false), nil, nil, nil, nil, NewNetHttpRequestHandler(nil))
+	clock := NewManualClock(friday(12, 0, time.UTC))
+	watchdog := NewWatchdog(client)
+	watchdog.Clock = clock
+	watchdog.StallAfter = time.Minute
+	watchdog.Policies = []StallPolicy{StallResubmit, StallResubmit}
+	var events []WatchdogEvent
+	watchdog.OnEvent = func(event WatchdogEvent) {
+		if event.Err != nil {
+			t.Errorf("%s: %v", event.Policy, event.Err)
+		}
+		if event.Type == WatchdogRecovered {
+			t.Errorf("false recovery of %s after resubmit", event.Gid)
+		}
+		events = append(events, event)
+	}
+	check := func(advance time.Duration) {
+		t.Helper()
+		clock.Advance(advance)
+		if err := watchdog.Check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempts := func() int {
+		watchdog.lock.Lock()
+		defer watchdog.lock.Unlock()
+		watched, ok := watchdog.downloads[download.gid()]
+		if !ok {
+			t.Fatalf("%s not watched", download.gid())
+		}
+		return watched.attempts
+	}
+
+	check(0)
+	check(61 * time.Second)
+	if n := attempts(); n != 1 {
+		t.Fatalf("attempts after first resubmit = %d", n)
+	}
+	// 新下载的completedLength从5000变成0 不算进展
+	check(10 * time.Second)
+	check(61 * time.Second)
+	if n := attempts(); n != 2 {
+		t.Fatalf("attempts after second resubmit = %d", n)
+	}
+	check(10 * time.Second)
+	check(61 * time.Second)
+	last := events[len(events)-1]
+	if last.Type != WatchdogGaveUp || last.Gid != download.gid() {
+		t.Fatalf("expected gave-up for %s, got %+v", download.gid(), events)
+	}
+	actions := 0
+	for _, event := range events {
+		if event.Type == WatchdogAction {
+			actions++
+		}
+	}
+	if actions != 2 {
+		t.Fatalf("expected 2 resubmits, got %d", actions)
+	}
+}
@@ -29,6 +29,10 @@ func main() {
 	wshandler := aria2.NewWebsocketRequestHandler()
 	client2 := aria2.NewAria2Client("ws://"+url,
 		nil, nil, &token, nil, wshandler)
+	if e := client2.StartErr(); e != nil {
+		fmt.Println(e.Error())
+		return
+	}
 	finish := make(chan bool)
 	client2.OnDownloadStart(func(client *aria2.Aria2Client, data aria2.RpcRequest) {
 		b, e := json.Marshal(data)